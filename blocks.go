@@ -0,0 +1,380 @@
+// blocks.go will hold the entries of which dot only manages a block inside
+// the target, instead of the whole file. The block is delimited by markers
+// with the name of the entry:
+//
+//	# BEGIN dot:[name]
+//	...
+//	# END dot:[name]
+//
+// The content of the block is the file of the entry in the files folder.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultBlockComment is the prefix of the markers of a block, when the
+// entry doesn't specify one
+const DefaultBlockComment = "#"
+
+// blockMarkers will return the lines that start and end the block of the
+// entry `name` in the target
+func blockMarkers(name string, e *Entry) (string, string) {
+	comment := e.Comment
+	if comment == "" {
+		comment = DefaultBlockComment
+	}
+
+	return fmt.Sprintf("%s BEGIN dot:%s", comment, name),
+		fmt.Sprintf("%s END dot:%s", comment, name)
+}
+
+// findBlock will return the index of the first and the last line of the
+// block that starts with `begin` and ends with `end` in `lines`. It returns
+// -1 when there is no such block, an error is returned when the block
+// doesn't end.
+func findBlock(lines []string, begin, end string) (int, int, error) {
+	for i, line := range lines {
+		if strings.TrimRight(line, " \t\r") != begin {
+			continue
+		}
+
+		for j := i + 1; j < len(lines); j++ {
+			if strings.TrimRight(lines[j], " \t\r") == end {
+				return i, j, nil
+			}
+		}
+
+		return -1, -1, fmt.Errorf("the block '%s' doesn't end with '%s'", begin, end)
+	}
+
+	return -1, -1, nil
+}
+
+// blockLines will split `content` into lines, without the line endings
+func blockLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// UpdateBlock will return `content` with the block of the entry `name` set
+// to `block`. An existing block is replaced in place, otherwise the block
+// is appended. Updating content that holds the block already returns the
+// same content.
+func UpdateBlock(content, name string, e *Entry, block string) (string, error) {
+	begin, end := blockMarkers(name, e)
+	lines := blockLines(content)
+
+	first, last, err := findBlock(lines, begin, end)
+	if err != nil {
+		return "", err
+	}
+
+	wrapped := append(append([]string{begin}, blockLines(block)...), end)
+	if first < 0 {
+		lines = append(lines, wrapped...)
+	} else {
+		lines = append(lines[:first], append(wrapped, lines[last+1:]...)...)
+	}
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// RemoveBlock will return `content` without the block of the entry `name`,
+// content without the block is returned as it is.
+func RemoveBlock(content, name string, e *Entry) (string, error) {
+	begin, end := blockMarkers(name, e)
+	lines := blockLines(content)
+
+	first, last, err := findBlock(lines, begin, end)
+	if err != nil || first < 0 {
+		return content, err
+	}
+
+	lines = append(lines[:first], lines[last+1:]...)
+	if len(lines) == 0 {
+		return "", nil
+	}
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// ExtractBlock will return the content of the block of the entry `name` in
+// `content`, it reports whether the block is present.
+func ExtractBlock(content, name string, e *Entry) (string, bool, error) {
+	begin, end := blockMarkers(name, e)
+	lines := blockLines(content)
+
+	first, last, err := findBlock(lines, begin, end)
+	if err != nil || first < 0 {
+		return "", false, err
+	}
+
+	block := lines[first+1 : last]
+	if len(block) == 0 {
+		return "", true, nil
+	}
+
+	return strings.Join(block, "\n") + "\n", true, nil
+}
+
+// blockTarget will return the file the block of `p` is written to. The
+// symlinks of the target are followed, so the block ends up in the file
+// they point to, unless that file is inside the repository.
+func blockTarget(c *Config, p EntryPaths) (string, error) {
+	f, err := os.Lstat(p.Target)
+	if os.IsNotExist(err) {
+		return p.Target, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if f.Mode()&os.ModeSymlink != 0 {
+		if c.InsideRepo(p.Target) {
+			return "", fmt.Errorf("%s is symlinked into the repository", p.Target)
+		}
+
+		resolved, err := filepath.EvalSymlinks(p.Target)
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("%s is a broken symlink", p.Target)
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if f, err = os.Stat(resolved); err != nil {
+			return "", err
+		}
+		p.Target = resolved
+	}
+
+	if f.IsDir() {
+		return "", fmt.Errorf("%s is a folder, not a file", p.Target)
+	}
+
+	return p.Target, nil
+}
+
+// readBlockTarget will return the file the block of `p` is written to, its
+// content and its mode. The content is empty when the file doesn't exist.
+func readBlockTarget(c *Config, p EntryPaths) (string, string, os.FileMode, error) {
+	target, err := blockTarget(c, p)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	data, err := ioutil.ReadFile(target)
+	if os.IsNotExist(err) {
+		return target, "", 0644, nil
+	}
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	f, err := os.Stat(target)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	return target, string(data), f.Mode().Perm(), nil
+}
+
+// planBlock will determine whether the block of the entry of `s` has to be
+// updated, see PlanEntry.
+func planBlock(s *Step) {
+	p := EntryPaths{Target: s.Target, Repo: s.Repo, Backup: s.Backup}
+
+	block, err := ioutil.ReadFile(s.Repo)
+	if err != nil {
+		s.Action = ActionSkip
+		s.Reason = fmt.Sprintf("the content of the block isn't present in %s", s.Repo)
+		return
+	}
+
+	_, content, _, err := readBlockTarget(s.config, p)
+	if err != nil {
+		s.Action = ActionSkip
+		s.Reason = err.Error()
+		return
+	}
+
+	updated, err := UpdateBlock(content, s.Name, s.Entry, string(block))
+	if err != nil {
+		s.Action = ActionSkip
+		s.Reason = err.Error()
+		return
+	}
+
+	s.Action = ActionNone
+	if updated != content {
+		s.Action = ActionUpdateBlock
+	}
+}
+
+// updateBlock will insert or update the block in the target. The first time
+// the block is added to an existing file, the file is copied to the backup
+// folder.
+func (s *Step) updateBlock() error {
+	p := EntryPaths{Target: s.Target, Repo: s.Repo, Backup: s.Backup}
+
+	block, err := ioutil.ReadFile(s.Repo)
+	if err != nil {
+		return err
+	}
+
+	target, content, mode, err := readBlockTarget(s.config, p)
+	if err != nil {
+		return err
+	}
+
+	_, present, err := ExtractBlock(content, s.Name, s.Entry)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Lstat(s.Backup); !present && content != "" && os.IsNotExist(err) {
+		err = os.MkdirAll(filepath.Dir(s.Backup), 0755)
+		if err != nil {
+			return err
+		}
+
+		err = CopyFile(target, s.Backup)
+		if err != nil {
+			return fmt.Errorf("not able to copy %s to %s (%s)", target, s.Backup, err)
+		}
+	}
+
+	updated, err := UpdateBlock(content, s.Name, s.Entry, string(block))
+	if err != nil || updated == content {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(target, []byte(updated), mode)
+}
+
+// removeBlock will remove the block of the entry `name` from its target,
+// the rest of the target is left untouched.
+func removeBlock(c *Config, name string, p EntryPaths) error {
+	target, content, mode, err := readBlockTarget(c, p)
+	if err != nil || content == "" {
+		return err
+	}
+
+	updated, err := RemoveBlock(content, name, c.Files[name])
+	if err != nil || updated == content {
+		return err
+	}
+
+	PrintBody(fmt.Sprintf("Removing the block of %s from %s", name, target))
+	return WriteFileAtomic(target, []byte(updated), mode)
+}
+
+// trackBlock will track the entry `e` of which dot manages a block in the
+// target. The content of the block is taken from the block that is present
+// in the target already, otherwise it starts out empty.
+func trackBlock(c *Config, name string, e *Entry) error {
+	p := c.LayerPaths(e.layer, name, e.Path)
+
+	err := checkBlockOverlap(c, name, p.Target)
+	if err != nil {
+		return err
+	}
+
+	_, content, _, err := readBlockTarget(c, p)
+	if err != nil {
+		return err
+	}
+
+	block, _, err := ExtractBlock(content, name, e)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Lstat(p.Repo); err == nil {
+		return fmt.Errorf("%s is already present", p.Repo)
+	}
+
+	err = os.MkdirAll(p.RepoDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	PrintBody(fmt.Sprintf("Creating the content of the block: %s", p.Repo))
+	return ioutil.WriteFile(p.Repo, []byte(block), 0644)
+}
+
+// checkBlockOverlap will return an error when the block of the entry `name`
+// can't be managed in `target`, because it is inside the repository or
+// inside another entry. The blocks of several entries can share a target.
+func checkBlockOverlap(c *Config, name, target string) error {
+	if _, ok := c.Files[name]; ok {
+		return fmt.Errorf("'%s' is already being tracked", name)
+	}
+
+	if root := c.insideRoot(target); root != "" {
+		return fmt.Errorf("%s is located inside the dot repository %s", target, root)
+	}
+
+	for _, o := range c.Overlaps(name, target) {
+		if o.Kind == OverlapSame && c.Files[o.Name].Mode == EntryModeBlock {
+			continue
+		}
+
+		return fmt.Errorf("not able to manage a block in %s, it %s", target, o)
+	}
+
+	return nil
+}
+
+// adoptBlock will replace the content of the block of the entry `name` in
+// the repository with the block in its target.
+func adoptBlock(c *Config, name string) error {
+	p := c.Paths(name)
+
+	_, content, _, err := readBlockTarget(c, p)
+	if err != nil {
+		return err
+	}
+
+	block, present, err := ExtractBlock(content, name, c.Files[name])
+	if err != nil {
+		return err
+	}
+	if !present {
+		return fmt.Errorf("the block of %s isn't present in %s, use `dot sync` instead", name, p.Target)
+	}
+
+	PrintBody(fmt.Sprintf("Adopting the block of %s from %s", name, p.Target))
+	return WriteFileAtomic(p.Repo, []byte(block), 0644)
+}
+
+// extractBlockFile will write the block of the entry `name` in its target
+// to `dst`, and return `dst`. When the block isn't present nothing is
+// written, so `dst` doesn't exist.
+func extractBlockFile(c *Config, name string, p EntryPaths, dst string) (string, error) {
+	_, content, _, err := readBlockTarget(c, p)
+	if err != nil {
+		return "", err
+	}
+
+	block, present, err := ExtractBlock(content, name, c.Files[name])
+	if err != nil || !present {
+		return dst, err
+	}
+
+	return dst, ioutil.WriteFile(dst, []byte(block), 0600)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdateBlock(t *testing.T) {
+	e := &Entry{}
+	block := "alias ll='ls -l'\n"
+
+	tests := []struct {
+		content  string
+		expected string
+	}{
+		{"", "# BEGIN dot:aliases\nalias ll='ls -l'\n# END dot:aliases\n"},
+		{"export A=1", "export A=1\n# BEGIN dot:aliases\nalias ll='ls -l'\n# END dot:aliases\n"},
+		{
+			"a\n# BEGIN dot:aliases\nold\nlines\n# END dot:aliases\nb\n",
+			"a\n# BEGIN dot:aliases\nalias ll='ls -l'\n# END dot:aliases\nb\n",
+		},
+		{
+			"# BEGIN dot:other\nx\n# END dot:other\n",
+			"# BEGIN dot:other\nx\n# END dot:other\n# BEGIN dot:aliases\nalias ll='ls -l'\n# END dot:aliases\n",
+		},
+	}
+
+	for _, test := range tests {
+		updated, err := UpdateBlock(test.content, "aliases", e, block)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if updated != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, updated)
+		}
+
+		// updating it again doesn't change anything
+		if again, _ := UpdateBlock(updated, "aliases", e, block); again != updated {
+			t.Errorf("expected the update to be idempotent, got %q", again)
+		}
+
+		if extracted, ok, _ := ExtractBlock(updated, "aliases", e); !ok || extracted != block {
+			t.Errorf("expected the block %q, got %q", block, extracted)
+		}
+	}
+
+	if _, err := UpdateBlock("# BEGIN dot:aliases\nx\n", "aliases", e, block); err == nil {
+		t.Error("expected an error for a block that doesn't end")
+	}
+
+	vim := &Entry{Comment: `"`}
+	updated, _ := UpdateBlock("", "vim", vim, "set nu\n")
+	if updated != "\" BEGIN dot:vim\nset nu\n\" END dot:vim\n" {
+		t.Errorf("expected the markers to use the comment of the entry, got %q", updated)
+	}
+}
+
+func TestRemoveBlock(t *testing.T) {
+	e := &Entry{}
+
+	content := "a\n# BEGIN dot:aliases\nx\n# END dot:aliases\nb\n"
+	if removed, err := RemoveBlock(content, "aliases", e); err != nil || removed != "a\nb\n" {
+		t.Errorf("expected the block to be removed, got %q (%v)", removed, err)
+	}
+
+	if removed, _ := RemoveBlock("a", "aliases", e); removed != "a" {
+		t.Errorf("expected content without the block to be left untouched, got %q", removed)
+	}
+
+	if removed, _ := RemoveBlock("# BEGIN dot:aliases\n# END dot:aliases\n", "aliases", e); removed != "" {
+		t.Errorf("expected nothing to be left, got %q", removed)
+	}
+}
+
+func TestSyncBlock(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	target := filepath.Join(tempDir, "home", ".bashrc")
+	writeTestFile(t, target, "export A=1\n")
+	writeTestFile(t, filepath.Join(c.Root(), "files", "aliases", ".bashrc"), "alias g=git\n")
+	writeTestFile(t, filepath.Join(c.Root(), "files", "paths", ".bashrc"), "export PATH=~/bin:$PATH\n")
+	c.Files["aliases"] = &Entry{Path: target, Mode: EntryModeBlock}
+	c.Files["paths"] = &Entry{Path: target, Mode: EntryModeBlock}
+
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Errorf("expected blocks to share a target, got %v", errs)
+	}
+
+	plan, err := PlanSync(c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range plan.Steps {
+		if step.Action != ActionUpdateBlock {
+			t.Errorf("expected the block of %s to be updated, got %s", step.Name, step.Action)
+		}
+	}
+
+	if plan.Execute(ioutil.Discard, 4) > 0 {
+		t.Fatal("expected the plan to succeed")
+	}
+
+	expected := "export A=1\n# BEGIN dot:aliases\nalias g=git\n# END dot:aliases\n" +
+		"# BEGIN dot:paths\nexport PATH=~/bin:$PATH\n# END dot:paths\n"
+	if data, _ := ioutil.ReadFile(target); string(data) != expected {
+		t.Errorf("expected %q, got %q", expected, data)
+	}
+
+	if data, _ := ioutil.ReadFile(filepath.Join(c.Root(), "backup", "aliases", ".bashrc")); string(data) != "export A=1\n" {
+		t.Errorf("expected a backup of the original, got %q", data)
+	}
+
+	plan, _ = PlanSync(c, true)
+	for _, step := range plan.Steps {
+		if step.Action != ActionNone {
+			t.Errorf("expected the block of %s to be up to date, got %s", step.Name, step.Action)
+		}
+	}
+
+	defer useTestConfig(t, c)()
+	if err := UntrackFiles([]string{"aliases"}, UntrackOptions{Mode: UntrackUnlink}); err == nil {
+		t.Error("expected an error for keeping a block in the repository")
+	}
+
+	if err := UntrackFiles([]string{"aliases"}, UntrackOptions{Yes: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected = "export A=1\n# BEGIN dot:paths\nexport PATH=~/bin:$PATH\n# END dot:paths\n"
+	if data, _ := ioutil.ReadFile(target); string(data) != expected {
+		t.Errorf("expected only the block of aliases to be removed, got %q", data)
+	}
+
+	if _, err := os.Stat(filepath.Join(c.Root(), "files", "aliases")); !os.IsNotExist(err) {
+		t.Error("expected the block to be removed from the repository")
+	}
+}
@@ -4,15 +4,17 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"text/tabwriter"
 )
 
 // CommandSync will do several things depending configuration:
 //  1. Sync files when there is a .dotconfig present in the correct location
 //  2. Create a .dotconfig in the correct location when it isn't
-//  3. Create a new setup of dot, including a .dotconfig, files and backup
-//     folders
-func CommandSync() {
+//
+// CommandSync will never create a new dot repository, use CommandInit for
+// that.
+func CommandSync(opts SyncOptions) {
 	// get current working directory
 	currentWorkingDir, err := os.Getwd()
 	if err != nil {
@@ -32,14 +34,16 @@ func CommandSync() {
 
 		// .dotconfig (symlink) found in home dir => SyncFiles
 		PrintBody("The .dotconfig file is present")
+		defer lockDotRepo()()
 
 		// relink everything
-		SyncFiles()
+		SyncFiles(opts)
 
 	} else if _, err := os.Stat(HomeDir() + "/" + ConfigFileName); err == nil {
 		// .dotconfig (regular file, not symlinked) found in home dir =>
 		// symlink .dotconfig
 		PrintBody("Found .dotconfig file in home folder")
+		defer lockOrExit(currentWorkingDir)()
 
 		// make sure .dotconfig is present in DotPath
 		if _, err := os.Stat(pathDotConfigCwd); err != nil {
@@ -65,12 +69,13 @@ func CommandSync() {
 		}
 
 		// relink everything
-		SyncFiles()
+		SyncFiles(opts)
 	} else if _, err := os.Stat(pathDotConfigCwd); err == nil {
 
 		// .dotconfig not found in home dir,
 		// .dotconfig found in current working dir => symlink .dotconfig
 		PrintBody("Found .dotconfig file in repository folder")
+		defer lockOrExit(currentWorkingDir)()
 
 		// make a symlink for .dotconfig file
 		dotconfigOld := fmt.Sprintf("%s/files/dotconfig/%s",
@@ -84,42 +89,250 @@ func CommandSync() {
 		}
 
 		// relink everything
-		SyncFiles()
+		SyncFiles(opts)
 	} else {
 
 		// .dotconfig not found in home dir,
-		// .dotconfig not found in current working dir => new setup
-		PrintBody("Couldn't find the .dotconfig file, do you want to create a new one? [Y/N]")
+		// .dotconfig not found in current working dir => nothing to sync
+		PrintBodyError("couldn't find a .dotconfig file. Create a new dot " +
+			"repository with `dot init [dir]`")
+	}
+}
+
+// CommandInit will create a new dot repository in `dir`, when `dir` is empty
+// the current working directory will be used. When `git` is set a git
+// repository will be initialized as well.
+func CommandInit(dir string, git bool) {
+	PrintHeader("Initializing new dot repository ...")
+
+	// make sure we don't overwrite an existing setup
+	if _, err := os.Lstat(PathDotConfig); err == nil {
+		PrintBodyError(fmt.Sprintf("%s is already present, use `dot sync` "+
+			"to sync the files that are being tracked", PathDotConfig))
+		return
+	}
 
-		// get input
-		var input string
-		_, err := fmt.Scan(&input)
+	if dir == "" {
+		currentWorkingDir, err := os.Getwd()
 		if err != nil {
 			log.Fatal(err)
 		}
+		dir = currentWorkingDir
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		PrintBodyError(err.Error())
+		return
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		PrintBodyError(err.Error())
+		return
+	}
+	defer lockOrExit(dir)()
+
+	err = SetupInitialMachine(PathDotConfig, dir, git)
+	if err != nil {
+		PrintBodyError(err.Error())
+		return
+	}
+
+	PrintBody("You're now ready to use dot! Type 'dot -help' for help")
+}
+
+// CommandAdd will add the files or folders `paths` for tracking, with the
+// settings of `e`. The name of an entry is derived from its path, unless
+// `name` is set, which is only possible for a single path. Files and
+// folders outside the home folder can only be added when `allowSystem` is
+// set. The include and exclude glob patterns of `e` decide which paths
+// inside a folder will be tracked.
+func CommandAdd(paths []string, name string, e *Entry, push, force, allowSystem bool) {
+	PrintHeader("Adding new entry for tracking ...")
+	defer lockDotRepo()()
+
+	if name != "" && len(paths) > 1 {
+		PrintBodyError("a name can only be given when adding a single path")
+		return
+	}
+
+	if _, err := NewIgnore(e.Include, e.Exclude); err != nil {
+		PrintBodyError(err.Error())
+		return
+	}
 
-		if input == "y" || input == "Y" {
-			// setup initial machine
-			// create new .dotconfig file
-			SetupInitialMachine(PathDotConfig)
+	names := make([]string, len(paths))
+	entries := make([]*Entry, len(paths))
+	for i, path := range paths {
+		fullPath, err := filepath.Abs(path)
+		if err != nil {
+			PrintBodyError(err.Error())
+			return
+		}
 
-			PrintBody("You're now ready to use dot! Type 'dot -help' for help")
-		} else {
+		if err := CheckTarget(fullPath, allowSystem); err != nil {
+			PrintBodyError(err.Error())
 			return
 		}
+
+		names[i] = name
+		entries[i] = &Entry{
+			Path:    fullPath,
+			Include: e.Include,
+			Exclude: e.Exclude,
+			Order:   e.Order,
+			After:   e.After,
+			Mode:    e.Mode,
+			Format:  e.Format,
+			Comment: e.Comment,
+			layer:   e.layer,
+		}
+	}
+
+	err := TrackFiles(names, entries, push)
+	if err != nil {
+		PrintBodyError(err.Error())
 	}
 }
 
-// CommandAdd will add a file or folder for tracking.
-func CommandAdd(name, path string, push, force bool) {
-	PrintHeader("Adding new entry for tracking ...")
-	_ = TrackFile(name, path, push, false)
+// CommandAddSystem will add a file outside the home folder as system entry,
+// it will be installed as a copy with the given `owner`, `group` and `mode`
+// when syncing.
+func CommandAddSystem(name, path, owner, group, mode string, push bool) {
+	PrintHeader("Adding new system entry for tracking ...")
+	defer lockDotRepo()()
+
+	fullPath, err := filepath.Abs(path)
+	if err != nil {
+		PrintBodyError(err.Error())
+		return
+	}
+
+	err = TrackSystemFile(name, fullPath, owner, group, mode)
+	if err != nil {
+		PrintBodyError(err.Error())
+		return
+	}
+
+	// push changes to repository
+	if push {
+		GitCommitPush("", name, "add")
+	}
+}
+
+// CommandMove will rename the entry `name` to `newName` and/or move its
+// target to `newPath`. Targets outside the home folder are only allowed when
+// `allowSystem` is set.
+func CommandMove(name, newName, newPath string, push, allowSystem bool) {
+	PrintHeader("Moving entry ...")
+	defer lockDotRepo()()
+
+	err := MoveFile(name, newName, newPath, allowSystem)
+	if err != nil {
+		PrintBodyError(err.Error())
+		return
+	}
+
+	// push changes to repository
+	if push {
+		if newName == "" {
+			newName = name
+		}
+
+		// the entry is committed to the repository of its layer
+		config, err := NewConfig(PathDotConfig)
+		if err != nil {
+			PrintBodyError("not able to find .dotconfig")
+			return
+		}
+		PushEntries(config, []string{newName}, []string{config.Files[newName].layer}, "mv")
+	}
 }
 
-// CommandRemove will remove a file from tracking.
-func CommandRemove(name string, push bool) {
+// CommandRemove will remove the entries that match `patterns` from
+// tracking, a pattern is either the name of an entry or a glob pattern.
+// What happens with their files is decided by the mode of `opts`.
+func CommandRemove(patterns []string, opts UntrackOptions) {
 	PrintHeader("Removing entry from tracking ...")
-	UntrackFile(name, push)
+	defer lockDotRepo()()
+
+	err := UntrackFiles(patterns, opts)
+	if err != nil {
+		PrintBodyError(err.Error())
+	}
+}
+
+// CommandAdopt will replace the version of the entry `name` in the
+// repository with the version on the system, and symlink it again. This is
+// used when the symlink was replaced by a regular file, e.g. by an editor.
+func CommandAdopt(name string) {
+	PrintHeader("Adopting entry ...")
+	defer lockDotRepo()()
+
+	err := AdoptFile(name)
+	if err != nil {
+		PrintBodyError(err.Error())
+	}
+}
+
+// CommandCollect will replace the values of the keys of the merged entries
+// `names`, or of all merged entries when empty, in the repository with the
+// values that were changed in their targets, e.g. by the application.
+func CommandCollect(names []string, dryRun, push bool) {
+	PrintHeader("Collecting the keys of merged entries ...")
+	defer lockDotRepo()()
+
+	config, err := NewConfig(PathDotConfig)
+	if err != nil {
+		PrintBodyError("not able to find .dotconfig")
+		return
+	}
+
+	changed, err := CollectEntries(os.Stdout, config, names, dryRun)
+	if err != nil {
+		PrintBodyError(err.Error())
+	}
+
+	if push && !dryRun && len(changed) > 0 {
+		var layers []string
+		for _, name := range changed {
+			layers = append(layers, config.Files[name].layer)
+		}
+		PushEntries(config, changed, layers, "collect")
+	}
+}
+
+// CommandDiff will show the differences between the targets of the entries
+// `names`, or all entries when empty, and the repository.
+func CommandDiff(names []string, opts DiffOptions) {
+	config, err := NewConfig(PathDotConfig)
+	if err != nil {
+		PrintBodyError("not able to find .dotconfig")
+		return
+	}
+
+	_, err = DiffEntries(os.Stdout, config, names, opts)
+	if err != nil {
+		PrintBodyError(err.Error())
+	}
+}
+
+// CommandStatus will show the state of the entries `names`, or all entries
+// when empty, and the keys of the merged entries that drifted.
+func CommandStatus(names []string) {
+	PrintHeader("Following is the state of the tracked entries ...")
+
+	config, err := NewConfig(PathDotConfig)
+	if err != nil {
+		PrintBodyError("not able to find .dotconfig")
+		return
+	}
+
+	_, err = StatusEntries(os.Stdout, config, names)
+	if err != nil {
+		PrintBodyError(err.Error())
+	}
 }
 
 // CommandList will output the list of files that are being tracked by dot.
@@ -127,14 +340,14 @@ func CommandList() {
 	PrintHeader("Following files are being tracked by dot ...")
 
 	// open config file
-	config, err := NewConfig(HomeDir() + "/" + ConfigFileName)
+	config, err := NewConfig(PathDotConfig)
 	if err != nil {
 		PrintBodyError("not able to find .dotconfig")
 		return
 	}
 
 	// check if there is anything to display
-	if len(config.Files) == 0 {
+	if len(config.Files) == 0 && len(config.System) == 0 {
 		PrintBodyError(
 			"there are no files being tracked. Begin doing so, with `dot add -name [name] -path [path]`",
 		)
@@ -144,10 +357,272 @@ func CommandList() {
 	// print out the tracked files
 	w := new(tabwriter.Writer)
 	w.Init(os.Stdout, 0, 8, 0, '\t', 0)
-	fmt.Fprintln(w, "name\tpath")
-	for name, path := range config.Files {
-		line := fmt.Sprintf("%s\t%s%s", name, HomeDir(), path)
+	// the layer of the entries is only shown when there are layers
+	layered := len(config.Layers) > 0
+	if layered {
+		fmt.Fprintln(w, "name\tlayer\tpath")
+	} else {
+		fmt.Fprintln(w, "name\tpath")
+	}
+	for _, name := range config.SortedNames() {
+		line := fmt.Sprintf("%s\t%s", name, config.TargetPath(name))
+		if layered {
+			line = fmt.Sprintf("%s\t%s\t%s", name, config.Files[name].Layer(), config.TargetPath(name))
+		}
+		switch config.Files[name].Mode {
+		case EntryModeBlock:
+			line += " (block)"
+		case EntryModeFragments:
+			line += " (fragments)"
+		case EntryModeMerge:
+			line += " (merge)"
+		}
+		if config.Files[name].layer == LocalLayer {
+			line += " (local)"
+		}
+		fmt.Fprintln(w, line)
+	}
+	for _, name := range config.SortedSystemNames() {
+		e := config.System[name]
+		line := fmt.Sprintf("%s\t%s (system)", name, e.Path)
+		if layered {
+			line = fmt.Sprintf("%s\t%s\t%s (system)", name, MainLayer, e.Path)
+		}
 		fmt.Fprintln(w, line)
 	}
 	w.Flush()
 }
+
+// CommandConfigValidate will check the config file for problems, like
+// overlapping entries, it returns false when a problem was found.
+func CommandConfigValidate() bool {
+	PrintHeader("Validating .dotconfig ...")
+
+	config, err := NewConfig(PathDotConfig)
+	if err != nil {
+		PrintBodyError("not able to find .dotconfig")
+		return false
+	}
+
+	errs := config.Validate()
+	for _, err := range errs {
+		PrintBodyError(err.Error())
+	}
+
+	if len(errs) > 0 {
+		return false
+	}
+
+	PrintBody("No problems found")
+	return true
+}
+
+// CommandScriptsList will list the scripts in the repository, and whether
+// they have run on this machine.
+func CommandScriptsList() {
+	PrintHeader("Following scripts are in the repository ...")
+
+	config, err := NewConfig(PathDotConfig)
+	if err != nil {
+		PrintBodyError("not able to find .dotconfig")
+		return
+	}
+
+	scripts, err := FindScripts(config.Root())
+	if err != nil {
+		PrintBodyError(err.Error())
+		return
+	}
+
+	if len(scripts) == 0 {
+		PrintBodyError(fmt.Sprintf("there are no scripts. Add them to the %s folder "+
+			"with a name that starts with %s or %s", ScriptsFolder, ScriptOncePrefix,
+			ScriptOnChangePrefix))
+		return
+	}
+
+	state, err := LoadScriptState(ScriptStatePath())
+	if err != nil {
+		PrintBodyError(err.Error())
+		return
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 0, '\t', 0)
+	fmt.Fprintln(w, "name\truns\tstatus")
+	for _, script := range scripts {
+		runs := "on change"
+		if script.Once {
+			runs = "once"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", script.Name, runs, state.Status(script))
+	}
+	w.Flush()
+}
+
+// CommandScriptsRun will run the scripts `names`, even when they already
+// ran on this machine. Without names the pending scripts are run.
+func CommandScriptsRun(names []string, dryRun bool) {
+	defer lockDotRepo()()
+
+	config, err := NewConfig(PathDotConfig)
+	if err != nil {
+		PrintBodyError("not able to find .dotconfig")
+		return
+	}
+
+	if len(names) == 0 {
+		err = SyncScripts(config, dryRun)
+		if err != nil {
+			PrintBodyError(err.Error())
+		}
+		return
+	}
+
+	PrintHeader("Running scripts ...")
+
+	scripts, err := FindScripts(config.Root())
+	if err != nil {
+		PrintBodyError(err.Error())
+		return
+	}
+
+	scripts, err = MatchScripts(scripts, names)
+	if err != nil {
+		PrintBodyError(err.Error())
+		return
+	}
+
+	state, err := LoadScriptState(ScriptStatePath())
+	if err != nil {
+		PrintBodyError(err.Error())
+		return
+	}
+
+	hooks, err := NewHookRunner(config, dryRun)
+	if err != nil {
+		PrintBodyError(err.Error())
+		return
+	}
+
+	RunScripts(os.Stdout, scripts, state, hooks)
+}
+
+// CommandScriptsReset will forget that the scripts `names` ran on this
+// machine, so they will run again. Without names every script is reset.
+func CommandScriptsReset(names []string) {
+	PrintHeader("Resetting scripts ...")
+	defer lockDotRepo()()
+
+	config, err := NewConfig(PathDotConfig)
+	if err != nil {
+		PrintBodyError("not able to find .dotconfig")
+		return
+	}
+
+	scripts, err := FindScripts(config.Root())
+	if err != nil {
+		PrintBodyError(err.Error())
+		return
+	}
+
+	if len(names) > 0 {
+		scripts, err = MatchScripts(scripts, names)
+		if err != nil {
+			PrintBodyError(err.Error())
+			return
+		}
+	}
+
+	state, err := LoadScriptState(ScriptStatePath())
+	if err != nil {
+		PrintBodyError(err.Error())
+		return
+	}
+
+	for _, script := range scripts {
+		if _, ok := state.Scripts[script.Path]; ok {
+			PrintBody(fmt.Sprintf("%s will run again", script.Name))
+			delete(state.Scripts, script.Path)
+		}
+	}
+
+	err = state.Save()
+	if err != nil {
+		PrintBodyError(err.Error())
+	}
+}
+
+// CommandPackagesStatus will show which packages of the package manifest
+// are installed on this machine.
+func CommandPackagesStatus() {
+	PrintHeader("Following packages are in the manifest ...")
+
+	config, err := NewConfig(PathDotConfig)
+	if err != nil {
+		PrintBodyError("not able to find .dotconfig")
+		return
+	}
+
+	manifest, err := LoadManifest(config.Root())
+	if err != nil {
+		PrintBodyError(err.Error())
+		return
+	}
+
+	if len(manifest) == 0 {
+		PrintBodyError(fmt.Sprintf("there are no packages. Add them to %s", PackagesFileName))
+		return
+	}
+
+	statuses, err := PackagesStatus(manifest, NewPackageManagers(nil))
+	if err != nil {
+		PrintBodyError(err.Error())
+		return
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(w, "manager\tpackage\tstatus")
+	for _, s := range statuses {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Manager, s.Package, s)
+	}
+	w.Flush()
+}
+
+// CommandPackagesInstall will install the packages of the package manifest
+// that are missing on this machine, the package managers of the system run
+// with the privilege helper `privilege`. It reports whether all packages
+// could be installed.
+func CommandPackagesInstall(dryRun bool, privilege string) bool {
+	PrintHeader("Installing packages ...")
+
+	config, err := NewConfig(PathDotConfig)
+	if err != nil {
+		PrintBodyError("not able to find .dotconfig")
+		return false
+	}
+
+	manifest, err := LoadManifest(config.Root())
+	if err != nil {
+		PrintBodyError(err.Error())
+		return false
+	}
+
+	var helper PrivilegeHelper
+	if !dryRun {
+		helper, err = NewPrivilegeHelper(privilege)
+		if err != nil {
+			PrintBodyError(err.Error())
+			return false
+		}
+	}
+
+	err = InstallPackages(os.Stdout, manifest, NewPackageManagers(helper), dryRun)
+	if err != nil {
+		PrintBodyError(err.Error())
+		return false
+	}
+
+	return true
+}
@@ -4,11 +4,38 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
 )
 
 const (
 	// name of the file, where the configuration will reside
 	ConfigFileName = ".dotconfig"
+
+	// version of the configuration format. Version 0 configurations stored
+	// every path relative to the home folder, starting with version 1 paths
+	// are either prefixed with `~` or absolute, see ExpandPath.
+	ConfigVersion = 1
+
+	// name of the layer of the entries of the repository in dot_path
+	MainLayer = "main"
+)
+
+const (
+	// the target is symlinked to the file or folder in the repository, this
+	// is the default
+	EntryModeLink = "link"
+
+	// dot only manages a delimited block inside the target, see blocks.go
+	EntryModeBlock = "block"
+
+	// the target is assembled from fragments, see fragments.go
+	EntryModeFragments = "fragments"
+
+	// dot only manages the keys of a structured config file, see merge.go
+	EntryModeMerge = "merge"
 )
 
 var (
@@ -16,11 +43,175 @@ var (
 )
 
 type Config struct {
+	// version of the configuration format
+	Version int `json:"version"`
+
 	// folder where the files that are tracked will reside
 	DotPath string `json:"dot_path"`
 
 	// map with the individual files that are being tracked
-	Files map[string]string `json:"files"`
+	Files map[string]*Entry `json:"files"`
+
+	// map with the system files that are being tracked, these will be
+	// installed as a copy instead of being symlinked
+	System map[string]*SystemEntry `json:"system,omitempty"`
+
+	// commands that run before and after a sync, see Hooks
+	Hooks *Hooks `json:"hooks,omitempty"`
+
+	// other repositories of which the entries are synced as well, e.g. the
+	// shared repository of a team. See Layer.
+	Layers []*Layer `json:"layers,omitempty"`
+
+	// path of the file the config was loaded from, Save will write to it
+	path string
+
+	// the settings of this machine, nil when there is no local config
+	local *LocalConfig
+
+	// entries of the config that are overridden or disabled by the local
+	// config
+	hidden map[string]*Entry
+}
+
+// Entry is a file or folder that is being tracked. In the config file an
+// entry can be written as just its path, or as an object when it has more
+// settings.
+type Entry struct {
+	// location of the file or folder, see ExpandPath
+	Path string `json:"path"`
+
+	// glob patterns of the paths inside a tracked folder that will be
+	// tracked, when empty everything is tracked. See Ignore.
+	Include []string `json:"include,omitempty"`
+
+	// glob patterns of the paths inside a tracked folder that won't be
+	// tracked. See Ignore.
+	Exclude []string `json:"exclude,omitempty"`
+
+	// entries are synced in ascending order, entries with the same order
+	// are sorted by name. See SortEntries.
+	Order int `json:"order,omitempty"`
+
+	// names of the entries that have to be synced before this entry
+	After []string `json:"after,omitempty"`
+
+	// commands that run when the entry is linked or unlinked, see
+	// EntryHooks
+	Hooks *EntryHooks `json:"hooks,omitempty"`
+
+	// how the entry is synced, EntryModeLink (the default),
+	// EntryModeBlock, EntryModeFragments or EntryModeMerge
+	Mode string `json:"mode,omitempty"`
+
+	// prefix of the markers of a block, defaults to DefaultBlockComment
+	Comment string `json:"comment,omitempty"`
+
+	// format of a merged file, FormatJSON, FormatYAML, FormatTOML or
+	// FormatINI. It's derived from the extension of the path when empty,
+	// see DetectFormat.
+	Format string `json:"format,omitempty"`
+
+	// name of the layer the entry belongs to, empty for the repository in
+	// dot_path
+	layer string
+}
+
+// Layer is a repository with entries below the one in dot_path. The layers
+// are listed in ascending order of precedence, an entry overrides the
+// entries with the same name in earlier layers, and the entries of dot_path
+// override those of every layer. Only the entries of a layer are used, its
+// other settings are ignored.
+type Layer struct {
+	Name string `json:"name"`
+
+	// folder of the repository, see ExpandPath. It holds the files and
+	// backup folder of its entries, which are listed in the .dotconfig file
+	// in this folder.
+	Path string `json:"path"`
+
+	// the config in the folder of the layer
+	config *Config
+
+	// the entries of the layer that weren't overridden when it was loaded
+	visible map[*Entry]bool
+
+	// the config of the layer as it was loaded or last saved, it's only
+	// written when it changes
+	saved []byte
+}
+
+// Root will return the absolute path to the folder of the layer
+func (l *Layer) Root() string {
+	return ExpandPath(l.Path)
+}
+
+// validateMode will return an error when the mode or the format of the
+// entry is unknown
+func (e *Entry) validateMode() error {
+	switch e.Mode {
+	case "", EntryModeLink, EntryModeBlock, EntryModeFragments, EntryModeMerge:
+	default:
+		return fmt.Errorf("unknown mode '%s', use '%s', '%s', '%s' or '%s'", e.Mode,
+			EntryModeLink, EntryModeBlock, EntryModeFragments, EntryModeMerge)
+	}
+
+	if e.Format != "" && !validFormat(e.Format) {
+		return fmt.Errorf("unknown format '%s', use '%s', '%s', '%s' or '%s'", e.Format,
+			FormatJSON, FormatYAML, FormatTOML, FormatINI)
+	}
+
+	return nil
+}
+
+// format will return the format of a merged entry, see DetectFormat
+func (e *Entry) format() (string, error) {
+	if e.Format != "" {
+		return e.Format, nil
+	}
+
+	format := DetectFormat(e.Path)
+	if format == "" {
+		return "", fmt.Errorf("the format of %s is unknown, use '%s', '%s', '%s' or '%s'", e.Path,
+			FormatJSON, FormatYAML, FormatTOML, FormatINI)
+	}
+
+	return format, nil
+}
+
+// Layer will return the name of the layer the entry belongs to
+func (e *Entry) Layer() string {
+	if e.layer == "" {
+		return MainLayer
+	}
+
+	return e.layer
+}
+
+// UnmarshalJSON will read an entry that is either written as a path, or as
+// an object.
+func (e *Entry) UnmarshalJSON(b []byte) error {
+	var path string
+	if err := json.Unmarshal(b, &path); err == nil {
+		*e = Entry{Path: path}
+		return nil
+	}
+
+	// use another type to prevent recursion
+	type entry Entry
+	return json.Unmarshal(b, (*entry)(e))
+}
+
+// MarshalJSON will write an entry as just its path, when it doesn't have
+// any other settings.
+func (e *Entry) MarshalJSON() ([]byte, error) {
+	if len(e.Include) == 0 && len(e.Exclude) == 0 && e.Order == 0 &&
+		len(e.After) == 0 && e.Hooks == nil && e.Mode == "" && e.Comment == "" && e.Format == "" {
+		return json.Marshal(e.Path)
+	}
+
+	type entry Entry
+	return json.Marshal((*entry)(e))
 }
 
 // Constructor for the Config struct
@@ -30,37 +221,446 @@ func NewConfig(path string) (*Config, error) {
 	return c, err
 }
 
-// Pointer receiver for the Config struct load the config file
+// Pointer receiver for the Config struct load the config file, the configs
+// of its layers and the local config of this machine
 func (c *Config) load(path string) error {
+	err := c.read(path)
+	if err != nil {
+		return err
+	}
+
+	err = c.loadLayers()
+	if err != nil {
+		return err
+	}
+
+	err = c.loadLocal(path + LocalConfigSuffix)
+	if err != nil {
+		return err
+	}
+
+	c.merge()
+	return nil
+}
+
+// read will read the config file `path`, without its layers
+func (c *Config) read(path string) error {
 	file, err := os.Open(path)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
 	if err := json.NewDecoder(file).Decode(&c); err != nil {
 		return err
 	}
 
+	c.path = path
+
+	if c.Files == nil {
+		c.Files = make(map[string]*Entry)
+	}
+
+	for name, e := range c.Files {
+		if e == nil {
+			return fmt.Errorf("entry '%s' doesn't have a path", name)
+		}
+		if err := ValidateName(name); err != nil {
+			return fmt.Errorf("invalid entry in %s: %s", path, err)
+		}
+		if err := e.validateMode(); err != nil {
+			return fmt.Errorf("invalid entry '%s' in %s: %s", name, path, err)
+		}
+	}
+
+	for name := range c.System {
+		if err := ValidateName(name); err != nil {
+			return fmt.Errorf("invalid system entry in %s: %s", path, err)
+		}
+	}
+
+	c.migrate()
+
+	return nil
+}
+
+// loadLayers will load the configs of the layers, see merge
+func (c *Config) loadLayers() error {
+	roots := map[string]string{c.Root(): MainLayer}
+	for i, l := range c.Layers {
+		if l == nil || l.Path == "" {
+			return fmt.Errorf("layer %d doesn't have a path", i+1)
+		}
+
+		if err := ValidateName(l.Name); err != nil {
+			return fmt.Errorf("invalid layer: %s", err)
+		}
+
+		if l.Name == MainLayer || l.Name == LocalLayer || c.layer(l.Name) != l {
+			return fmt.Errorf("the name of the layer '%s' is already taken", l.Name)
+		}
+
+		if other, ok := roots[l.Root()]; ok {
+			return fmt.Errorf("the layer '%s' has the same folder as '%s'", l.Name, other)
+		}
+		roots[l.Root()] = l.Name
+
+		// the config of a new layer is created on save
+		path := filepath.Join(l.Root(), ConfigFileName)
+		l.config = &Config{Version: ConfigVersion, DotPath: l.Path, Files: make(map[string]*Entry), path: path}
+		err := l.config.read(path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("not able to load the layer '%s' (%s)", l.Name, err)
+		}
+
+		l.saved, err = json.MarshalIndent(l.config, "", "\t")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// merge will combine the entries of the layers, of the config itself and
+// of the local config into Files. Entries of later layers override the ones
+// of earlier layers, the entries of the config override those of every
+// layer, and the local entries override all of them. The disabled entries
+// of the local config are left out.
+func (c *Config) merge() {
+	own := c.Files
+	c.Files = make(map[string]*Entry)
+	for _, l := range c.Layers {
+		for name, e := range l.config.Files {
+			e.layer = l.Name
+			c.Files[name] = e
+		}
+	}
+
+	for name, e := range own {
+		c.Files[name] = e
+	}
+
+	if c.local != nil {
+		for _, name := range c.local.Disabled {
+			delete(c.Files, name)
+		}
+
+		for name, e := range c.local.Files {
+			e.layer = LocalLayer
+			c.Files[name] = e
+		}
+	}
+
+	// the entries that are left out still have to be saved
+	c.hidden = make(map[string]*Entry)
+	for name, e := range own {
+		if c.Files[name] != e {
+			c.hidden[name] = e
+		}
+	}
+
+	for _, l := range c.Layers {
+		l.visible = make(map[*Entry]bool)
+		for name, e := range l.config.Files {
+			if c.Files[name] == e {
+				l.visible[e] = true
+			}
+		}
+	}
+}
+
+// layer will return the layer with the name `name`, or nil when there is no
+// such layer
+func (c *Config) layer(name string) *Layer {
+	for _, l := range c.Layers {
+		if l != nil && l.Name == name {
+			return l
+		}
+	}
+
 	return nil
 }
 
-// Pointer receiver for the config struct that will save the config file
+// Layer will return the name of the layer `name` as it is stored in the
+// entries, an error is returned when there is no such layer.
+func (c *Config) Layer(name string) (string, error) {
+	if name == "" || name == MainLayer {
+		return "", nil
+	}
+
+	if name == LocalLayer {
+		return name, nil
+	}
+
+	if c.layer(name) == nil {
+		return "", fmt.Errorf("there is no layer '%s' in %s", name, ConfigFileName)
+	}
+
+	return name, nil
+}
+
+// LayerRoot will return the absolute path to the folder of the repository
+// of the layer `layer`, see Entry.Layer.
+func (c *Config) LayerRoot(layer string) string {
+	if layer == LocalLayer {
+		return c.LocalRoot()
+	}
+
+	if l := c.layer(layer); l != nil {
+		return l.Root()
+	}
+
+	return c.Root()
+}
+
+// Roots will return the absolute paths to the folders of the repository in
+// dot_path, of every layer, and of the local entries when there are any
+func (c *Config) Roots() []string {
+	roots := []string{c.Root()}
+	for _, l := range c.Layers {
+		roots = append(roots, l.Root())
+	}
+
+	for _, e := range c.Files {
+		if e.layer == LocalLayer {
+			roots = append(roots, c.LocalRoot())
+			break
+		}
+	}
+
+	return roots
+}
+
+// migrate will upgrade a configuration that was written in an older version
+// of the configuration format to the current one.
+func (c *Config) migrate() {
+	if c.Version == 0 {
+		// paths were relative to the home folder, e.g. `/.config/nvim`
+		legacy := func(path string) string {
+			path = strings.Trim(path, "/")
+			if path == "" {
+				return "~"
+			}
+			return "~/" + path
+		}
+
+		c.DotPath = legacy(c.DotPath)
+		for _, e := range c.Files {
+			e.Path = legacy(e.Path)
+		}
+	}
+
+	c.Version = ConfigVersion
+}
+
+// Root will return the absolute path to the folder where the files that are
+// tracked will reside.
+func (c *Config) Root() string {
+	return ExpandPath(c.DotPath)
+}
+
+// TargetPath will return the absolute path to the original location of the
+// tracked entry `name`.
+func (c *Config) TargetPath(name string) string {
+	return ExpandPath(c.Files[name].Path)
+}
+
+// Pointer receiver for the config struct that will save the config file to
+// the path it was loaded from, the entries of the layers are saved in the
+// configs of their layers. See write.
 func (c *Config) Save() error {
-	// truncate existing file if it exists
-	f, err := os.Create(PathDotConfig)
+	// the entries of the layers and the local entries are saved in their
+	// own config
+	own := *c
+	own.Files = make(map[string]*Entry)
+	for name, e := range c.hidden {
+		own.Files[name] = e
+	}
+	for name, e := range c.Files {
+		if e.layer == "" {
+			own.Files[name] = e
+		}
+	}
+
+	b, err := json.MarshalIndent(&own, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	err = c.write(b)
+	if err != nil {
+		return err
+	}
+
+	err = c.saveLocal()
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	b, err := json.MarshalIndent(c, "", "\t")
+	for _, l := range c.Layers {
+		// the layers of a layer aren't loaded
+		if l.config == nil {
+			continue
+		}
+
+		err = c.saveLayer(l)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// saveLayer will write the entries of the layer `l` to its config, when
+// they changed. Entries of the layer that are overridden by another layer
+// are kept.
+func (c *Config) saveLayer(l *Layer) error {
+	names := make(map[*Entry]string)
+	for name, e := range c.Files {
+		names[e] = name
+	}
+
+	files := make(map[string]*Entry)
+	for name, e := range l.config.Files {
+		switch newName, ok := names[e]; {
+		case ok:
+			// the entry may have been renamed
+			files[newName] = e
+		case !l.visible[e]:
+			files[name] = e
+		}
+	}
+
+	for name, e := range c.Files {
+		if e.layer == l.Name {
+			files[name] = e
+		}
+	}
+
+	l.config.Files = files
+	b, err := json.MarshalIndent(l.config, "", "\t")
+	if err != nil || string(b) == string(l.saved) {
+		return err
+	}
+
+	err = os.MkdirAll(l.Root(), 0755)
 	if err != nil {
 		return err
 	}
 
-	if _, err := f.Write(b); err != nil {
+	err = l.config.write(b)
+	if err != nil {
 		return err
 	}
 
+	l.saved = b
 	return nil
 }
+
+// write will write `data` to the path the config was loaded from. The data
+// is written to a temporary file first, which is then renamed over the
+// original, so a crash never leaves a truncated config behind. When the
+// path is a symlink, like ~/.dotconfig, the file it points to will be
+// replaced.
+func (c *Config) write(data []byte) error {
+	path := c.path
+	if path == "" {
+		path = PathDotConfig
+	}
+
+	// resolve the symlink, otherwise we would replace the symlink itself
+	mode := os.FileMode(0644)
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+
+		if f, err := os.Stat(path); err == nil {
+			mode = f.Mode().Perm()
+		}
+	}
+
+	return WriteFileAtomic(path, data, mode)
+}
+
+// NewName will return a name for a new entry for `path`, derived from its
+// base name without leading dots, e.g. `vimrc` for `~/.vimrc`. When the name
+// is already taken a number is appended, e.g. `vimrc-2`.
+func (c *Config) NewName(path string) string {
+	name := strings.TrimLeft(filepath.Base(strings.TrimRight(path, "/")), ".")
+	if ValidateName(name) != nil {
+		name = "entry"
+	}
+
+	taken := func(name string) bool {
+		_, file := c.Files[name]
+		_, system := c.System[name]
+		return file || system
+	}
+
+	newName := name
+	if taken(name) {
+		// leave room for the suffix
+		for len(name) > maxNameLength-8 {
+			_, size := utf8.DecodeLastRuneInString(name)
+			name = name[:len(name)-size]
+		}
+	}
+
+	for i := 2; taken(newName); i++ {
+		newName = fmt.Sprintf("%s-%d", name, i)
+	}
+
+	return newName
+}
+
+// MatchNames will return the names of the entries, including the system
+// entries, that match `patterns`. A pattern is either the name of an entry
+// or a glob pattern, e.g. `vim*`. A pattern that doesn't match any entry
+// results in an error.
+func (c *Config) MatchNames(patterns []string) ([]string, error) {
+	all := append(c.SortedNames(), c.SortedSystemNames()...)
+
+	var names []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	for _, pattern := range patterns {
+		if _, ok := c.Files[pattern]; ok {
+			add(pattern)
+			continue
+		}
+		if _, ok := c.System[pattern]; ok {
+			add(pattern)
+			continue
+		}
+
+		if !strings.ContainsAny(pattern, "*?[") {
+			return nil, fmt.Errorf("'%s' is not being tracked. Get the list "+
+				"of tracked files with `dot list`", pattern)
+		}
+
+		matched := false
+		for _, name := range all {
+			ok, err := path.Match(pattern, name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern: '%s'", pattern)
+			}
+			if ok {
+				add(name)
+				matched = true
+			}
+		}
+
+		if !matched {
+			return nil, fmt.Errorf("'%s' doesn't match any of the tracked entries", pattern)
+		}
+	}
+
+	return names, nil
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"syscall"
 	"testing"
 )
@@ -68,18 +72,386 @@ func TestNewConfig(t *testing.T) {
 		log.Fatal(err)
 	}
 
+	// payload doesn't have a version, so the paths are relative to the
+	// home folder and should be migrated
+	if c.Version != ConfigVersion {
+		t.Error("c.Version doesn't match")
+	}
+
 	// c.DotPath should be the same as payload
-	if c.DotPath != "/path/to/dotfiles" {
+	if c.DotPath != "~/path/to/dotfiles" {
 		t.Error("c.DotPath doesn't match")
 	}
 
 	// c.Files should be the same as payload
-	if c.Files["test_file_1"] != "path-to-test-file-1" {
+	if c.Files["test_file_1"].Path != "~/path-to-test-file-1" {
 		t.Error("c.Files doesn't match")
 	}
 
 	// c.Files should be the same as payload
-	if c.Files["test_file_2"] != "path-to-test-file-2" {
+	if c.Files["test_file_2"].Path != "~/path-to-test-file-2" {
 		t.Error("c.Files doesn't match")
 	}
 }
+
+func TestNewConfigAbsolutePaths(t *testing.T) {
+	f, err := ioutil.TempFile("", ".dotconfig")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer syscall.Unlink(f.Name())
+
+	ioutil.WriteFile(
+		f.Name(),
+		[]byte(`{
+			"version": 1,
+			"dot_path": "/srv/dotfiles",
+			"files": {"hosts": "/etc/hosts", "vimrc": "~/.vimrc"}
+		}`),
+		0644,
+	)
+
+	c, err := NewConfig(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Root() != "/srv/dotfiles" {
+		t.Errorf("c.Root() doesn't match: %s", c.Root())
+	}
+
+	if c.TargetPath("hosts") != "/etc/hosts" {
+		t.Errorf("c.TargetPath() doesn't match: %s", c.TargetPath("hosts"))
+	}
+
+	if c.TargetPath("vimrc") != filepath.Join(HomeDir(), ".vimrc") {
+		t.Errorf("c.TargetPath() doesn't match: %s", c.TargetPath("vimrc"))
+	}
+}
+
+func TestEntryJSON(t *testing.T) {
+	var files map[string]*Entry
+	err := json.Unmarshal([]byte(`{
+		"vimrc": "~/.vimrc",
+		"code": {"path": "~/.config/Code", "include": ["User/"], "exclude": ["*.log"]}
+	}`), &files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if files["vimrc"].Path != "~/.vimrc" {
+		t.Errorf("unexpected path: %s", files["vimrc"].Path)
+	}
+
+	code := files["code"]
+	if code.Path != "~/.config/Code" ||
+		!reflect.DeepEqual(code.Include, []string{"User/"}) ||
+		!reflect.DeepEqual(code.Exclude, []string{"*.log"}) {
+		t.Errorf("unexpected entry: %+v", code)
+	}
+
+	// entries without patterns should be written as just their path
+	b, err := json.Marshal(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"code":{"path":"~/.config/Code","include":["User/"],"exclude":["*.log"]},"vimrc":"~/.vimrc"}`
+	if string(b) != expected {
+		t.Errorf("unexpected json: %s", b)
+	}
+}
+
+// Test if Save will write the config back to the path it was loaded from,
+// keeping the symlink intact
+func TestConfigSave(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// tempDir
+	// |_ files
+	// |  |_ dotconfig
+	// |     |_ .dotconfig
+	// |_ .dotconfig -> files/dotconfig/.dotconfig
+	pathConfig := filepath.Join(tempDir, "files", "dotconfig", ".dotconfig")
+	pathSymlink := filepath.Join(tempDir, ".dotconfig")
+	os.MkdirAll(filepath.Dir(pathConfig), 0755)
+	ioutil.WriteFile(pathConfig, []byte(payload), 0600)
+	os.Symlink(pathConfig, pathSymlink)
+
+	c, err := NewConfig(pathSymlink)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Files["test_file_3"] = &Entry{Path: "~/path-to-test-file-3"}
+	err = c.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Lstat(pathSymlink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Mode()&os.ModeSymlink == 0 {
+		t.Error("symlink was replaced")
+	}
+
+	f, err = os.Stat(pathConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Mode().Perm() != 0600 {
+		t.Errorf("mode wasn't preserved: %o", f.Mode().Perm())
+	}
+
+	c, err = NewConfig(pathConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Files["test_file_3"] == nil {
+		t.Error("new entry wasn't saved")
+	}
+
+	// no temporary files should be left behind
+	files, _ := ioutil.ReadDir(filepath.Dir(pathConfig))
+	if len(files) != 1 {
+		t.Errorf("expected 1 file, got %d", len(files))
+	}
+}
+
+func TestConfigNewName(t *testing.T) {
+	c := &Config{
+		Files: map[string]*Entry{
+			"vimrc":   {Path: "/home/user/.vimrc"},
+			"vimrc-2": {Path: "/home/user/.vim/vimrc"},
+		},
+		System: map[string]*SystemEntry{
+			"hosts": {Path: "/etc/hosts"},
+		},
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/home/user/.zshrc", "zshrc"},
+		{"/home/user/.config/nvim/", "nvim"},
+		{"/home/user/vimrc", "vimrc-3"},
+		{"/home/user/hosts", "hosts-2"},
+		{"/home/user/...", "entry"},
+		{"/home/user/" + strings.Repeat("a", maxNameLength+1), "entry"},
+	}
+
+	for _, test := range tests {
+		if name := c.NewName(test.path); name != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.path, test.expected, name)
+		}
+	}
+
+	// the suffix of a long name that is taken mustn't make it too long
+	long := strings.Repeat("a", maxNameLength)
+	c.Files[long] = &Entry{Path: "/home/user/" + long}
+	if name := c.NewName("/home/user/" + long); ValidateName(name) != nil || name == long {
+		t.Errorf("expected a valid, unique name, got %s", name)
+	}
+}
+
+func TestConfigMatchNames(t *testing.T) {
+	c := &Config{
+		Files: map[string]*Entry{
+			"vim":   {Path: "/home/user/.vim"},
+			"vimrc": {Path: "/home/user/.vimrc"},
+			"zshrc": {Path: "/home/user/.zshrc"},
+		},
+		System: map[string]*SystemEntry{
+			"vim-system": {Path: "/etc/vim/vimrc"},
+		},
+	}
+
+	tests := []struct {
+		patterns []string
+		expected []string
+	}{
+		{[]string{"zshrc"}, []string{"zshrc"}},
+		{[]string{"vim*"}, []string{"vim", "vimrc", "vim-system"}},
+		{[]string{"zshrc", "*rc", "vim"}, []string{"zshrc", "vimrc", "vim"}},
+	}
+
+	for _, test := range tests {
+		names, err := c.MatchNames(test.patterns)
+		if err != nil {
+			t.Errorf("%v: %s", test.patterns, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("%v: expected %v, got %v", test.patterns, test.expected, names)
+		}
+	}
+
+	for _, patterns := range [][]string{{"unknown"}, {"vim", "bash*"}, {"[vim"}} {
+		if _, err := c.MatchNames(patterns); err == nil {
+			t.Errorf("%v: expected an error", patterns)
+		}
+	}
+}
+
+// newTestLayerConfig will create a config with the layer `team`, which has
+// the entries `shared` and `vim`. The config overrides `vim`.
+func newTestLayerConfig(t *testing.T) (*Config, string) {
+	c, tempDir := newTestConfig(t)
+
+	team := filepath.Join(tempDir, "team")
+	writeTestFile(t, filepath.Join(team, ConfigFileName), `{"version": 1, "files": {
+		"shared": "~/.shared",
+		"vim": "~/.vimrc-team"
+	}}`)
+
+	c.Layers = []*Layer{{Name: "team", Path: team}}
+	c.Files["vim"] = &Entry{Path: "~/.vimrc"}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewConfig(c.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, tempDir
+}
+
+func TestConfigLayers(t *testing.T) {
+	c, tempDir := newTestLayerConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	team := filepath.Join(tempDir, "team")
+	if len(c.Files) != 2 || c.Files["shared"].Layer() != "team" || c.Files["vim"].Layer() != MainLayer {
+		t.Fatalf("expected shared of team and vim of main, got %v", c.Files)
+	}
+
+	if c.Files["vim"].Path != "~/.vimrc" {
+		t.Errorf("expected vim to be overridden, got %s", c.Files["vim"].Path)
+	}
+
+	if p := c.Paths("shared"); p.RepoDir != filepath.Join(team, "files", "shared") {
+		t.Errorf("expected shared in the repository of team, got %s", p.RepoDir)
+	}
+
+	if err := c.RemoveAll(filepath.Join(team, "files", "shared")); err != nil {
+		t.Errorf("expected the files of a layer to be removable, got %v", err)
+	}
+
+	if !c.InsideRepo(filepath.Join(team, "files")) {
+		t.Error("expected the folder of a layer to be inside the repository")
+	}
+
+	if layer, err := c.Layer("team"); err != nil || layer != "team" {
+		t.Errorf("expected the layer team, got %s (%v)", layer, err)
+	}
+
+	if layer, err := c.Layer(MainLayer); err != nil || layer != "" {
+		t.Errorf("expected the main layer, got %s (%v)", layer, err)
+	}
+
+	if _, err := c.Layer("unknown"); err == nil {
+		t.Error("expected an error for an unknown layer")
+	}
+}
+
+func TestConfigSaveLayers(t *testing.T) {
+	c, tempDir := newTestLayerConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	pathTeam := filepath.Join(tempDir, "team", ConfigFileName)
+	before, _ := ioutil.ReadFile(pathTeam)
+
+	// the layer is only written when its entries change
+	c.Files["main"] = &Entry{Path: "~/.main"}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if after, _ := ioutil.ReadFile(pathTeam); string(after) != string(before) {
+		t.Errorf("expected the layer to be left untouched, got %s", after)
+	}
+
+	main, _ := ioutil.ReadFile(c.path)
+	if strings.Contains(string(main), "shared") || !strings.Contains(string(main), "~/.main") {
+		t.Errorf("expected only the entries of main in its config, got %s", main)
+	}
+
+	// rename an entry of the layer, and remove the override
+	e := c.Files["shared"]
+	delete(c.Files, "shared")
+	c.Files["common"] = e
+	c.Files["new"] = &Entry{Path: "~/.new", layer: "team"}
+	delete(c.Files, "vim")
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewConfig(c.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"common": "team",
+		"new":    "team",
+		"vim":    "team",
+		"main":   MainLayer,
+	}
+	if len(c.Files) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, c.Files)
+	}
+	for name, layer := range expected {
+		if e, ok := c.Files[name]; !ok || e.Layer() != layer {
+			t.Errorf("expected %s in the layer %s, got %v", name, layer, e)
+		}
+	}
+
+	if c.Files["vim"].Path != "~/.vimrc-team" {
+		t.Errorf("expected the entry of the layer once the override is removed, got %s", c.Files["vim"].Path)
+	}
+}
+
+func TestConfigInvalidLayers(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	team := filepath.Join(tempDir, "team")
+	tests := map[string][]*Layer{
+		"no path":     {{Name: "team"}},
+		"invalid":     {{Name: "a/b", Path: team}},
+		"main":        {{Name: MainLayer, Path: team}},
+		"duplicate":   {{Name: "team", Path: team}, {Name: "team", Path: tempDir}},
+		"same folder": {{Name: "team", Path: c.Root()}},
+	}
+
+	for description, layers := range tests {
+		c.Layers = layers
+		if err := c.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := NewConfig(c.path); err == nil {
+			t.Errorf("%s: expected an error", description)
+		}
+	}
+
+	writeTestFile(t, filepath.Join(team, ConfigFileName), `{"files": []}`)
+	c.Layers = []*Layer{{Name: "team", Path: team}}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewConfig(c.path); err == nil {
+		t.Error("expected an error for an invalid config of a layer")
+	}
+}
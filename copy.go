@@ -0,0 +1,340 @@
+// copy.go will hold the operations that copy and move files and folders.
+// Copies are as faithful as permitted: symlinks, modes, ownership,
+// modification times and extended attributes are preserved.
+
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// rename is used to move files and folders, it can be replaced in tests to
+// simulate moves across file systems
+var rename = os.Rename
+
+// MakeAndMoveToDir will move the source file/folder `src` to the destination
+// `dst` (`dst` will be absolute path to the destination), `dst` may not
+// exist yet.
+//
+// When `src` and `dst` reside on the same file system it is renamed, which
+// is atomic. Otherwise `src` is copied into a temporary folder next to `dst`,
+// renamed into place and removed afterwards. An interruption will never
+// leave a partial `dst` behind, at worst `src` and `dst` both exist.
+func MakeAndMoveToDir(src string, dst string) error {
+	return moveToDir(src, dst, func(src, tmpDst string) error {
+		return copyTree(src, tmpDst, dst)
+	})
+}
+
+func moveToDir(src, dst string, copy func(src, tmpDst string) error) error {
+	src = filepath.Clean(src)
+	dst = filepath.Clean(dst)
+
+	err := checkMove(src, dst)
+	if err != nil {
+		return err
+	}
+
+	// fast path, src and dst on the same file system
+	err = rename(src, dst)
+	if err == nil {
+		return nil
+	}
+
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	return moveByCopy(src, dst, copy)
+}
+
+// checkMove will make sure `src` can be moved to `dst`, and creates the
+// missing parent folders of `dst`.
+func checkMove(src, dst string) error {
+	if _, err := os.Lstat(src); err != nil {
+		return err
+	}
+
+	_, err := os.Lstat(dst)
+	if err == nil {
+		return errors.New("dst already exist")
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	return makeParents(src, dst)
+}
+
+// moveByCopy will use `copy` to copy `src` into a temporary folder next to
+// `dst`, rename the copy into place and then remove `src`. Absolute symlinks
+// in the copy have to point inside `dst`, not the temporary folder.
+func moveByCopy(src, dst string, copy func(src, tmpDst string) error) error {
+	tmpDir, err := ioutil.TempDir(filepath.Dir(dst), ".dot-move-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmpDst := filepath.Join(tmpDir, filepath.Base(dst))
+	err = copy(src, tmpDst)
+	if err != nil {
+		return err
+	}
+
+	// the temporary folder is on the same file system as dst
+	err = rename(tmpDst, dst)
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(src)
+}
+
+// MakeAndCopyToDir will copy the source file/folder `src` to the destination
+// `dst`, the missing parent folders of `dst` are created with the mode of
+// the parent folder of `src`.
+func MakeAndCopyToDir(src string, dst string) error {
+	// folder or file
+	f, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	err = makeParents(src, dst)
+	if err != nil {
+		return err
+	}
+
+	if f.IsDir() {
+		return CopyDir(src, dst)
+	}
+
+	return CopyFile(src, dst)
+}
+
+// makeParents will create the missing parent folders of `dst`, using the
+// permissions of the parent folder of `src`.
+func makeParents(src string, dst string) error {
+	mode := os.FileMode(0755)
+	if f, err := os.Stat(filepath.Dir(src)); err == nil {
+		// make sure we are still able to write in the folders
+		mode = f.Mode().Perm() | 0700
+	}
+
+	return os.MkdirAll(filepath.Dir(dst), mode)
+}
+
+// CopyDir will copy the folder `src` to `dst`, `dst` may not exist yet.
+// Symlinks are copied as symlinks, absolute symlinks that point inside of
+// `src` will be rewritten to point inside of `dst`. Sockets, named pipes
+// and devices are skipped.
+func CopyDir(src string, dst string) error {
+	src = filepath.Clean(src)
+	dst = filepath.Clean(dst)
+
+	// Check src
+	f, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if !f.IsDir() {
+		return errors.New("source is not a directory")
+	}
+
+	// Check dst
+	_, err = os.Lstat(dst)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		return errors.New("dst already exist")
+	}
+
+	return copyTree(src, dst, dst)
+}
+
+// copyTree will copy the file or folder `src` to `dst`, absolute symlinks
+// pointing inside of `src` will be rewritten to point inside of `linkRoot`.
+func copyTree(src, dst, linkRoot string) error {
+	f, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if !f.IsDir() {
+		return copyFile(src, dst, f)
+	}
+
+	c := &copier{srcRoot: src, dstRoot: dst, linkRoot: linkRoot}
+	return c.copyDir(src, dst, f)
+}
+
+// CopyFile will copy the file `src` to `dst`, preserving its mode,
+// ownership, modification time and extended attributes.
+func CopyFile(src string, dst string) error {
+	f, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	return copyFile(src, dst, f)
+}
+
+// copier copies a tree of files and folders, rooted at srcRoot, to dstRoot.
+type copier struct {
+	srcRoot string
+	dstRoot string
+
+	// absolute symlinks that point inside srcRoot will be rewritten to
+	// point inside linkRoot, this is where dstRoot will end up eventually
+	linkRoot string
+}
+
+// copy will copy `src`, with file info `f`, to `dst` depending on its type.
+func (c *copier) copy(src, dst string, f os.FileInfo) error {
+	switch {
+	case f.Mode()&os.ModeSymlink != 0:
+		return c.copySymlink(src, dst, f)
+	case f.IsDir():
+		return c.copyDir(src, dst, f)
+	case f.Mode().IsRegular():
+		return copyFile(src, dst, f)
+	default:
+		// sockets, named pipes and devices can't be copied
+		return nil
+	}
+}
+
+func (c *copier) copyDir(src, dst string, f os.FileInfo) error {
+	// the folder is created with restrictive permissions, the permissions of
+	// `src` are applied when its contents are copied, this way we're able to
+	// copy read-only folders as well
+	err := os.Mkdir(dst, 0700)
+	if err != nil && !(os.IsExist(err) && dst == c.dstRoot) {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		srcPath := filepath.Join(src, file.Name())
+		dstPath := filepath.Join(dst, file.Name())
+
+		err = c.copy(srcPath, dstPath, file)
+		if err != nil {
+			return err
+		}
+	}
+
+	return copyMetadata(src, dst, f)
+}
+
+func (c *copier) copySymlink(src, dst string, f os.FileInfo) error {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+
+	// absolute symlinks that point inside of the tree have to point inside
+	// of the copy, relative symlinks stay valid as they are
+	if filepath.IsAbs(target) {
+		relPath, err := filepath.Rel(c.srcRoot, target)
+		if err == nil && !IsOutsideDir(relPath) {
+			target = filepath.Join(c.linkRoot, relPath)
+		}
+	}
+
+	err = os.Symlink(target, dst)
+	if err != nil {
+		return err
+	}
+
+	return copyOwner(dst, f, true)
+}
+
+func copyFile(src, dst string, f os.FileInfo) error {
+	inFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer inFile.Close()
+
+	outFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	_, err = io.Copy(outFile, inFile)
+	if err != nil {
+		return err
+	}
+
+	err = outFile.Sync()
+	if err != nil {
+		return err
+	}
+
+	err = outFile.Close()
+	if err != nil {
+		return err
+	}
+
+	return copyMetadata(src, dst, f)
+}
+
+// copyMetadata will apply the ownership, extended attributes, mode and
+// modification time of `src`, with file info `f`, to `dst`. Ownership and
+// extended attributes are only copied when we're permitted to.
+func copyMetadata(src, dst string, f os.FileInfo) error {
+	// ownership goes first, changing it can clear the setuid and setgid bits
+	err := copyOwner(dst, f, false)
+	if err != nil {
+		return err
+	}
+
+	err = copyXattrs(src, dst)
+	if err != nil {
+		return err
+	}
+
+	mode := f.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	err = os.Chmod(dst, mode)
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(dst, f.ModTime(), f.ModTime())
+}
+
+// copyOwner will apply the owner and group of file info `f` to `dst`, when
+// we don't have the permission to do so it's skipped.
+func copyOwner(dst string, f os.FileInfo, symlink bool) error {
+	uid, gid, ok := fileOwner(f)
+	if !ok {
+		return nil
+	}
+
+	var err error
+	if symlink {
+		err = os.Lchown(dst, uid, gid)
+	} else {
+		err = os.Chown(dst, uid, gid)
+	}
+
+	if err != nil && !errors.Is(err, os.ErrPermission) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Test if CopyDir will preserve symlinks, absolute symlinks that point
+// inside the copied folder should point inside the copy
+func TestCopyDirSymlinks(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// we will start with:
+	//
+	// src
+	// |_ file
+	// |_ relative -> file
+	// |_ absolute -> [tempDir]/src/file
+	// |_ external -> /etc/hosts
+	// |_ dangling -> missing
+	// |_ dir
+	//    |_ up -> ../file
+	src := filepath.Join(tempDir, "src")
+	os.MkdirAll(filepath.Join(src, "dir"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "file"), []byte("file"), 0644)
+	os.Symlink("file", filepath.Join(src, "relative"))
+	os.Symlink(filepath.Join(src, "file"), filepath.Join(src, "absolute"))
+	os.Symlink("/etc/hosts", filepath.Join(src, "external"))
+	os.Symlink("missing", filepath.Join(src, "dangling"))
+	os.Symlink("../file", filepath.Join(src, "dir", "up"))
+
+	dst := filepath.Join(tempDir, "dst")
+	err = CopyDir(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"relative": "file",
+		"absolute": filepath.Join(dst, "file"),
+		"external": "/etc/hosts",
+		"dangling": "missing",
+		"dir/up":   "../file",
+	}
+
+	for name, expected := range tests {
+		target, err := os.Readlink(filepath.Join(dst, name))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		if target != expected {
+			t.Errorf("%s: expected %s, got %s", name, expected, target)
+		}
+	}
+}
+
+// Test if CopyDir will preserve the modes of files and folders
+func TestCopyDirModes(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	src := filepath.Join(tempDir, "src")
+	os.MkdirAll(filepath.Join(src, "private"), 0755)
+	os.MkdirAll(filepath.Join(src, "readonly"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "id_rsa"), []byte("key"), 0600)
+	ioutil.WriteFile(filepath.Join(src, "script"), []byte("#!/bin/sh"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "readonly", "file"), []byte(""), 0444)
+
+	// set modes explicitly, so they aren't influenced by the umask
+	modes := map[string]os.FileMode{
+		"id_rsa":        0600,
+		"script":        0755,
+		"private":       0700 | os.ModeDir,
+		"readonly/file": 0444,
+		"readonly":      0555 | os.ModeDir,
+		".":             0750 | os.ModeDir,
+	}
+	for name, mode := range modes {
+		os.Chmod(filepath.Join(src, name), mode)
+	}
+	defer os.Chmod(filepath.Join(src, "readonly"), 0755)
+
+	dst := filepath.Join(tempDir, "dst")
+	err = CopyDir(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(dst, "readonly"), 0755)
+
+	for name, mode := range modes {
+		f, err := os.Stat(filepath.Join(dst, name))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		if f.Mode() != mode {
+			t.Errorf("%s: expected %s, got %s", name, mode, f.Mode())
+		}
+	}
+}
+
+// Test if CopyDir will preserve modification times, also of folders
+func TestCopyDirTimestamps(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	src := filepath.Join(tempDir, "src")
+	os.MkdirAll(filepath.Join(src, "dir"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "dir", "file"), []byte("file"), 0644)
+
+	mtime := time.Date(2016, 12, 3, 19, 45, 7, 0, time.UTC)
+	for _, name := range []string{"dir/file", "dir", "."} {
+		os.Chtimes(filepath.Join(src, name), mtime, mtime)
+	}
+
+	dst := filepath.Join(tempDir, "dst")
+	err = CopyDir(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"dir/file", "dir", "."} {
+		f, err := os.Stat(filepath.Join(dst, name))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		if !f.ModTime().Equal(mtime) {
+			t.Errorf("%s: expected %s, got %s", name, mtime, f.ModTime())
+		}
+	}
+}
+
+// Test if CopyDir will preserve ownership, this is only permitted for root
+func TestCopyDirOwnership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing ownership requires root")
+	}
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	src := filepath.Join(tempDir, "src")
+	os.MkdirAll(src, 0755)
+	ioutil.WriteFile(filepath.Join(src, "file"), []byte("file"), 0644)
+	os.Symlink("file", filepath.Join(src, "link"))
+
+	os.Chown(filepath.Join(src, "file"), 1234, 2345)
+	os.Lchown(filepath.Join(src, "link"), 3456, 4567)
+
+	dst := filepath.Join(tempDir, "dst")
+	err = CopyDir(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][2]int{
+		"file": {1234, 2345},
+		"link": {3456, 4567},
+	}
+
+	for name, owner := range tests {
+		f, err := os.Lstat(filepath.Join(dst, name))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		uid, gid, _ := fileOwner(f)
+		if uid != owner[0] || gid != owner[1] {
+			t.Errorf("%s: expected %d:%d, got %d:%d",
+				name, owner[0], owner[1], uid, gid)
+		}
+	}
+}
+
+// Test if CopyDir will skip sockets instead of failing
+func TestCopyDirSockets(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	src := filepath.Join(tempDir, "src")
+	os.MkdirAll(src, 0755)
+	ioutil.WriteFile(filepath.Join(src, "file"), []byte("file"), 0644)
+
+	l, err := net.Listen("unix", filepath.Join(src, "socket"))
+	if err != nil {
+		t.Skip("not able to create socket")
+	}
+	defer l.Close()
+
+	dst := filepath.Join(tempDir, "dst")
+	err = CopyDir(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "file")); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := os.Lstat(filepath.Join(dst, "socket")); err == nil {
+		t.Error("socket shouldn't be copied")
+	}
+}
+
+// Test if MakeAndCopyToDir will create the missing parent folders with the
+// mode of the parent folder of the source
+func TestMakeAndCopyToDirParents(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	src := filepath.Join(tempDir, ".ssh")
+	os.MkdirAll(src, 0700)
+	os.Chmod(src, 0700)
+	ioutil.WriteFile(filepath.Join(src, "config"), []byte("Host *"), 0600)
+
+	dst := filepath.Join(tempDir, "files", "ssh", "config")
+	err = MakeAndCopyToDir(filepath.Join(src, "config"), dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Stat(filepath.Dir(dst))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Mode().Perm() != 0700 {
+		t.Errorf("expected 0700, got %o", f.Mode().Perm())
+	}
+}
+
+// Test if MakeAndMoveToDir will rename when source and destination are on
+// the same file system, the moved folder should keep its inode
+func TestMakeAndMoveToDirRename(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	src := filepath.Join(tempDir, "src")
+	os.MkdirAll(src, 0755)
+	before, _ := os.Stat(src)
+
+	dst := filepath.Join(tempDir, "files", "name", "src")
+	err = MakeAndMoveToDir(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !os.SameFile(before, after) {
+		t.Error("expected folder to be renamed")
+	}
+}
+
+// crossDeviceRename will simulate that `src` is on another file system than
+// its destination, when `fail` is set renaming into place will fail as well
+func crossDeviceRename(src string, fail bool) func(string, string) error {
+	return func(oldpath, newpath string) error {
+		if oldpath == src {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+		}
+		if fail {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EIO}
+		}
+		return os.Rename(oldpath, newpath)
+	}
+}
+
+// Test if MakeAndMoveToDir will fall back to copying when source and
+// destination are on different file systems
+func TestMakeAndMoveToDirCrossDevice(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	src := filepath.Join(tempDir, "src")
+	os.MkdirAll(src, 0755)
+	ioutil.WriteFile(filepath.Join(src, "file"), []byte("file"), 0644)
+	os.Symlink(filepath.Join(src, "file"), filepath.Join(src, "link"))
+
+	rename = crossDeviceRename(src, false)
+	defer func() { rename = os.Rename }()
+
+	dst := filepath.Join(tempDir, "files", "src")
+	err = MakeAndMoveToDir(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "file")); err != nil {
+		t.Error(err)
+	}
+
+	// the absolute symlink should point inside dst, not the temporary
+	// folder that was used for copying
+	target, err := os.Readlink(filepath.Join(dst, "link"))
+	if err != nil || target != filepath.Join(dst, "file") {
+		t.Errorf("unexpected symlink target: %s (%v)", target, err)
+	}
+
+	if _, err := os.Stat(src); err == nil {
+		t.Error("src should be removed")
+	}
+
+	// the temporary folder should be cleaned up
+	files, _ := ioutil.ReadDir(filepath.Join(tempDir, "files"))
+	if len(files) != 1 {
+		t.Errorf("expected only dst, got %d files", len(files))
+	}
+}
+
+// Test if an interrupted move across file systems leaves the source intact,
+// and doesn't leave a partial destination behind
+func TestMakeAndMoveToDirCrossDeviceFailure(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	src := filepath.Join(tempDir, "src")
+	os.MkdirAll(src, 0755)
+	ioutil.WriteFile(filepath.Join(src, "file"), []byte("file"), 0644)
+
+	rename = crossDeviceRename(src, true)
+	defer func() { rename = os.Rename }()
+
+	dst := filepath.Join(tempDir, "files", "src")
+	err = MakeAndMoveToDir(src, dst)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if _, err := os.Stat(filepath.Join(src, "file")); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := os.Stat(dst); err == nil {
+		t.Error("dst shouldn't exist")
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Join(tempDir, "files"))
+	if len(files) != 0 {
+		t.Errorf("expected no files, got %d files", len(files))
+	}
+}
+
+// Test if MakeAndMoveToDir refuses to overwrite an existing destination
+func TestMakeAndMoveToDirExisting(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	src := filepath.Join(tempDir, "src")
+	dst := filepath.Join(tempDir, "dst")
+	ioutil.WriteFile(src, []byte("src"), 0644)
+	ioutil.WriteFile(dst, []byte("dst"), 0644)
+
+	err = MakeAndMoveToDir(src, dst)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	content, _ := ioutil.ReadFile(dst)
+	if string(content) != "dst" {
+		t.Error("dst shouldn't be overwritten")
+	}
+
+	if _, err := os.Stat(src); err != nil {
+		t.Error(err)
+	}
+}
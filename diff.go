@@ -0,0 +1,823 @@
+// diff.go will hold the comparison of files and folders, the differences
+// are written as unified diffs.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// number of unchanged lines that surround the changes in a hunk
+const diffContext = 3
+
+// edit is a single line of an edit script, kind is ' ' for an unchanged
+// line, '-' for a removed line and '+' for an added line
+type edit struct {
+	kind byte
+	line string
+}
+
+// diffLines will return the shortest edit script that turns `a` into `b`,
+// using the linear space variant of the algorithm of Myers. Removed lines
+// come before the added lines that replace them.
+func diffLines(a, b []string) []edit {
+	var edits []edit
+	diffRange(a, b, &edits)
+
+	// put the removed lines of every change before the added lines
+	for i := 0; i < len(edits); {
+		if edits[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		j := i
+		for j < len(edits) && edits[j].kind != ' ' {
+			j++
+		}
+		sort.SliceStable(edits[i:j], func(x, y int) bool {
+			return edits[i+x].kind == '-' && edits[i+y].kind == '+'
+		})
+		i = j
+	}
+
+	return edits
+}
+
+// diffRange will append the edit script that turns `a` into `b` to
+// `edits`. The script is split at the middle snake, see middleSnake, so
+// only the space of the current range is used.
+func diffRange(a, b []string, edits *[]edit) {
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		*edits = append(*edits, edit{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	tail := a[len(a)-suffix:]
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	switch {
+	case len(a) == 0:
+		for _, line := range b {
+			*edits = append(*edits, edit{'+', line})
+		}
+	case len(b) == 0:
+		for _, line := range a {
+			*edits = append(*edits, edit{'-', line})
+		}
+	default:
+		x, y, u, v := middleSnake(a, b)
+		diffRange(a[:x], b[:y], edits)
+		for _, line := range a[x:u] {
+			*edits = append(*edits, edit{' ', line})
+		}
+		diffRange(a[u:], b[v:], edits)
+	}
+
+	for _, line := range tail {
+		*edits = append(*edits, edit{' ', line})
+	}
+}
+
+// middleSnake will return the start (x, y) and the end (u, v) of the
+// middle snake of the shortest edit script that turns `a` into `b`, the
+// diagonal where the searches from the start and from the end meet. `a` and
+// `b` may not be empty.
+func middleSnake(a, b []string) (int, int, int, int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+
+	// the furthest x for every diagonal k, offset by `offset`. The search
+	// from the end works on the reversed sequences.
+	offset := (n+m+1)/2 + 1
+	forward := make([]int, 2*offset+1)
+	backward := make([]int, 2*offset+1)
+
+	for d := 0; d < offset; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+
+			reversed := delta - k
+			if odd && reversed >= -(d-1) && reversed <= d-1 && x+backward[offset+reversed] >= n {
+				return startX, startY, x, y
+			}
+		}
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+
+			reversed := delta - k
+			if !odd && reversed >= -d && reversed <= d && x+forward[offset+reversed] >= n {
+				return n - x, m - y, n - startX, m - startY
+			}
+		}
+	}
+
+	// not reached, the searches always meet
+	return 0, 0, 0, 0
+}
+
+// splitLines will split `data` into lines, every line keeps its newline
+// except for the last line when the data doesn't end with a newline.
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// UnifiedDiff will write the differences between `a` and `b` as a unified
+// diff to `w`, using `labelA` and `labelB` as the names of the files. It
+// reports whether there were any differences.
+func UnifiedDiff(w io.Writer, labelA, labelB string, a, b []byte) bool {
+	edits := diffLines(splitLines(a), splitLines(b))
+
+	insertions, deletions := countEdits(edits)
+	if insertions == 0 && deletions == 0 {
+		return false
+	}
+
+	writeUnified(w, labelA, labelB, edits)
+	return true
+}
+
+// countEdits will return the number of added and removed lines
+func countEdits(edits []edit) (int, int) {
+	insertions, deletions := 0, 0
+	for _, e := range edits {
+		switch e.kind {
+		case '+':
+			insertions++
+		case '-':
+			deletions++
+		}
+	}
+
+	return insertions, deletions
+}
+
+// writeUnified will write the edit script `edits` as a unified diff, the
+// changes are grouped in hunks surrounded by diffContext unchanged lines.
+func writeUnified(w io.Writer, labelA, labelB string, edits []edit) {
+	// line numbers in `a` and `b` before every edit
+	posA := make([]int, len(edits)+1)
+	posB := make([]int, len(edits)+1)
+	for i, e := range edits {
+		posA[i+1], posB[i+1] = posA[i], posB[i]
+		if e.kind != '+' {
+			posA[i+1]++
+		}
+		if e.kind != '-' {
+			posB[i+1]++
+		}
+	}
+
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", labelA, labelB)
+
+	// index after the previous hunk, hunks never overlap
+	prevEnd := 0
+	for i := 0; i < len(edits); {
+		// find the next change
+		for i < len(edits) && edits[i].kind == ' ' {
+			i++
+		}
+		if i == len(edits) {
+			break
+		}
+
+		start := i - diffContext
+		if start < prevEnd {
+			start = prevEnd
+		}
+
+		// extend the hunk while the next change is close enough
+		end := i
+		for {
+			for end < len(edits) && edits[end].kind != ' ' {
+				end++
+			}
+
+			next := end
+			for next < len(edits) && edits[next].kind == ' ' {
+				next++
+			}
+
+			if next == len(edits) || next-end > 2*diffContext {
+				break
+			}
+			end = next
+		}
+
+		end += diffContext
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		fmt.Fprintf(w, "@@ -%s +%s @@\n",
+			hunkRange(posA[start], posA[end]-posA[start]),
+			hunkRange(posB[start], posB[end]-posB[start]))
+
+		for _, e := range edits[start:end] {
+			fmt.Fprintf(w, "%c%s", e.kind, e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				fmt.Fprint(w, "\n\\ No newline at end of file\n")
+			}
+		}
+
+		i, prevEnd = end, end
+	}
+}
+
+// hunkRange will return the range of a hunk header, e.g. `3,4`. Line
+// numbers start at 1, an empty range refers to the line before it.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// DiffStat holds the number of changed lines of a file, see Differ
+type DiffStat struct {
+	Label      string
+	Insertions int
+	Deletions  int
+	Binary     bool
+}
+
+// Differ will compare files and folders, the differences are either written
+// as unified diffs or collected as statistics.
+type Differ struct {
+	// only collect the statistics of the changed files, instead of writing
+	// the differences, see WriteStat
+	Stat  bool
+	Stats []DiffStat
+}
+
+// DiffTrees will write the differences between the files and folders at
+// `oldPath` and `newPath` to `w`, see Differ.Diff.
+func DiffTrees(w io.Writer, oldPath, newPath, oldLabel, newLabel string) (bool, error) {
+	return (&Differ{}).Diff(w, oldPath, newPath, oldLabel, newLabel)
+}
+
+// Diff will write the differences between the files and folders at
+// `oldPath` and `newPath` to `w`, folders are compared recursively. A path
+// that doesn't exist is treated as empty, symlinks are compared by the path
+// they point to and binary files are only reported. The paths are shown as
+// `oldLabel` and `newLabel`. It reports whether there were any differences.
+func (d *Differ) Diff(w io.Writer, oldPath, newPath, oldLabel, newLabel string) (bool, error) {
+	oldInfo, err := lstatIfExists(oldPath)
+	if err != nil {
+		return false, err
+	}
+
+	newInfo, err := lstatIfExists(newPath)
+	if err != nil {
+		return false, err
+	}
+
+	if !isDir(oldInfo) && !isDir(newInfo) {
+		return d.diffFiles(w, oldPath, newPath, oldInfo, newInfo, oldLabel, newLabel)
+	}
+
+	// a file that is replaced by a folder, or the other way around, is
+	// compared to nothing
+	differs := false
+	if oldInfo != nil && !oldInfo.IsDir() {
+		_, err = d.diffFiles(w, oldPath, "", oldInfo, nil, oldLabel, newLabel)
+		if err != nil {
+			return false, err
+		}
+		differs, oldPath = true, ""
+	}
+
+	if newInfo != nil && !newInfo.IsDir() {
+		_, err = d.diffFiles(w, "", newPath, nil, newInfo, oldLabel, newLabel)
+		if err != nil {
+			return false, err
+		}
+		differs, newPath = true, ""
+	}
+
+	dirDiffers, err := d.diffDirs(w, oldPath, newPath, oldLabel, newLabel)
+	return differs || dirDiffers, err
+}
+
+// diffDirs will compare the contents of the folders `oldPath` and
+// `newPath`, an empty path is treated as an empty folder.
+func (d *Differ) diffDirs(w io.Writer, oldPath, newPath, oldLabel, newLabel string) (bool, error) {
+	names := make(map[string]bool)
+	for _, dir := range []string{oldPath, newPath} {
+		if dir == "" {
+			continue
+		}
+
+		files, err := ioutil.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+		for _, f := range files {
+			names[f.Name()] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	join := func(dir, name string) string {
+		if dir == "" {
+			return ""
+		}
+		return filepath.Join(dir, name)
+	}
+
+	differs := false
+	for _, name := range sorted {
+		changed, err := d.Diff(w,
+			join(oldPath, name), join(newPath, name),
+			filepath.Join(oldLabel, name), filepath.Join(newLabel, name))
+		if err != nil {
+			return false, err
+		}
+		differs = differs || changed
+	}
+
+	return differs, nil
+}
+
+// diffFiles will compare the files, or symlinks, `oldPath` and `newPath`
+// with file info `oldInfo` and `newInfo`. A nil file info means the file
+// doesn't exist.
+func (d *Differ) diffFiles(w io.Writer, oldPath, newPath string, oldInfo, newInfo os.FileInfo, oldLabel, newLabel string) (bool, error) {
+	if oldInfo == nil && newInfo == nil {
+		return false, nil
+	}
+
+	oldData, err := readContent(oldPath, oldInfo)
+	if err != nil {
+		return false, err
+	}
+
+	newData, err := readContent(newPath, newInfo)
+	if err != nil {
+		return false, err
+	}
+
+	if oldInfo != nil && newInfo != nil && isSymlink(oldInfo) == isSymlink(newInfo) &&
+		bytes.Equal(oldData, newData) {
+		return false, nil
+	}
+
+	statLabel := newLabel
+	if oldInfo == nil {
+		oldLabel = os.DevNull
+	}
+	if newInfo == nil {
+		newLabel = os.DevNull
+		statLabel = oldLabel
+	}
+
+	if isBinary(oldData) || isBinary(newData) {
+		if d.Stat {
+			d.Stats = append(d.Stats, DiffStat{Label: statLabel, Binary: true})
+		} else {
+			fmt.Fprintf(w, "Binary files %s and %s differ\n", oldLabel, newLabel)
+		}
+		return true, nil
+	}
+
+	edits := diffLines(splitLines(oldData), splitLines(newData))
+
+	if d.Stat {
+		insertions, deletions := countEdits(edits)
+		d.Stats = append(d.Stats, DiffStat{
+			Label:      statLabel,
+			Insertions: insertions,
+			Deletions:  deletions,
+		})
+	} else {
+		writeUnified(w, oldLabel, newLabel, edits)
+	}
+
+	return true, nil
+}
+
+// maximum width of the bar of plus and minus signs, see WriteStat
+const diffStatWidth = 40
+
+// WriteStat will write a summary of the collected statistics to `w`, one
+// line per file followed by the totals, like `git diff --stat`.
+func (d *Differ) WriteStat(w io.Writer) {
+	width, most := 0, 0
+	for _, stat := range d.Stats {
+		if len(stat.Label) > width {
+			width = len(stat.Label)
+		}
+		if stat.Insertions+stat.Deletions > most {
+			most = stat.Insertions + stat.Deletions
+		}
+	}
+
+	insertions, deletions := 0, 0
+	for _, stat := range d.Stats {
+		if stat.Binary {
+			fmt.Fprintf(w, " %-*s | Bin\n", width, stat.Label)
+			continue
+		}
+
+		plus, minus := stat.Insertions, stat.Deletions
+		if most > diffStatWidth {
+			plus = (plus*diffStatWidth + most - 1) / most
+			minus = (minus*diffStatWidth + most - 1) / most
+		}
+
+		fmt.Fprintf(w, " %-*s | %d %s%s\n", width, stat.Label,
+			stat.Insertions+stat.Deletions,
+			strings.Repeat("+", plus), strings.Repeat("-", minus))
+
+		insertions += stat.Insertions
+		deletions += stat.Deletions
+	}
+
+	fmt.Fprintf(w, " %s changed, %s(+), %s(-)\n",
+		plural(len(d.Stats), "file"), plural(insertions, "insertion"),
+		plural(deletions, "deletion"))
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, word)
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
+
+// isBinary reports whether `data` looks like the contents of a binary file,
+// like git it checks for a null byte in the first 8000 bytes.
+func isBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+func lstatIfExists(path string) (os.FileInfo, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	f, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return f, err
+}
+
+// readContent will return the contents of a regular file, or the path a
+// symlink points to. Other files, and files that don't exist, are empty.
+func readContent(path string, f os.FileInfo) ([]byte, error) {
+	switch {
+	case f == nil:
+		return nil, nil
+	case isSymlink(f):
+		target, err := os.Readlink(path)
+		return []byte(target), err
+	case f.Mode().IsRegular():
+		return ioutil.ReadFile(path)
+	default:
+		return nil, nil
+	}
+}
+
+func isDir(f os.FileInfo) bool {
+	return f != nil && f.IsDir()
+}
+
+func isSymlink(f os.FileInfo) bool {
+	return f != nil && f.Mode()&os.ModeSymlink != 0
+}
+
+// DiffOptions holds the options that influence what DiffEntries compares
+// the targets of the entries with.
+type DiffOptions struct {
+	// compare with the backup folder instead of the files folder
+	Backup bool
+
+	// compare with the files folder in a git revision of the repository,
+	// e.g. `HEAD~1`
+	Revision string
+
+	// only show a summary of the changed files
+	Stat bool
+}
+
+// DiffEntries will write the differences between the targets of the
+// entries `names` and their versions in the repository to `w`, when
+// `names` is empty every entry is compared. Targets that are symlinked
+// into the repository are the same as the version in the files folder. It
+// reports whether there were any differences.
+func DiffEntries(w io.Writer, c *Config, names []string, opts DiffOptions) (bool, error) {
+	if len(names) == 0 {
+		names = append(c.SortedNames(), c.SortedSystemNames()...)
+	}
+
+	// the revision is verified in the repository of every layer that is
+	// compared, the layers are separate git repositories
+	var revisionDir string
+	verified := make(map[string]bool)
+	if opts.Revision != "" {
+		var err error
+		revisionDir, err = ioutil.TempDir("", "dot-diff-")
+		if err != nil {
+			return false, err
+		}
+		defer os.RemoveAll(revisionDir)
+	}
+
+	// holds the blocks, the assembled fragments and the merged keys that
+	// are compared
+	var tempDir string
+	var err error
+	d := &Differ{Stat: opts.Stat}
+	differs := false
+	for _, name := range names {
+		_, system := c.System[name]
+		if _, ok := c.Files[name]; !ok && !system {
+			return false, fmt.Errorf("'%s' is not being tracked. Get the list of tracked files with `dot list`", name)
+		}
+
+		root := c.Root()
+		if e, ok := c.Files[name]; ok {
+			root = c.LayerRoot(e.layer)
+		}
+
+		p := c.Paths(name)
+		target, repo := p.Target, p.Repo
+		relPath, _ := filepath.Rel(root, repo)
+
+		// a symlinked target is the version in the files folder
+		current := target
+		if p.Linked() {
+			current = repo
+		}
+
+		// only the block in the target is compared with the repository, the
+		// backup is a copy of the whole target
+		if e, ok := c.Files[name]; ok && e.Mode == EntryModeBlock && !opts.Backup {
+			if tempDir == "" {
+				tempDir, err = ioutil.TempDir("", "dot-diff-")
+				if err != nil {
+					return false, err
+				}
+				defer os.RemoveAll(tempDir)
+			}
+
+			current, err = extractBlockFile(c, name, p, filepath.Join(tempDir, name))
+			if err != nil {
+				return false, err
+			}
+		}
+
+		old, oldLabel := repo, relPath
+		switch {
+		case opts.Backup:
+			old = p.Backup
+			oldLabel, _ = filepath.Rel(root, old)
+		case opts.Revision != "":
+			if !verified[root] {
+				err := gitVerifyRevision(root, opts.Revision)
+				if err != nil {
+					return false, err
+				}
+				verified[root] = true
+			}
+
+			err := gitExtract(root, opts.Revision, relPath, filepath.Join(revisionDir, relPath))
+			if err != nil {
+				return false, err
+			}
+			old = filepath.Join(revisionDir, relPath)
+			oldLabel = opts.Revision + ":" + relPath
+		}
+
+		// the fragments are compared as they are assembled
+		if e, ok := c.Files[name]; ok && e.Mode == EntryModeFragments && !opts.Backup {
+			if tempDir == "" {
+				tempDir, err = ioutil.TempDir("", "dot-diff-")
+				if err != nil {
+					return false, err
+				}
+				defer os.RemoveAll(tempDir)
+			}
+
+			old, err = assembleFile(c, old, filepath.Join(tempDir, name))
+			if err != nil {
+				return false, err
+			}
+		}
+
+		// only the keys in the repository are compared, with their values
+		// in the target
+		if e, ok := c.Files[name]; ok && e.Mode == EntryModeMerge && !opts.Backup {
+			if tempDir == "" {
+				tempDir, err = ioutil.TempDir("", "dot-diff-")
+				if err != nil {
+					return false, err
+				}
+				defer os.RemoveAll(tempDir)
+			}
+
+			current, err = collectFile(c, name, old, filepath.Join(tempDir, name))
+			if err != nil {
+				return false, err
+			}
+		}
+
+		if old == current {
+			continue
+		}
+
+		changed, err := d.Diff(w, old, current, oldLabel, ContractPath(target))
+		if err != nil {
+			return false, err
+		}
+		differs = differs || changed
+	}
+
+	if opts.Stat && differs {
+		d.WriteStat(w)
+	}
+
+	return differs, nil
+}
+
+// gitVerifyRevision will return an error when `revision` isn't a commit in
+// the git repository at `dir`.
+func gitVerifyRevision(dir, revision string) error {
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", revision+"^{commit}")
+	cmd.Dir = dir
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("unknown revision: %s", revision)
+	}
+
+	return nil
+}
+
+// gitExtract will extract the file or folder `path`, relative to the git
+// repository at `dir`, as it was in `revision` to `dst`. When `path` didn't
+// exist in `revision` nothing is extracted.
+func gitExtract(dir, revision, path, dst string) error {
+	object := revision + ":./" + filepath.ToSlash(path)
+
+	cmd := exec.Command("git", "cat-file", "-t", object)
+	cmd.Dir = dir
+	kind, err := cmd.Output()
+	if err != nil {
+		// path doesn't exist in revision
+		return nil
+	}
+
+	if strings.TrimSpace(string(kind)) == "blob" {
+		cmd := exec.Command("git", "cat-file", "blob", object)
+		cmd.Dir = dir
+		data, err := cmd.Output()
+		if err != nil {
+			return fmt.Errorf("not able to read %s: %s", object, err)
+		}
+
+		err = os.MkdirAll(filepath.Dir(dst), 0700)
+		if err != nil {
+			return err
+		}
+
+		return ioutil.WriteFile(dst, data, 0600)
+	}
+
+	cmd = exec.Command("git", "archive", "--format=tar", object)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	err = extractTar(stdout, dst)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+
+	err = cmd.Wait()
+	if err != nil {
+		return fmt.Errorf("not able to read %s: %s (%s)", object,
+			strings.TrimSpace(stderr.String()), err)
+	}
+
+	return nil
+}
+
+// extractTar will extract the folders, files and symlinks of the tar
+// archive `r` into the folder `dst`.
+func extractTar(r io.Reader, dst string) error {
+	err := os.MkdirAll(dst, 0700)
+	if err != nil {
+		return err
+	}
+
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		relPath := filepath.Clean(filepath.FromSlash(header.Name))
+		if filepath.IsAbs(relPath) || IsOutsideDir(relPath) {
+			return fmt.Errorf("invalid path in archive: %s", header.Name)
+		}
+		path := filepath.Join(dst, relPath)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0700)
+		case tar.TypeSymlink:
+			err = os.Symlink(header.Linkname, path)
+		case tar.TypeReg:
+			var file *os.File
+			file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return err
+			}
+
+			_, err = io.Copy(file, archive)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
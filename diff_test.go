@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected string
+	}{
+		{"a\nb\n", "a\nb\n", ""},
+		{
+			"a\nb\nc\n", "a\nB\nc\n",
+			"--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			"", "a\n",
+			"--- a\n+++ b\n@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			"a\n", "a",
+			"--- a\n+++ b\n@@ -1 +1 @@\n-a\n+a\n\\ No newline at end of file\n",
+		},
+		{
+			// changes that are far apart end up in separate hunks
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			"one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			"--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n" +
+				"@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+		{
+			// changes that are close end up in the same hunk
+			"1\n2\n3\n4\n5\n6\n7\n8\n",
+			"one\n2\n3\n4\n5\n6\n7\neight\n",
+			"--- a\n+++ b\n@@ -1,8 +1,8 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+eight\n",
+		},
+	}
+
+	for _, test := range tests {
+		var output bytes.Buffer
+		differs := UnifiedDiff(&output, "a", "b", []byte(test.a), []byte(test.b))
+
+		if differs != (test.expected != "") {
+			t.Errorf("%q, %q: expected differs to be %t", test.a, test.b, !differs)
+		}
+
+		if output.String() != test.expected {
+			t.Errorf("%q, %q: expected:\n%s\ngot:\n%s", test.a, test.b, test.expected, output.String())
+		}
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	a := []string{"a", "b", "c", "a", "b", "b", "a"}
+	b := []string{"c", "b", "a", "b", "a", "c"}
+
+	// the example of the paper of Myers has an edit distance of 5
+	distance := 0
+	var result []string
+	for _, e := range diffLines(a, b) {
+		if e.kind != ' ' {
+			distance++
+		}
+		if e.kind != '-' {
+			result = append(result, e.line)
+		}
+	}
+
+	if distance != 5 {
+		t.Errorf("expected an edit distance of 5, got %d", distance)
+	}
+
+	if len(result) != len(b) {
+		t.Fatalf("expected %v, got %v", b, result)
+	}
+	for i := range b {
+		if result[i] != b[i] {
+			t.Errorf("expected %v, got %v", b, result)
+		}
+	}
+}
+
+func TestDiffTrees(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	old := filepath.Join(tempDir, "old")
+	new := filepath.Join(tempDir, "new")
+
+	writeTestFile(t, filepath.Join(old, "same"), "same\n")
+	writeTestFile(t, filepath.Join(new, "same"), "same\n")
+	writeTestFile(t, filepath.Join(old, "changed"), "old\n")
+	writeTestFile(t, filepath.Join(new, "changed"), "new\n")
+	writeTestFile(t, filepath.Join(old, "sub", "removed"), "removed\n")
+	writeTestFile(t, filepath.Join(new, "added"), "added\n")
+
+	var output bytes.Buffer
+	differs, err := DiffTrees(&output, old, new, "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "--- /dev/null\n+++ b/added\n@@ -0,0 +1 @@\n+added\n" +
+		"--- a/changed\n+++ b/changed\n@@ -1 +1 @@\n-old\n+new\n" +
+		"--- a/sub/removed\n+++ /dev/null\n@@ -1 +0,0 @@\n-removed\n"
+
+	if !differs || output.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, output.String())
+	}
+
+	output.Reset()
+	differs, err = DiffTrees(&output, filepath.Join(old, "same"), filepath.Join(new, "same"), "a", "b")
+	if err != nil || differs || output.Len() > 0 {
+		t.Errorf("expected no differences, got %q (%v)", output.String(), err)
+	}
+}
+
+func TestDifferStat(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	old := filepath.Join(tempDir, "old")
+	new := filepath.Join(tempDir, "new")
+
+	writeTestFile(t, filepath.Join(old, "changed"), "a\nb\nc\n")
+	writeTestFile(t, filepath.Join(new, "changed"), "a\nB\nc\nd\n")
+	writeTestFile(t, filepath.Join(old, "image"), "\x00old")
+	writeTestFile(t, filepath.Join(new, "image"), "\x00new")
+	writeTestFile(t, filepath.Join(old, "removed"), "removed\n")
+
+	var output bytes.Buffer
+	d := &Differ{Stat: true}
+	differs, err := d.Diff(&output, old, new, "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !differs || output.Len() > 0 {
+		t.Errorf("expected only statistics to be collected, got %q", output.String())
+	}
+
+	d.WriteStat(&output)
+
+	expected := " b/changed | 3 ++-\n" +
+		" b/image   | Bin\n" +
+		" a/removed | 1 -\n" +
+		" 3 files changed, 2 insertions(+), 2 deletions(-)\n"
+
+	if output.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, output.String())
+	}
+}
+
+func TestDiffBinary(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	writeTestFile(t, filepath.Join(tempDir, "old"), "\x00\x01\x02")
+	writeTestFile(t, filepath.Join(tempDir, "new"), "\x00\x01\x03")
+	writeTestFile(t, filepath.Join(tempDir, "same"), "\x00\x01\x02")
+
+	var output bytes.Buffer
+	differs, err := DiffTrees(&output, filepath.Join(tempDir, "old"), filepath.Join(tempDir, "new"), "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "Binary files a and b differ\n"; !differs || output.String() != expected {
+		t.Errorf("expected %q, got %q", expected, output.String())
+	}
+
+	output.Reset()
+	differs, err = DiffTrees(&output, filepath.Join(tempDir, "old"), filepath.Join(tempDir, "same"), "a", "b")
+	if err != nil || differs {
+		t.Errorf("expected no differences, got %q (%v)", output.String(), err)
+	}
+}
+
+func TestDiffEntries(t *testing.T) {
+	c, tempDir := newTestPlanConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	writeTestFile(t, filepath.Join(c.Root(), "backup", "both", ".both"), "backup\n")
+
+	// symlinked entries are the same as the repository
+	var output bytes.Buffer
+	differs, err := DiffEntries(&output, c, []string{"linked"}, DiffOptions{})
+	if err != nil || differs || output.Len() > 0 {
+		t.Errorf("expected no differences, got %q (%v)", output.String(), err)
+	}
+
+	output.Reset()
+	differs, err = DiffEntries(&output, c, []string{"both"}, DiffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := ContractPath(filepath.Join(tempDir, "home", ".both"))
+	expected := "--- files/both/.both\n+++ " + target + "\n@@ -1 +1 @@\n" +
+		"-repo\n\\ No newline at end of file\n+local\n\\ No newline at end of file\n"
+	if !differs || output.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, output.String())
+	}
+
+	output.Reset()
+	_, err = DiffEntries(&output, c, []string{"both"}, DiffOptions{Backup: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(output.String(), "--- backup/both/.both\n") {
+		t.Errorf("expected a diff with the backup, got:\n%s", output.String())
+	}
+
+	if _, err := DiffEntries(&output, c, []string{"unknown"}, DiffOptions{}); err == nil {
+		t.Error("expected an error for an unknown entry")
+	}
+}
+
+func TestDiffEntriesRevision(t *testing.T) {
+	c, tempDir := newTestPlanConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{
+			"-c", "user.name=dot", "-c", "user.email=dot@example.com",
+		}, args...)...)
+		cmd.Dir = c.Root()
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s (%s)", args, output, err)
+		}
+	}
+
+	git("init", "-q")
+	git("add", "-A")
+	git("commit", "-q", "-m", "initial")
+
+	writeTestFile(t, filepath.Join(c.Root(), "files", "linked", ".linked"), "changed")
+	writeTestFile(t, filepath.Join(c.Root(), "files", "missing", "sub", "file"), "new\n")
+
+	var output bytes.Buffer
+	differs, err := DiffEntries(&output, c, []string{"linked", "new"}, DiffOptions{Revision: "HEAD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := ContractPath(filepath.Join(tempDir, "home", ".linked"))
+	expected := "--- HEAD:files/linked/.linked\n+++ " + target + "\n@@ -1 +1 @@\n" +
+		"-linked\n\\ No newline at end of file\n+changed\n\\ No newline at end of file\n"
+
+	if !strings.HasPrefix(output.String(), expected) {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, output.String())
+	}
+
+	// `new` isn't in the repository, the whole folder is new
+	if !differs || !strings.Contains(output.String(), "--- /dev/null\n") {
+		t.Errorf("expected the files of new to be added, got:\n%s", output.String())
+	}
+
+	// folders are extracted as well
+	extracted, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(extracted)
+
+	dst := filepath.Join(extracted, "missing")
+	err = gitExtract(c.Root(), "HEAD", filepath.Join("files", "missing"), dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ := ioutil.ReadFile(filepath.Join(dst, ".missing"))
+	if string(content) != "missing" {
+		t.Errorf("expected the folder to be extracted, got %q", content)
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "sub")); !os.IsNotExist(err) {
+		t.Error("expected only the files of the revision to be extracted")
+	}
+
+	if _, err := DiffEntries(&output, c, nil, DiffOptions{Revision: "unknown"}); err == nil {
+		t.Error("expected an error for an unknown revision")
+	}
+}
+
+func TestDiffEntriesRevisionLayer(t *testing.T) {
+	c, tempDir := newTestLayerConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	// only the repository of the layer is a git repository
+	team := filepath.Join(tempDir, "team")
+	writeTestFile(t, filepath.Join(team, "files", "shared", ".shared"), "shared\n")
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"commit", "-q", "-m", "initial"},
+	} {
+		cmd := exec.Command("git", append([]string{
+			"-c", "user.name=dot", "-c", "user.email=dot@example.com",
+		}, args...)...)
+		cmd.Dir = team
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s (%s)", args, output, err)
+		}
+	}
+
+	target := filepath.Join(tempDir, "home", ".shared")
+	writeTestFile(t, target, "changed\n")
+	c.Files["shared"].Path = target
+
+	var output bytes.Buffer
+	differs, err := DiffEntries(&output, c, []string{"shared"}, DiffOptions{Revision: "HEAD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !differs || !strings.Contains(output.String(), "--- HEAD:files/shared/.shared\n") {
+		t.Errorf("expected the revision of the layer to be compared, got:\n%s", output.String())
+	}
+}
@@ -6,16 +6,50 @@ package main
 import (
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"log"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
 )
 
-func SyncFiles() {
+// SyncOptions holds the options that influence how SyncFiles will sync the
+// tracked entries.
+type SyncOptions struct {
+	// sync entries that are located outside the home folder, and install
+	// the system entries
+	AllowSystem bool
+
+	// number of entries that are synced concurrently
+	Jobs int
+
+	// only show what would be done
+	DryRun bool
+
+	// entries that are present on the system and in the repository replace
+	// the version in the repository, see Plan.Adopt
+	Adopt bool
+
+	// name of the privilege helper that is used to install system entries,
+	// see NewPrivilegeHelper
+	Privilege string
+
+	// directory in which the system entries will be installed, defaults
+	// to "/"
+	SystemRoot string
+
+	// only report which system entries would be installed
+	SystemDryRun bool
+}
+
+// SyncFiles will track every entry in the config file, and afterwards
+// install the system entries. Entries that point outside the home folder,
+// and system entries are skipped unless AllowSystem is set.
+//
+// Syncing happens in three phases, first the plan is created without
+// changing anything, then the user is asked all the questions that are
+// needed, and finally the plan is executed for multiple entries at once.
+// Afterwards the scripts that are pending on this machine are run, see
+// SyncScripts, and the system entries are installed.
+func SyncFiles(opts SyncOptions) {
 	PrintHeader("Syncing files ...")
 
 	// load config
@@ -27,415 +61,872 @@ func SyncFiles() {
 		return
 	}
 
-	// when we have files the sync them
-	if len(c.Files) > 0 {
-		// for every file track it
-		copyAll := false
-		for name, path := range c.Files {
-			// get full path
-			fullPath := fmt.Sprintf("%s%s", HomeDir(), path)
-			copyAll = TrackFile(name, fullPath, false, copyAll)
+	if len(c.Files) == 0 && len(c.System) == 0 {
+		PrintBodyError("there aren't any files being tracked. Begin doing so with: `dot add -name [name] -path [path]`")
+		return
+	}
+
+	plan, err := PlanSync(c, opts.AllowSystem)
+	if err != nil {
+		PrintBodyError(err.Error())
+		return
+	}
+
+	if opts.Adopt {
+		plan.Adopt()
+	}
+
+	plan.Hooks, err = NewHookRunner(c, opts.DryRun)
+	if err != nil {
+		PrintBodyError(err.Error())
+		return
+	}
+
+	if !opts.DryRun {
+		plan.Prompt()
+	}
+
+	if !executePlan(c, plan, opts) {
+		return
+	}
+
+	err = SyncScripts(c, opts.DryRun)
+	if err != nil {
+		PrintBodyError(err.Error())
+	}
+
+	// system entries are installed in a separate phase
+	if len(c.System) == 0 {
+		return
+	}
+
+	if !opts.AllowSystem {
+		PrintBodyError("skipping system entries, use -allow-system to install them")
+		return
+	}
+
+	i := &SystemInstaller{
+		Root:   opts.SystemRoot,
+		DryRun: opts.SystemDryRun || opts.DryRun,
+	}
+
+	if i.Root == "" {
+		i.Root = "/"
+	}
+
+	if !i.DryRun {
+		i.Helper, err = NewPrivilegeHelper(opts.Privilege)
+		if err != nil {
+			PrintBodyError(err.Error())
+			return
 		}
+	}
+
+	SyncSystemFiles(c, i)
+}
+
+// executePlan will execute `plan`, or print it for a dry run. The pre_sync
+// and post_sync hooks of the config `c` run before and after it, when at
+// least one entry changes. It reports whether the sync can continue.
+func executePlan(c *Config, plan *Plan, opts SyncOptions) bool {
+	hooks := &Hooks{}
+	if c.Hooks != nil {
+		hooks = c.Hooks
+	}
+
+	changed := plan.Changed()
+	env := []string{"DOT_CHANGED=" + strings.Join(changed, " ")}
+
+	if len(changed) > 0 {
+		err := plan.Hooks.Run(os.Stdout, "pre_sync", hooks.PreSync, env)
+		if err != nil {
+			PrintBodyError(err.Error())
+			if plan.Hooks.Stop() {
+				return false
+			}
+		}
+	}
+
+	if opts.DryRun {
+		plan.Print(os.Stdout)
 	} else {
-		PrintBodyError("there aren't any files being tracked. Begin doing so with: `dot add -name [name] -path [path]`")
+		plan.Execute(os.Stdout, opts.Jobs)
 	}
+
+	if len(changed) > 0 {
+		err := plan.Hooks.Run(os.Stdout, "post_sync", hooks.PostSync, env)
+		if err != nil {
+			PrintBodyError(err.Error())
+		}
+	}
+
+	return true
 }
 
 // TrackFile will track an individual file, meaning, it will move the original
 // file to either the files or backup folder. It will the create a symlink of
 // the file in the original location. `name` will be used as the name of the
-// folder and key in the config file. `fullPath` has to be the absolute path
-// to the file to be tracked.
-//
-// TrackFile can be called from two contexes:
+// folder and key in the config file. The path of the entry `e` points to the
+// file to be tracked, the include and exclude patterns of `e` decide which
+// paths of a tracked folder will end up in the files folder. See PlanEntry
+// for the distinction between a new file and a file that is already being
+// tracked.
 //
-//  1. From SyncFiles, it will read all the tracked files from the config and
-//     make track files if necessary.
-//
-//  2. From CommandAdd, this will add a new file for tracking
-//
-// TrackFile will make a distinction between a new file and a file that is
-// already been tracked:
-//
-//  1. TrackFile can't find the symlink, but the file is present in the
-//     dot_path (the folder that holds all the original files). Then we need to
-//     relink it, thus creating a symlink at the correct location. This happens
-//     we you run dot on a new 'additional machine'.
-//
-//  2. TrackFile can't find the symlink, and the file is also not present in
-//     the dot_path folder. This will mean that it is a new file were are going
-//     to track. So we copy the file to the files folder, create a symlink, and
-//     add an entry to the config file.
-func TrackFile(name string, fullPath string, push bool, copyAll bool) bool {
+// When a new file is tracked, an entry is added to the config file.
+func TrackFile(name string, e *Entry, push bool) error {
+	return TrackFiles([]string{name}, []*Entry{e}, push)
+}
+
+// TrackFiles will track every entry of `entries` like TrackFile does,
+// `names` holds the name of every entry. When a name is empty it's derived
+// from the path of the entry, see Config.NewName. The config is saved, and
+// the changes are pushed, once for all entries. When an entry can't be
+// tracked the others still are.
+func TrackFiles(names []string, entries []*Entry, push bool) error {
 	// load config
 	c, err := NewConfig(PathDotConfig)
 	if err != nil {
-		PrintBodyError("not able to find .dotconfig")
-		return copyAll
+		return errors.New("not able to find .dotconfig")
 	}
 
-	// Base
-	base := path.Base(fullPath)
+	var added, layers []string
+	failures := 0
+	for i, e := range entries {
+		layer, err := c.Layer(e.layer)
+		if err != nil {
+			return err
+		}
+		e.layer = layer
 
-	// get relative path
-	relPath, err := GetRelativePath(fullPath)
-	if err != nil {
-		PrintBodyError(err.Error())
-		return copyAll
-	}
+		name := names[i]
+		if name == "" {
+			name = c.NewName(e.Path)
+		}
 
-	// check if path is present
-	_, err = os.Stat(fullPath)
-	if err != nil {
-		PrintBodyError(fmt.Sprintf("file not present on system: %s", fullPath))
+		tracked, err := trackEntry(c, name, e)
+		if err != nil && len(entries) == 1 {
+			return err
+		}
+		if err != nil {
+			PrintBodyError(fmt.Sprintf("%s: %s", name, err))
+			failures++
+			continue
+		}
 
-		if copyAll {
-			src := fmt.Sprintf("%s%s/files/%s/%s", HomeDir(), c.DotPath, name, base)
-			MakeAndCopyToDir(src, fullPath)
-			return TrackFile(name, fullPath, push, copyAll)
+		if tracked {
+			// create entry in .dotconfig file
+			c.Files[name] = e
+			added = append(added, name)
+			layers = append(layers, e.layer)
 		}
+	}
 
-		PrintBody("Copy file(s) to its destination? [All/Y/N]")
-		var input string
-		_, err := fmt.Scan(&input)
+	if len(added) > 0 {
+		err = c.Save()
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
-		switch input {
-		case "All":
-			copyAll = true
-			src := fmt.Sprintf("%s%s/files/%s/%s", HomeDir(), c.DotPath, name, base)
-			err := MakeAndCopyToDir(src, fullPath)
-			if err != nil {
-				PrintBodyError(err.Error())
-				return copyAll
-			}
-			return TrackFile(name, fullPath, push, copyAll)
-		case "Y":
-			src := fmt.Sprintf("%s%s/files/%s/%s", HomeDir(), c.DotPath, name, base)
-			err := MakeAndCopyToDir(src, fullPath)
-			if err != nil {
-				PrintBodyError(err.Error())
-				return copyAll
-			}
-			return TrackFile(name, fullPath, push, copyAll)
-		case "N":
-			PrintBodyError(fmt.Sprintf("Ignoring %s", name))
-			return copyAll
-		default:
-			PrintBodyError("Invalid input")
-			return copyAll
+		// push changes to repository
+		if push {
+			PushEntries(c, added, layers, "add")
 		}
 	}
 
-	// check if path is already symlinked
-	s, err := os.Lstat(fullPath)
-	if err != nil {
-		return copyAll
+	if failures > 0 {
+		return fmt.Errorf("not able to track %d of the %d paths", failures, len(entries))
 	}
 
-	if s.Mode()&os.ModeSymlink == os.ModeSymlink {
-		PrintBody(fmt.Sprintf("%s is already symlinked", name))
-		return copyAll
+	return nil
+}
+
+// trackEntry will track the entry `e` with the name `name`, it reports
+// whether the entry has to be added to the config `c`.
+func trackEntry(c *Config, name string, e *Entry) (bool, error) {
+	if err := ValidateName(name); err != nil {
+		return false, err
 	}
 
-	repoPath := fmt.Sprintf("%s%s/files/%s/", HomeDir(), c.DotPath, name)
-	if _, err := os.Stat(repoPath); err == nil {
-		// no symlink found, already in repo => additional machine
-		PrintBody(fmt.Sprintf("Symlinking: %s", name))
+	for _, dep := range e.After {
+		if _, ok := c.Files[dep]; !ok {
+			return false, fmt.Errorf("'%s' can't be synced after '%s', it isn't being tracked", name, dep)
+		}
+	}
 
-		// put in backup folder, set named folder based on `name`, e.g.:
-		// `/home/jpbruinsslot/dotfiles/backup/[name]/[base]`
-		dst := fmt.Sprintf("%s%s/backup/%s/%s", HomeDir(), c.DotPath, name, base)
-		err = MakeAndMoveToDir(fullPath, dst)
+	if e.Mode == EntryModeBlock {
+		err := trackBlock(c, name, e)
 		if err != nil {
-			msg := fmt.Sprintf("not able to move files to %s (%s)", dst, err)
-			PrintBodyError(msg)
+			return false, err
+		}
 
-			prompt := fmt.Sprintf("Remove %s ? [Y/N]", dst)
-			PrintBody(prompt)
-			var input string
-			_, err := fmt.Scan(&input)
-			if err != nil {
-				log.Fatal(err)
-			}
+		e.Path = ContractPath(ExpandPath(e.Path))
 
-			if input == "Y" {
-				err := os.RemoveAll(dst)
-				if err != nil {
-					log.Fatal(err)
-				}
-
-				err = MakeAndMoveToDir(fullPath, dst)
-				if err != nil {
-					log.Fatal(err)
-				}
-			} else {
-				msg := fmt.Sprintf("ignoring %s", name)
-				PrintBodyError(msg)
-				return copyAll
-			}
+		// insert the block, which is empty unless it was present already
+		plan := &Plan{Steps: []*Step{PlanEntry(c, name, e)}}
+		if plan.Execute(os.Stdout, 1) > 0 {
+			return false, fmt.Errorf("not able to track %s", name)
 		}
 
-		// trim potential trailing slash for symlink
-		fullPath = strings.TrimRight(fullPath, "/")
+		return true, nil
+	}
 
-		// create symlink (os.Symlink(oldname, newname))
-		dst = fmt.Sprintf("%s%s/files/%s/%s", HomeDir(), c.DotPath, name, base)
-		err = os.Symlink(dst, fullPath)
+	if e.Mode == EntryModeFragments {
+		err := trackFragments(c, name, e)
 		if err != nil {
-			log.Fatal(err)
+			return false, err
 		}
 
-	} else {
-		// no symlink found, not in repo => new entry
-		PrintBody(fmt.Sprintf("Symlinking: %s", name))
+		e.Path = ContractPath(ExpandPath(e.Path))
+		return true, nil
+	}
 
-		// put in files folder, set named folder based on `name`, e.g.:
-		// `/home/jpbruinsslot/dotfiles/files/[name]/[base]`
-		dst := fmt.Sprintf("%s%s/files/%s/%s", HomeDir(), c.DotPath, name, base)
-		err = MakeAndMoveToDir(fullPath, dst)
+	if e.Mode == EntryModeMerge {
+		err := trackMerge(c, name, e)
 		if err != nil {
-			log.Fatal(err)
+			return false, err
 		}
 
-		// trim potential trailing slash for symlink
-		fullPath = strings.TrimRight(fullPath, "/")
+		e.Path = ContractPath(ExpandPath(e.Path))
+		return true, nil
+	}
 
-		// create symlink (os.Symlink(oldname, newname))
-		err = os.Symlink(dst, fullPath)
-		if err != nil {
-			log.Fatal(err)
+	// entries inside the new entry can be merged into it, other overlaps
+	// aren't allowed
+	children, err := CheckOverlap(c, name, ExpandPath(e.Path))
+	if err != nil {
+		return false, err
+	}
+
+	for _, o := range children {
+		question := fmt.Sprintf("%s %s, merge it into '%s'? [Y/N]", e.Path, o, name)
+		if Ask(question) != "Y" {
+			return false, fmt.Errorf("not able to track %s, it %s", e.Path, o)
 		}
 
-		// create entry in .dotconfig file
-		c.Files[name] = relPath
-		c.Save()
+		PrintBody(fmt.Sprintf("Merging %s into %s", o.Name, name))
+		err = MergeEntry(c, o.Name, name)
+		if err != nil {
+			return false, err
+		}
+	}
 
-		// push changes to repository
-		if push {
-			GitCommitPush(name, "add")
+	// the merged entries are gone from the repository already
+	if len(children) > 0 {
+		err = c.Save()
+		if err != nil {
+			return false, err
 		}
 	}
 
-	return copyAll
+	plan := &Plan{Steps: []*Step{PlanEntry(c, name, e)}}
+	plan.Prompt()
+	if plan.Execute(os.Stdout, 1) > 0 {
+		return false, fmt.Errorf("not able to track %s", name)
+	}
+
+	step := plan.Steps[0]
+	if step.Action != ActionMoveAndLink {
+		return false, nil
+	}
+
+	e.Path = ContractPath(step.Target)
+	return true, nil
 }
 
-// UntrackFile will remove a file from tracking. `name` will be the key
-// in the config file that points to the initial location of the file
-func UntrackFile(name string, push bool) {
-	// open config file
-	c, err := NewConfig(fmt.Sprintf("%s/%s", HomeDir(), ConfigFileName))
+// AdoptFile will replace the version of the entry `name` in the repository
+// with the version on the system, after showing the differences between
+// the two. The version in the repository is moved to the backup folder.
+func AdoptFile(name string) error {
+	c, err := NewConfig(PathDotConfig)
 	if err != nil {
-		PrintBodyError("not able to find .dotconfig")
-		return
+		return errors.New("not able to find .dotconfig")
 	}
 
-	// check if `name` is present in c.Files
-	path := c.Files[name]
-	if path == "" {
-		PrintBodyError(
-			fmt.Sprintf(
-				"'%s' is not being tracked. Get the list of tracked files with `dot list`",
-				name,
-			),
-		)
-		return
+	e := c.Files[name]
+	if e == nil {
+		return fmt.Errorf("'%s' is not being tracked. Get the list of tracked files with `dot list`", name)
 	}
 
-	// check if path (the symlink) is present
-	pathSymlink := fmt.Sprintf("%s%s", HomeDir(), path)
-	f, err := os.Lstat(pathSymlink)
-	if err != nil {
-		PrintBodyError(fmt.Sprintf("not able to find: %s", path))
-		return
+	switch e.Mode {
+	case EntryModeBlock:
+		return adoptBlock(c, name)
+	case EntryModeFragments:
+		return fmt.Errorf("'%s' is assembled from fragments, change the fragments in %s instead", name, c.Paths(name).Repo)
+	case EntryModeMerge:
+		return fmt.Errorf("'%s' merges keys, use `dot collect %s` instead", name, name)
 	}
 
-	// check if path is symlink
-	if f.Mode()&os.ModeSymlink != os.ModeSymlink {
-		PrintBodyError(fmt.Sprintf("%s is not a symlink", path))
-		return
+	step := PlanEntry(c, name, e)
+	switch step.Action {
+	case ActionBackupAndLink:
+	case ActionNone:
+		return fmt.Errorf("%s is already symlinked", step.Target)
+	case ActionLink:
+		return fmt.Errorf("%s isn't present on the system", step.Target)
+	case ActionMoveAndLink:
+		return fmt.Errorf("%s isn't present in the repository, use `dot sync` instead", step.Target)
+	default:
+		return errors.New(step.Reason)
 	}
 
-	// check if src is present
-	src := fmt.Sprintf("%s%s/files/%s%s", HomeDir(), c.DotPath, name, path)
-	if _, err = os.Stat(src); err != nil {
-		PrintBodyError(fmt.Sprintf("not able to find %s", src))
-		return
+	plan := &Plan{Steps: []*Step{step}}
+	plan.Adopt()
+	plan.Prompt()
+	if plan.Execute(os.Stdout, 1) > 0 {
+		return fmt.Errorf("not able to adopt %s", name)
 	}
 
-	// remove symlink
-	err = os.Remove(pathSymlink)
+	return nil
+}
+
+// MoveFile will rename the entry `name` to `newName`, and/or move its target
+// to `newPath`, an empty value leaves it as it is. The files in the
+// repository are renamed and the symlink is replaced by one at the new
+// location.
+func MoveFile(name, newName, newPath string, allowSystem bool) error {
+	c, err := NewConfig(PathDotConfig)
 	if err != nil {
-		PrintBodyError(fmt.Sprintf("not able to remove %s", pathSymlink))
-		return
+		return errors.New("not able to find .dotconfig")
 	}
 
-	// move the file or directory
-	dst := fmt.Sprintf("%s%s", HomeDir(), path)
+	e := c.Files[name]
+	if e == nil {
+		return fmt.Errorf("'%s' is not being tracked. Get the list of tracked files with `dot list`", name)
+	}
 
-	PrintBody(fmt.Sprintf("Moving %s back to %s", name, dst))
+	if name == "dotconfig" {
+		return errors.New("the dotconfig entry can't be moved")
+	}
 
-	err = MakeAndCopyToDir(src, dst)
-	if err != nil {
-		log.Fatal(err)
+	switch e.Mode {
+	case EntryModeBlock:
+		return fmt.Errorf("'%s' is a managed block, remove it and add it again instead", name)
+	case EntryModeFragments:
+		return fmt.Errorf("'%s' is assembled from fragments, remove it and add it again instead", name)
+	case EntryModeMerge:
+		return fmt.Errorf("'%s' merges keys, remove it and add it again instead", name)
 	}
 
-	// remove tracked files from repo dir
-	entry := fmt.Sprintf("%s%s/files/%s", HomeDir(), c.DotPath, name)
-	err = os.RemoveAll(entry)
-	if err != nil {
-		log.Fatal(err)
+	if newName == "" {
+		newName = name
 	}
 
-	// remove entry from config and save config
-	delete(c.Files, name)
-	c.Save()
+	if err := ValidateName(newName); err != nil {
+		return err
+	}
 
-	// push changes to repository
-	if push {
-		GitCommitPush(name, "rm")
+	if _, ok := c.System[newName]; ok || (newName != name && c.Files[newName] != nil) {
+		return fmt.Errorf("'%s' is already being tracked", newName)
 	}
-}
 
-// MakeAndMoveToDir will move the source file/folder `src` to the destination
-// `dst` (`dst` will be absolute path to the destination).
-func MakeAndMoveToDir(src string, dst string) error {
-	err := MakeAndCopyToDir(src, dst)
-	if err != nil {
-		return err
+	paths := c.Paths(name)
+	target := paths.Target
+	newTarget := target
+	if newPath != "" {
+		newTarget, err = filepath.Abs(ExpandPath(newPath))
+		if err != nil {
+			return err
+		}
 	}
 
-	err = os.RemoveAll(src)
-	if err != nil {
-		return err
+	if newName == name && newTarget == target {
+		return errors.New("nothing to move, pass a new name or path")
 	}
 
-	return nil
-}
+	newPaths := c.LayerPaths(e.layer, newName, newTarget)
 
-func MakeAndCopyToDir(src string, dst string) error {
-	// folder or file
-	f, err := os.Stat(src)
-	if err != nil {
-		return err
+	// is the target symlinked into the repository
+	linked := paths.Linked()
+
+	if newTarget != target {
+		err = CheckTarget(newTarget, allowSystem)
+		if err != nil {
+			return err
+		}
+
+		overlaps, err := checkOverlap(c, name, newTarget)
+		if err != nil {
+			return err
+		}
+		if len(overlaps) > 0 {
+			return fmt.Errorf("not able to move to %s, it %s", newTarget, overlaps[0])
+		}
+
+		if _, err := os.Lstat(newTarget); err == nil {
+			return fmt.Errorf("%s is already present", newTarget)
+		}
+
+		if _, err := os.Lstat(target); err == nil && !linked {
+			return fmt.Errorf("%s isn't symlinked, sync or adopt '%s' first", target, name)
+		}
+	}
+
+	// rename the folders of the entry, then the file or folder inside
+	if newName != name {
+		folders := [][2]string{
+			{paths.RepoDir, newPaths.RepoDir},
+			{paths.BackupDir, newPaths.BackupDir},
+		}
+		for _, folder := range folders {
+			src, dst := folder[0], folder[1]
+			if _, err := os.Lstat(src); os.IsNotExist(err) {
+				continue
+			}
+
+			PrintBody(fmt.Sprintf("Moving %s to %s", src, dst))
+			err = MakeAndMoveToDir(src, dst)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
-	if f.IsDir() {
-		err = CopyDir(src, dst)
+	// the files of the entry have the new name, but the old base name
+	oldPaths := c.LayerPaths(e.layer, newName, target)
+	if oldPaths.Repo != newPaths.Repo {
+		if _, err := os.Lstat(oldPaths.Repo); err == nil {
+			err = MakeAndMoveToDir(oldPaths.Repo, newPaths.Repo)
+			if err != nil {
+				return err
+			}
+		}
+
+		if _, err := os.Lstat(oldPaths.Backup); err == nil {
+			err = MakeAndMoveToDir(oldPaths.Backup, newPaths.Backup)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// replace the symlink
+	if linked {
+		PrintBody(fmt.Sprintf("Symlinking %s to %s", newTarget, newPaths.Repo))
+
+		err = os.Remove(target)
 		if err != nil {
 			return err
 		}
-	} else {
-		// get directory
-		dir, _ := filepath.Split(dst)
 
-		// create destination dir
-		// err = os.MkdirAll(dir, f.Mode())
-		err = os.MkdirAll(dir, 0755)
+		err = makeParents(newPaths.Repo, newTarget)
 		if err != nil {
 			return err
 		}
 
-		// rename the file
-		err = CopyFile(src, dst)
+		err = os.Symlink(newPaths.Repo, newTarget)
 		if err != nil {
 			return err
 		}
 	}
 
-	return nil
+	e.Path = ContractPath(newTarget)
+	delete(c.Files, name)
+	c.Files[newName] = e
+
+	c.RenameDependency(name, newName)
+
+	// the .gitignore refers to the tracked folder by name
+	if ignore, err := c.Ignore(e); err == nil {
+		if f, err := os.Stat(newPaths.Repo); err == nil && f.IsDir() {
+			WriteEntryGitIgnore(newPaths.RepoDir, filepath.Base(newPaths.Repo), ignore)
+		}
+	}
+
+	return c.Save()
+}
+
+// UntrackMode decides what happens with the files of an entry that is
+// removed from tracking
+type UntrackMode int
+
+const (
+	// the files in the repository are moved back to the original location,
+	// and the entry is removed from the repository
+	UntrackRestore UntrackMode = iota
+
+	// the symlink is replaced by a copy of the files in the repository, the
+	// entry remains in the repository for the other machines
+	UntrackUnlink
+
+	// the entry and its files are removed from the repository, without
+	// restoring them
+	UntrackForget
+
+	// the version in the backup folder is moved back to the original
+	// location, and the entry is removed from the repository
+	UntrackBackup
+)
+
+// String will return a description of the mode, used for dry runs
+func (m UntrackMode) String() string {
+	switch m {
+	case UntrackUnlink:
+		return "replace symlink with a copy, keep in repository"
+	case UntrackForget:
+		return "remove from repository without restoring"
+	case UntrackBackup:
+		return "restore backup and remove from repository"
+	default:
+		return "move back and remove from repository"
+	}
 }
 
-func CopyDir(src string, dst string) error {
-	src = filepath.Clean(src)
-	dst = filepath.Clean(dst)
+// question will return the confirmation that is asked before the entries
+// `names` are removed
+func (m UntrackMode) question(names []string) string {
+	list := strings.Join(names, ", ")
+
+	switch m {
+	case UntrackUnlink:
+		return fmt.Sprintf("Replace the symlinks of %s with a copy of their files? They "+
+			"remain in the repository [Y/N]", list)
+	case UntrackForget:
+		return fmt.Sprintf("Remove %s and their files from the repository, without "+
+			"restoring them? [Y/N]", list)
+	case UntrackBackup:
+		return fmt.Sprintf("Remove %s from the repository and restore their "+
+			"backups? [Y/N]", list)
+	default:
+		return fmt.Sprintf("Move the files of %s back to their original location and "+
+			"remove them from the repository? [Y/N]", list)
+	}
+}
+
+// UntrackOptions are the options for UntrackFiles
+type UntrackOptions struct {
+	// what happens with the files of the entries
+	Mode UntrackMode
 
-	// Check src
-	f, err := os.Stat(src)
+	// only show what would be done
+	DryRun bool
+
+	// don't ask for confirmation
+	Yes bool
+
+	// commit and push the changes to the repository
+	Push bool
+}
+
+// UntrackFiles will remove the entries that match `patterns` from
+// tracking, a pattern is either the name of an entry or a glob pattern,
+// e.g. `vim*`. Every entry is checked before anything is changed, the
+// config is saved, and the changes are pushed, once for all entries.
+func UntrackFiles(patterns []string, opts UntrackOptions) error {
+	// open config file
+	c, err := NewConfig(PathDotConfig)
+	if err != nil {
+		return errors.New("not able to find .dotconfig")
+	}
+
+	names, err := c.MatchNames(patterns)
 	if err != nil {
 		return err
 	}
 
-	if !f.IsDir() {
-		return errors.New("source is not a directory")
+	// make sure every entry can be removed before changing anything
+	for _, name := range names {
+		err = checkUntrack(c, name, opts.Mode)
+		if err != nil {
+			return err
+		}
 	}
 
-	// Check dst
-	_, err = os.Stat(dst)
-	if err != nil && !os.IsNotExist(err) {
+	hooks, err := NewHookRunner(c, opts.DryRun)
+	if err != nil {
+		return err
+	}
+
+	if opts.DryRun {
+		for _, name := range names {
+			PrintBody(fmt.Sprintf("%s: %s", name, opts.Mode))
+			if e, ok := c.Files[name]; ok && e.Hooks != nil {
+				hooks.Run(os.Stdout, "pre_unlink", e.Hooks.PreUnlink, nil)
+			}
+		}
 		return nil
 	}
-	if err == nil {
-		return errors.New("dst already exist")
+
+	if !opts.Yes && Ask(opts.Mode.question(names)) != "Y" {
+		PrintBody("Leaving the entries untouched")
+		return nil
 	}
 
-	files, err := ioutil.ReadDir(src)
-	if err != nil {
+	var removed, layers []string
+	for _, name := range names {
+		layer := ""
+		if e, ok := c.Files[name]; ok {
+			layer = e.layer
+		}
+
+		err = untrackEntry(c, name, opts.Mode, hooks)
+		if err != nil {
+			break
+		}
+		removed = append(removed, name)
+		layers = append(layers, layer)
+	}
+
+	// the entries remain in the repository, there is nothing to save
+	if opts.Mode == UntrackUnlink {
 		return err
 	}
 
-	os.MkdirAll(dst, f.Mode())
+	// save the entries that were removed, even when one of them failed
+	if len(removed) > 0 {
+		if saveErr := c.Save(); saveErr != nil {
+			return saveErr
+		}
+	}
+
 	if err != nil {
 		return err
 	}
 
-	for _, file := range files {
-		srcPath := filepath.Join(src, file.Name())
-		dstPath := filepath.Join(dst, file.Name())
+	// push changes to repository
+	if opts.Push {
+		PushEntries(c, removed, layers, "rm")
+	}
 
-		if file.IsDir() {
-			err = CopyDir(srcPath, dstPath)
-			if err != nil {
-				return err
-			}
-		} else {
-			if file.Mode()&os.ModeSymlink != 0 {
-				continue
-			}
+	return nil
+}
 
-			err = CopyFile(srcPath, dstPath)
-			if err != nil {
-				return err
-			}
+// PushEntries will commit and push the changes to the entries `names`, the
+// entry `names[i]` belongs to the layer `layers[i]`. The changes are pushed
+// to the repository of every layer, see GitCommitPush.
+func PushEntries(c *Config, names, layers []string, action string) {
+	var roots []string
+	byRoot := make(map[string][]string)
+	for i, name := range names {
+		root := c.LayerRoot(layers[i])
+		if _, ok := byRoot[root]; !ok {
+			roots = append(roots, root)
 		}
+		byRoot[root] = append(byRoot[root], name)
 	}
 
-	return nil
+	for _, root := range roots {
+		GitCommitPush(root, strings.Join(byRoot[root], ", "), action)
+	}
 }
 
-func CopyFile(src string, dst string) error {
-	inFile, err := os.Open(src)
-	if err != nil {
-		return err
+// checkUntrack will return an error when the entry `name` can't be removed
+// from tracking with the mode `mode`
+func checkUntrack(c *Config, name string, mode UntrackMode) error {
+	if name == "dotconfig" {
+		return errors.New("the dotconfig entry can't be removed")
 	}
-	defer inFile.Close()
 
-	outFile, err := os.Create(dst)
-	if err != nil {
+	// system entries are installed as a copy and don't have a backup
+	if _, ok := c.System[name]; ok {
+		if mode == UntrackUnlink || mode == UntrackBackup {
+			return fmt.Errorf("'%s' is a system entry, it can only be removed or forgotten", name)
+		}
+		return nil
+	}
+
+	// the rest of the target of a block or of merged keys isn't owned by
+	// dot, and an assembled target isn't a symlink
+	switch c.Files[name].Mode {
+	case EntryModeBlock, EntryModeFragments, EntryModeMerge:
+		if mode == UntrackUnlink || mode == UntrackBackup {
+			return fmt.Errorf("'%s' is a %s entry, it can only be removed or forgotten", name, c.Files[name].Mode)
+		}
+		return nil
+	}
+
+	switch mode {
+	case UntrackForget:
+		return nil
+	case UntrackBackup:
+		p := c.Paths(name)
+		if f, err := os.Lstat(p.Target); err == nil && f.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("%s is not a symlink", c.Files[name].Path)
+		}
+
+		if _, err := os.Lstat(p.Backup); err != nil {
+			return fmt.Errorf("there is no backup of '%s'", name)
+		}
+		return nil
+	default:
+		_, err := untrackPaths(c, name)
 		return err
 	}
-	defer outFile.Close()
+}
+
+// untrackPaths will return the locations of the entry `name`. An error is
+// returned when the entry can't be untracked, because its target isn't
+// symlinked to its files in the repository.
+func untrackPaths(c *Config, name string) (EntryPaths, error) {
+	e := c.Files[name]
+	p := c.Paths(name)
 
-	_, err = io.Copy(outFile, inFile)
+	// check if path (the symlink) is present
+	f, err := os.Lstat(p.Target)
 	if err != nil {
-		return err
+		return p, fmt.Errorf("not able to find: %s", e.Path)
+	}
+
+	// check if path is symlink
+	if f.Mode()&os.ModeSymlink != os.ModeSymlink {
+		return p, fmt.Errorf("%s is not a symlink", e.Path)
+	}
+
+	if !p.Linked() {
+		return p, fmt.Errorf("%s is not symlinked to %s", e.Path, p.Repo)
+	}
+
+	// check if src is present
+	if _, err = os.Stat(p.Repo); err != nil {
+		return p, fmt.Errorf("not able to find %s", p.Repo)
+	}
+
+	return p, nil
+}
+
+// untrackEntry will remove the entry `name` from tracking with the mode
+// `mode`, it's removed from the config `c` unless the mode is
+// UntrackUnlink. The pre_unlink hook of the entry is run with `hooks`
+// first. The config isn't saved.
+func untrackEntry(c *Config, name string, mode UntrackMode, hooks *HookRunner) error {
+	p := c.Paths(name)
+
+	// system entries are installed as a copy, so we only have to remove
+	// them from the repo, the installed file is left untouched
+	if e, ok := c.System[name]; ok {
+		PrintBody(fmt.Sprintf("%s will be left untouched", e.Path))
+
+		err := c.RemoveAll(p.RepoDir)
+		if err != nil {
+			return err
+		}
+
+		delete(c.System, name)
+		return nil
+	}
+
+	if e := c.Files[name]; e.Hooks != nil {
+		err := hooks.Run(os.Stdout, "pre_unlink", e.Hooks.PreUnlink, entryHookEnv(name, p))
+		if err != nil && hooks.Stop() {
+			return err
+		}
+		if err != nil {
+			PrintBodyError(err.Error())
+		}
+	}
+
+	// only the block is removed from the target of a managed block, when
+	// it's forgotten the block is left as it is
+	if c.Files[name].Mode == EntryModeBlock {
+		if mode != UntrackForget {
+			err := removeBlock(c, name, p)
+			if err != nil {
+				return err
+			}
+		}
+		mode = UntrackForget
+	}
+
+	// the assembled target remains as it is
+	if c.Files[name].Mode == EntryModeFragments {
+		err := recordFragmentTarget(p.Target, "")
+		if err != nil {
+			return err
+		}
+		mode = UntrackForget
+	}
+
+	// the merged keys remain in the target, the application may rely on
+	// them
+	if c.Files[name].Mode == EntryModeMerge {
+		mode = UntrackForget
+	}
+
+	switch mode {
+	case UntrackUnlink:
+		p, err := untrackPaths(c, name)
+		if err != nil {
+			return err
+		}
+
+		err = os.Remove(p.Target)
+		if err != nil {
+			return fmt.Errorf("not able to remove %s", p.Target)
+		}
+
+		PrintBody(fmt.Sprintf("Replacing the symlink %s with a copy of %s", p.Target, name))
+		return MakeAndCopyToDir(p.Repo, p.Target)
+	case UntrackForget:
+		err := removeSymlink(p)
+		if err != nil {
+			return err
+		}
+
+		PrintBody(fmt.Sprintf("Removing %s from the repository", name))
+	case UntrackBackup:
+		err := removeSymlink(p)
+		if err != nil {
+			return err
+		}
+
+		PrintBody(fmt.Sprintf("Restoring the backup of %s to %s", name, p.Target))
+		err = MakeAndMoveToDir(p.Backup, p.Target)
+		if err != nil {
+			return err
+		}
+
+		err = c.RemoveAll(p.BackupDir)
+		if err != nil {
+			return err
+		}
+	default:
+		p, err := untrackPaths(c, name)
+		if err != nil {
+			return err
+		}
+
+		// remove symlink
+		err = os.Remove(p.Target)
+		if err != nil {
+			return fmt.Errorf("not able to remove %s", p.Target)
+		}
+
+		PrintBody(fmt.Sprintf("Moving %s back to %s", name, p.Target))
+
+		// move the file or directory
+		err = MakeAndCopyToDir(p.Repo, p.Target)
+		if err != nil {
+			return err
+		}
 	}
 
-	err = outFile.Sync()
+	// remove tracked files from repo dir
+	err := c.RemoveAll(p.RepoDir)
 	if err != nil {
 		return err
 	}
 
-	f, err := os.Stat(src)
+	// remove entry from config
+	delete(c.Files, name)
+	return nil
+}
+
+// removeSymlink will remove the symlink at the target of `p`, when it
+// points into the repository. Anything else at the location is left
+// untouched.
+func removeSymlink(p EntryPaths) error {
+	link, err := os.Readlink(p.Target)
 	if err != nil {
-		return err
+		return nil
 	}
 
-	err = os.Chmod(dst, f.Mode())
+	if link != p.RepoDir && !strings.HasPrefix(link, p.RepoDir+string(filepath.Separator)) {
+		return nil
+	}
+
+	PrintBody(fmt.Sprintf("Removing the symlink %s", p.Target))
+	err = os.Remove(p.Target)
 	if err != nil {
-		return err
+		return fmt.Errorf("not able to remove %s", p.Target)
 	}
 
 	return nil
@@ -143,3 +143,344 @@ func TestMakeAndMoveToDirFile(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+// useTestConfig will save the config `c` and make it the config that is
+// used by the commands, the returned function restores the original.
+func useTestConfig(t *testing.T, c *Config) func() {
+	err := c.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pathDotConfig := PathDotConfig
+	PathDotConfig = c.path
+
+	return func() { PathDotConfig = pathDotConfig }
+}
+
+func TestMoveFile(t *testing.T) {
+	c, tempDir := newTestPlanConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	home := filepath.Join(tempDir, "home")
+	c.Files["after"] = &Entry{Path: filepath.Join(home, ".after"), After: []string{"linked"}}
+	writeTestFile(t, filepath.Join(c.Root(), "backup", "linked", ".linked"), "backup")
+	defer useTestConfig(t, c)()
+
+	// rename
+	err := MoveFile("linked", "renamed", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := filepath.Join(c.Root(), "files", "renamed", ".linked")
+	if target, err := os.Readlink(filepath.Join(home, ".linked")); err != nil || target != repo {
+		t.Errorf("expected symlink to %s, got %s (%v)", repo, target, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(c.Root(), "backup", "renamed", ".linked")); err != nil {
+		t.Errorf("expected the backup to be renamed: %s", err)
+	}
+
+	// move to another path
+	newTarget := filepath.Join(home, ".config", "linked", "config")
+	err = MoveFile("renamed", "", newTarget, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo = filepath.Join(c.Root(), "files", "renamed", "config")
+	if target, err := os.Readlink(newTarget); err != nil || target != repo {
+		t.Errorf("expected symlink to %s, got %s (%v)", repo, target, err)
+	}
+
+	if _, err := os.Lstat(filepath.Join(home, ".linked")); !os.IsNotExist(err) {
+		t.Error("expected the old symlink to be removed")
+	}
+
+	content, _ := ioutil.ReadFile(newTarget)
+	if string(content) != "linked" {
+		t.Errorf("expected the contents to be moved, got %q", content)
+	}
+
+	c, err = NewConfig(c.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e := c.Files["renamed"]; e == nil || e.Path != newTarget {
+		t.Errorf("expected renamed to point to %s, got %v", newTarget, e)
+	}
+
+	if _, ok := c.Files["linked"]; ok {
+		t.Error("expected linked to be removed from the config")
+	}
+
+	if after := c.Files["after"].After; len(after) != 1 || after[0] != "renamed" {
+		t.Errorf("expected after to be synced after renamed, got %v", after)
+	}
+}
+
+func TestMoveFileErrors(t *testing.T) {
+	c, tempDir := newTestPlanConfig(t)
+	defer os.RemoveAll(tempDir)
+	defer useTestConfig(t, c)()
+
+	home := filepath.Join(tempDir, "home")
+
+	tests := []struct {
+		name, newName, newPath string
+	}{
+		{"unknown", "new-name", ""},
+		{"linked", "missing", ""},
+		{"linked", "", ""},
+		{"linked", "", filepath.Join(home, ".both")},
+		{"linked", "", filepath.Join(home, ".config", "new", "linked")},
+		{"linked", "", filepath.Join(c.Root(), "linked")},
+		{"both", "", filepath.Join(home, ".other")},
+	}
+
+	for _, test := range tests {
+		err := MoveFile(test.name, test.newName, test.newPath, true)
+		if err == nil {
+			t.Errorf("%v: expected an error", test)
+		}
+	}
+
+	// nothing should have been changed
+	if _, err := os.Readlink(filepath.Join(home, ".linked")); err != nil {
+		t.Errorf("expected the symlink to be left untouched: %s", err)
+	}
+}
+
+func TestTrackFiles(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	home := filepath.Join(tempDir, "home")
+	writeTestFile(t, filepath.Join(home, ".vimrc"), "vimrc")
+	writeTestFile(t, filepath.Join(home, "vim", "vimrc"), "vimrc")
+	writeTestFile(t, filepath.Join(home, ".config", "nvim", "init.vim"), "init")
+	defer useTestConfig(t, c)()
+
+	paths := []string{
+		filepath.Join(home, ".vimrc"),
+		filepath.Join(home, "vim", "vimrc"),
+		filepath.Join(home, ".config", "nvim"),
+	}
+
+	entries := make([]*Entry, len(paths))
+	for i, path := range paths {
+		entries[i] = &Entry{Path: path}
+	}
+
+	err := TrackFiles(make([]string, len(paths)), entries, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = NewConfig(c.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"vimrc":   paths[0],
+		"vimrc-2": paths[1],
+		"nvim":    paths[2],
+	}
+
+	if len(c.Files) != len(expected) {
+		t.Errorf("expected %d entries, got %v", len(expected), c.Files)
+	}
+
+	for name, path := range expected {
+		if e := c.Files[name]; e == nil || e.Path != path {
+			t.Errorf("%s: expected %s, got %v", name, path, e)
+		}
+
+		if _, err := os.Readlink(path); err != nil {
+			t.Errorf("%s: expected %s to be symlinked: %s", name, path, err)
+		}
+	}
+
+	// a path that can't be tracked doesn't stop the others
+	writeTestFile(t, filepath.Join(home, ".zshrc"), "zshrc")
+	entries = []*Entry{
+		{Path: filepath.Join(home, ".config", "nvim", "init.vim")},
+		{Path: filepath.Join(home, ".zshrc")},
+	}
+
+	if err := TrackFiles(make([]string, 2), entries, false); err == nil {
+		t.Error("expected an error for the path inside the repository")
+	}
+
+	c, err = NewConfig(c.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := c.Files["zshrc"]; !ok {
+		t.Error("expected zshrc to be tracked")
+	}
+}
+
+func TestUntrackFilesErrors(t *testing.T) {
+	c, tempDir := newTestPlanConfig(t)
+	defer os.RemoveAll(tempDir)
+	defer useTestConfig(t, c)()
+
+	tests := [][]string{
+		{"unknown"},
+		{"linked", "unknown*"},
+		// both isn't symlinked, so linked has to be left untouched as well
+		{"linked", "both"},
+	}
+
+	for _, patterns := range tests {
+		if err := UntrackFiles(patterns, UntrackOptions{}); err == nil {
+			t.Errorf("%v: expected an error", patterns)
+		}
+	}
+
+	c, err := NewConfig(c.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := c.Files["linked"]; !ok {
+		t.Error("expected linked to be left untouched")
+	}
+
+	if _, err := os.Readlink(filepath.Join(tempDir, "home", ".linked")); err != nil {
+		t.Errorf("expected the symlink to be left untouched: %s", err)
+	}
+}
+
+func TestUntrackFilesModes(t *testing.T) {
+	c, tempDir := newTestPlanConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	home := filepath.Join(tempDir, "home")
+	writeTestFile(t, filepath.Join(c.Root(), "backup", "linked", ".linked"), "original")
+	defer useTestConfig(t, c)()
+
+	ask := Ask
+	defer func() { Ask = ask }()
+
+	answer := "N"
+	var questions []string
+	Ask = func(question string) string {
+		questions = append(questions, question)
+		return answer
+	}
+
+	// a dry run and a declined confirmation don't change anything
+	err := UntrackFiles([]string{"linked"}, UntrackOptions{Mode: UntrackBackup, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = UntrackFiles([]string{"linked"}, UntrackOptions{Mode: UntrackBackup})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// moving the files back asks for confirmation as well
+	err = UntrackFiles([]string{"linked"}, UntrackOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(questions) != 2 {
+		t.Errorf("expected a confirmation for both modes, got %v", questions)
+	}
+
+	if _, err := os.Readlink(filepath.Join(home, ".linked")); err != nil {
+		t.Errorf("expected the symlink to be left untouched: %s", err)
+	}
+
+	// restore the backup
+	answer = "Y"
+	err = UntrackFiles([]string{"linked"}, UntrackOptions{Mode: UntrackBackup})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(home, ".linked"))
+	if err != nil || string(content) != "original" {
+		t.Errorf("expected the backup to be restored, got %q (%v)", content, err)
+	}
+
+	for _, folder := range []string{"files", "backup"} {
+		if _, err := os.Lstat(filepath.Join(c.Root(), folder, "linked")); !os.IsNotExist(err) {
+			t.Errorf("expected linked to be removed from the %s folder", folder)
+		}
+	}
+
+	// forget, the local file of both isn't a symlink and is left untouched
+	err = UntrackFiles([]string{"both", "missing"}, UntrackOptions{Mode: UntrackForget})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ = ioutil.ReadFile(filepath.Join(home, ".both"))
+	if string(content) != "local" {
+		t.Errorf("expected the local file to be left untouched, got %q", content)
+	}
+
+	for _, name := range []string{"both", "missing"} {
+		if _, err := os.Lstat(filepath.Join(c.Root(), "files", name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed from the repository", name)
+		}
+	}
+
+	c, err = NewConfig(c.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"linked", "both", "missing"} {
+		if _, ok := c.Files[name]; ok {
+			t.Errorf("expected %s to be removed from the config", name)
+		}
+	}
+
+	// there is no backup of new
+	err = UntrackFiles([]string{"new"}, UntrackOptions{Mode: UntrackBackup})
+	if err == nil {
+		t.Error("expected an error for an entry without a backup")
+	}
+}
+
+func TestTrackFilesLayer(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	team := filepath.Join(tempDir, "team")
+	c.Layers = []*Layer{{Name: "team", Path: team}}
+	defer useTestConfig(t, c)()
+
+	path := filepath.Join(tempDir, "home", ".tmux.conf")
+	writeTestFile(t, path, "tmux")
+
+	err := TrackFiles([]string{""}, []*Entry{{Path: path, layer: "unknown"}}, false)
+	if err == nil {
+		t.Error("expected an error for an unknown layer")
+	}
+
+	err = TrackFiles([]string{""}, []*Entry{{Path: path, layer: "team"}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := filepath.Join(team, "files", "tmux.conf", ".tmux.conf")
+	if link, _ := os.Readlink(path); link != repo {
+		t.Errorf("expected %s to be symlinked to %s, got %s", path, repo, link)
+	}
+
+	layer, err := NewConfig(filepath.Join(team, ConfigFileName))
+	if err != nil || layer.Files["tmux.conf"] == nil {
+		t.Errorf("expected the entry in the config of the layer, got %v (%v)", layer, err)
+	}
+}
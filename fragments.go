@@ -0,0 +1,380 @@
+// fragments.go will hold the entries of which the target is assembled from
+// fragments. The file of the entry in the files folder is a folder with the
+// fragments, they are concatenated in the order of their names, e.g.:
+//
+//	files/ssh/config/00-common
+//	files/ssh/config/50-work@work
+//	files/ssh/config/90-laptop@laptop
+//
+// A fragment of which the name ends with `@` and a comma separated list of
+// profiles or host names, is only used on the machines with one of them. A
+// fragment of which the name ends with `.tmpl` is a text/template, it's
+// executed with the Machine, e.g. `{{ .Values.email }}`:
+//
+//	files/git/config/10-user@work.tmpl
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+const (
+	// the fragment that is created when an existing file is tracked
+	FirstFragmentName = "00-common"
+
+	// suffix of the fragments that are templates
+	TemplateSuffix = ".tmpl"
+)
+
+// Machine describes the machine the fragments are assembled for, it is the
+// data the template fragments are executed with.
+type Machine struct {
+	// profile of the machine, as it is set in the local config
+	Profile string
+
+	// host name of the machine
+	Host string
+
+	// template values of the machine, as they are set in the local config
+	Values map[string]string
+}
+
+// fragmentApplies reports whether the fragment `name` is used on the
+// machine with the profile `profile` and the host name `host`.
+func fragmentApplies(name, profile, host string) bool {
+	name = strings.TrimSuffix(name, TemplateSuffix)
+
+	i := strings.LastIndex(name, "@")
+	if i < 0 {
+		return true
+	}
+
+	for _, only := range strings.Split(name[i+1:], ",") {
+		if only != "" && (only == profile || only == host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Fragments will return the paths of the fragments in the folder `dir`
+// that are used on the machine with the profile `profile` and the host name
+// `host`, in the order of their names. Hidden files and folders are
+// skipped.
+func Fragments(dir, profile, host string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var fragments []string
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || strings.HasPrefix(name, ".") || !fragmentApplies(name, profile, host) {
+			continue
+		}
+
+		fragments = append(fragments, filepath.Join(dir, name))
+	}
+
+	sort.Strings(fragments)
+	return fragments, nil
+}
+
+// AssembleFragments will concatenate the fragments in the folder `dir`
+// that are used on the machine `m`, see Fragments. Every fragment starts on
+// a new line. The template fragments are executed with `m`, a template that
+// uses a value that isn't set results in an error.
+func AssembleFragments(dir string, m Machine) (string, error) {
+	fragments, err := Fragments(dir, m.Profile, m.Host)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, fragment := range fragments {
+		data, err := ioutil.ReadFile(fragment)
+		if err != nil {
+			return "", err
+		}
+
+		if strings.HasSuffix(fragment, TemplateSuffix) {
+			data, err = executeFragment(fragment, data, m)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+			b.WriteString("\n")
+		}
+		b.Write(data)
+	}
+
+	return b.String(), nil
+}
+
+// executeFragment will execute the template fragment `path`, with the
+// contents `data`, with the machine `m`.
+func executeFragment(path string, data []byte, m Machine) ([]byte, error) {
+	tmpl, err := template.New(filepath.Base(path)).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	err = tmpl.Execute(&b, m)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(b.String()), nil
+}
+
+// Machine will return this machine, with the profile and the template
+// values of the local config.
+func (c *Config) Machine() Machine {
+	host, _ := os.Hostname()
+	return Machine{Profile: c.Profile(), Host: host, Values: c.Values()}
+}
+
+// assemble will return the target of the fragments in `dir` for this
+// machine, see AssembleFragments
+func (c *Config) assemble(dir string) (string, error) {
+	return AssembleFragments(dir, c.Machine())
+}
+
+// hashContent will return the sha256 of `content` as a hex string
+func hashContent(content string) string {
+	hash := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(hash[:])
+}
+
+// FragmentStatePath will return the location of the state of the assembled
+// targets on this machine, see StateDir.
+func FragmentStatePath() string {
+	return filepath.Join(StateDir(), "fragments.json")
+}
+
+// fragmentStateMu protects the state of the assembled targets, targets are
+// assembled concurrently
+var fragmentStateMu sync.Mutex
+
+// loadFragmentState will return the sha256 of the content that was last
+// written to every assembled target, by the absolute path of the target.
+func loadFragmentState() (map[string]string, error) {
+	state := make(map[string]string)
+
+	data, err := ioutil.ReadFile(FragmentStatePath())
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return nil, fmt.Errorf("not able to read %s (%s)", FragmentStatePath(), err)
+	}
+
+	return state, nil
+}
+
+// recordFragmentTarget will remember that `content` was written to the
+// target `target`, when `content` is empty the target is forgotten.
+func recordFragmentTarget(target, content string) error {
+	fragmentStateMu.Lock()
+	defer fragmentStateMu.Unlock()
+
+	state, err := loadFragmentState()
+	if err != nil {
+		return err
+	}
+
+	if content == "" {
+		delete(state, target)
+	} else {
+		state[target] = hashContent(content)
+	}
+
+	data, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(StateDir(), 0755)
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(FragmentStatePath(), append(data, '\n'), 0644)
+}
+
+// planFragments will determine whether the target of the entry of `s` has
+// to be assembled, see PlanEntry. A target that differs from what dot wrote
+// to it last time has drifted, its changes would be lost.
+func planFragments(s *Step) {
+	assembled, err := s.config.assemble(s.Repo)
+	if err != nil {
+		s.Action = ActionSkip
+		s.Reason = fmt.Sprintf("not able to assemble the fragments in %s (%s)", s.Repo, err)
+		return
+	}
+
+	f, err := os.Lstat(s.Target)
+	switch {
+	case os.IsNotExist(err):
+		s.Action = ActionAssemble
+		return
+	case err != nil:
+		s.Action = ActionSkip
+		s.Reason = err.Error()
+		return
+	case !f.Mode().IsRegular():
+		s.Action = ActionSkip
+		s.Reason = fmt.Sprintf("%s isn't a regular file", s.Target)
+		return
+	}
+
+	data, err := ioutil.ReadFile(s.Target)
+	if err != nil {
+		s.Action = ActionSkip
+		s.Reason = err.Error()
+		return
+	}
+
+	if string(data) == assembled {
+		s.Action = ActionNone
+		return
+	}
+
+	fragmentStateMu.Lock()
+	state, err := loadFragmentState()
+	fragmentStateMu.Unlock()
+	if err != nil {
+		s.Action = ActionSkip
+		s.Reason = err.Error()
+		return
+	}
+
+	s.Action = ActionAssemble
+	s.Drifted = state[s.Target] != hashContent(string(data))
+}
+
+// assembleFragments will write the fragments to the target, a target that
+// drifted is copied to the backup folder first.
+func (s *Step) assembleFragments() error {
+	assembled, err := s.config.assemble(s.Repo)
+	if err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	if f, err := os.Stat(s.Target); err == nil {
+		mode = f.Mode().Perm()
+	}
+
+	if s.Drifted {
+		err = s.config.RemoveAll(s.Backup)
+		if err != nil {
+			return err
+		}
+
+		err = os.MkdirAll(filepath.Dir(s.Backup), 0755)
+		if err != nil {
+			return err
+		}
+
+		err = CopyFile(s.Target, s.Backup)
+		if err != nil {
+			return fmt.Errorf("not able to copy %s to %s (%s)", s.Target, s.Backup, err)
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(s.Target), 0755)
+	if err != nil {
+		return err
+	}
+
+	err = WriteFileAtomic(s.Target, []byte(assembled), mode)
+	if err != nil {
+		return err
+	}
+
+	return recordFragmentTarget(s.Target, assembled)
+}
+
+// trackFragments will track the entry `e` of which the target is assembled
+// from fragments. An existing target becomes the first fragment.
+func trackFragments(c *Config, name string, e *Entry) error {
+	p := c.LayerPaths(e.layer, name, e.Path)
+
+	children, err := CheckOverlap(c, name, p.Target)
+	if err != nil {
+		return err
+	}
+	if len(children) > 0 {
+		return fmt.Errorf("not able to assemble %s, it %s", p.Target, children[0])
+	}
+
+	if _, err := os.Lstat(p.Repo); err == nil {
+		return fmt.Errorf("%s is already present", p.Repo)
+	}
+
+	f, err := os.Lstat(p.Target)
+	if err == nil && !f.Mode().IsRegular() {
+		return fmt.Errorf("%s isn't a regular file", p.Target)
+	}
+
+	err = os.MkdirAll(p.Repo, 0755)
+	if err != nil {
+		return err
+	}
+
+	if f == nil {
+		PrintBody(fmt.Sprintf("Creating the folder for the fragments: %s", p.Repo))
+		return nil
+	}
+
+	first := filepath.Join(p.Repo, FirstFragmentName)
+	PrintBody(fmt.Sprintf("Copying %s to the fragment %s", p.Target, first))
+	err = CopyFile(p.Target, first)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(p.Target)
+	if err != nil {
+		return err
+	}
+
+	return recordFragmentTarget(p.Target, string(data))
+}
+
+// assembleFile will write the target as it is assembled from the fragments
+// in `dir` to `dst`, and return `dst`. When `dir` doesn't exist nothing is
+// written, so `dst` doesn't exist.
+func assembleFile(c *Config, dir, dst string) (string, error) {
+	assembled, err := c.assemble(dir)
+	if os.IsNotExist(err) {
+		return dst, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return dst, ioutil.WriteFile(dst, []byte(assembled), 0600)
+}
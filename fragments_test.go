@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAssembleFragments(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	writeTestFile(t, filepath.Join(tempDir, "90-local@laptop"), "laptop\n")
+	writeTestFile(t, filepath.Join(tempDir, "00-common"), "common")
+	writeTestFile(t, filepath.Join(tempDir, "50-work@work,office"), "work\n")
+	writeTestFile(t, filepath.Join(tempDir, ".gitignore"), "ignored\n")
+	writeTestFile(t, filepath.Join(tempDir, "folder", "fragment"), "ignored\n")
+
+	tests := []struct {
+		profile, host string
+		expected      string
+	}{
+		{"", "desktop", "common"},
+		{"work", "desktop", "common\nwork\n"},
+		{"office", "laptop", "common\nwork\nlaptop\n"},
+		{"home", "laptop", "common\nlaptop\n"},
+	}
+
+	for _, test := range tests {
+		assembled, err := AssembleFragments(tempDir, Machine{Profile: test.profile, Host: test.host})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if assembled != test.expected {
+			t.Errorf("%s on %s: expected %q, got %q", test.profile, test.host, test.expected, assembled)
+		}
+	}
+
+	if _, err := AssembleFragments(filepath.Join(tempDir, "missing"), Machine{}); err == nil {
+		t.Error("expected an error for a missing folder")
+	}
+}
+
+func TestAssembleFragmentsTemplate(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	writeTestFile(t, filepath.Join(tempDir, "00-common"), "[core]\n")
+	writeTestFile(t, filepath.Join(tempDir, "10-user@work.tmpl"), "[user]\n\temail = {{ .Values.email }}\n")
+	writeTestFile(t, filepath.Join(tempDir, "20-host.tmpl"), "# {{ .Host }}\n")
+
+	m := Machine{Profile: "work", Host: "laptop", Values: map[string]string{"email": "jane@example.com"}}
+	assembled, err := AssembleFragments(tempDir, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "[core]\n[user]\n\temail = jane@example.com\n# laptop\n"
+	if assembled != expected {
+		t.Errorf("expected %q, got %q", expected, assembled)
+	}
+
+	assembled, err = AssembleFragments(tempDir, Machine{Host: "desktop"})
+	if err != nil || assembled != "[core]\n# desktop\n" {
+		t.Errorf("expected the template of work to be skipped, got %q (%v)", assembled, err)
+	}
+
+	if _, err := AssembleFragments(tempDir, Machine{Profile: "work"}); err == nil {
+		t.Error("expected an error for a value that isn't set")
+	}
+}
+
+func TestSyncFragments(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	os.Setenv("XDG_STATE_HOME", filepath.Join(tempDir, "state"))
+	defer os.Unsetenv("XDG_STATE_HOME")
+
+	target := filepath.Join(tempDir, "home", ".ssh", "config")
+	writeTestFile(t, target, "Host *\n")
+	e := &Entry{Path: target, Mode: EntryModeFragments}
+	if err := trackFragments(c, "ssh", e); err != nil {
+		t.Fatal(err)
+	}
+	c.Files["ssh"] = e
+
+	repo := c.Paths("ssh").Repo
+	if data, _ := ioutil.ReadFile(filepath.Join(repo, FirstFragmentName)); string(data) != "Host *\n" {
+		t.Errorf("expected the file to become the first fragment, got %q", data)
+	}
+
+	step := PlanEntry(c, "ssh", e)
+	if step.Action != ActionNone {
+		t.Errorf("expected the target to be up to date, got %s", step.Action)
+	}
+
+	// a new fragment is assembled without drift
+	writeTestFile(t, filepath.Join(repo, "50-work"), "Host work\n")
+	step = PlanEntry(c, "ssh", e)
+	if step.Action != ActionAssemble || step.Drifted {
+		t.Errorf("expected the target to be assembled, got %s (drifted %v)", step.Action, step.Drifted)
+	}
+
+	var output bytes.Buffer
+	if _, err := DiffEntries(&output, c, []string{"ssh"}, DiffOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "-Host work") {
+		t.Errorf("expected the diff against the assembled target, got %s", output.String())
+	}
+
+	plan := &Plan{Steps: []*Step{step}}
+	if plan.Execute(ioutil.Discard, 1) > 0 {
+		t.Fatal("expected the plan to succeed")
+	}
+
+	if data, _ := ioutil.ReadFile(target); string(data) != "Host *\nHost work\n" {
+		t.Errorf("expected the assembled target, got %q", data)
+	}
+
+	// a change to the target is drift, it's kept in the backup folder
+	writeTestFile(t, target, "Host *\nHost work\nHost edited\n")
+	step = PlanEntry(c, "ssh", e)
+	if step.Action != ActionAssemble || !step.Drifted {
+		t.Errorf("expected the target to have drifted, got %s (drifted %v)", step.Action, step.Drifted)
+	}
+
+	plan = &Plan{Steps: []*Step{step}}
+	if plan.Execute(ioutil.Discard, 1) > 0 {
+		t.Fatal("expected the plan to succeed")
+	}
+
+	if data, _ := ioutil.ReadFile(c.Paths("ssh").Backup); !strings.Contains(string(data), "Host edited") {
+		t.Errorf("expected the drifted target in the backup folder, got %q", data)
+	}
+
+	if data, _ := ioutil.ReadFile(target); string(data) != "Host *\nHost work\n" {
+		t.Errorf("expected the assembled target, got %q", data)
+	}
+}
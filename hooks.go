@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultHookTimeout is the time a hook may run, when the config doesn't
+	// specify a timeout
+	DefaultHookTimeout = time.Minute
+
+	// a failing pre hook stops what it precedes: the sync, or the linking
+	// or unlinking of the entry. A failing post hook counts as a failure.
+	HookFailureStop = "stop"
+
+	// a failing hook is reported, but otherwise ignored
+	HookFailureContinue = "continue"
+)
+
+// Hooks are the shell commands that run before and after a sync, and the
+// settings for all hooks. The sync hooks only run when at least one entry
+// will be changed.
+type Hooks struct {
+	PreSync  string `json:"pre_sync,omitempty"`
+	PostSync string `json:"post_sync,omitempty"`
+
+	// time a hook may run before it is killed, e.g. "30s", defaults to
+	// DefaultHookTimeout
+	Timeout string `json:"timeout,omitempty"`
+
+	// what happens when a hook fails, HookFailureStop (the default) or
+	// HookFailureContinue
+	OnFailure string `json:"on_failure,omitempty"`
+}
+
+// EntryHooks are the shell commands that run when an entry changes, they
+// don't run for entries that are already symlinked.
+type EntryHooks struct {
+	PreLink   string `json:"pre_link,omitempty"`
+	PostLink  string `json:"post_link,omitempty"`
+	PreUnlink string `json:"pre_unlink,omitempty"`
+}
+
+// HookRunner will run hooks with `sh -c` in the dot repository. Besides the
+// variables of the environment of dot, a hook gets DOT_HOOK with the name of
+// the hook and DOT_PATH with the location of the repository.
+type HookRunner struct {
+	Dir string
+
+	// time a hook may run, there is no limit when it's zero
+	Timeout time.Duration
+
+	OnFailure string
+
+	// only report which hooks would run
+	DryRun bool
+}
+
+// NewHookRunner will return the runner for the hooks of the config `c`, an
+// error is returned when the settings of the hooks are invalid.
+func NewHookRunner(c *Config, dryRun bool) (*HookRunner, error) {
+	r := &HookRunner{
+		Dir:       c.Root(),
+		Timeout:   DefaultHookTimeout,
+		OnFailure: HookFailureStop,
+		DryRun:    dryRun,
+	}
+
+	if c.Hooks == nil {
+		return r, nil
+	}
+
+	if c.Hooks.Timeout != "" {
+		timeout, err := time.ParseDuration(c.Hooks.Timeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid hook timeout: '%s'", c.Hooks.Timeout)
+		}
+		r.Timeout = timeout
+	}
+
+	switch c.Hooks.OnFailure {
+	case "":
+	case HookFailureStop, HookFailureContinue:
+		r.OnFailure = c.Hooks.OnFailure
+	default:
+		return nil, fmt.Errorf("invalid hook failure policy: '%s', use '%s' or '%s'",
+			c.Hooks.OnFailure, HookFailureStop, HookFailureContinue)
+	}
+
+	return r, nil
+}
+
+// Run will run the hook `hook` with the shell command `command`, when it
+// isn't empty. The variables `env` are added to its environment, and its
+// output is written to `w`. A hook that runs longer than the timeout is
+// killed, including the processes it started.
+func (r *HookRunner) Run(w io.Writer, hook, command string, env []string) error {
+	if command == "" {
+		return nil
+	}
+
+	if r.DryRun {
+		FprintBody(w, fmt.Sprintf("Would run the %s hook: %s", hook, command))
+		return nil
+	}
+
+	FprintBody(w, fmt.Sprintf("Running the %s hook: %s", hook, command))
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), "DOT_HOOK="+hook)
+	return r.run(w, "the "+hook+" hook", cmd, env)
+}
+
+// run will run `cmd` in the folder of the runner, `description` is used in
+// the errors
+func (r *HookRunner) run(w io.Writer, description string, cmd *exec.Cmd, env []string) error {
+	var output bytes.Buffer
+	cmd.Dir = r.Dir
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, "DOT_PATH="+r.Dir)
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	// run it in its own process group, so it can be killed as a whole
+	setProcessGroup(cmd)
+
+	err := cmd.Start()
+	if err != nil {
+		return fmt.Errorf("not able to run %s (%s)", description, err)
+	}
+
+	var timedOut int32
+	var timer *time.Timer
+	if r.Timeout > 0 {
+		timer = time.AfterFunc(r.Timeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			killProcessGroup(cmd)
+		})
+	}
+	err = cmd.Wait()
+	if timer != nil {
+		timer.Stop()
+	}
+
+	for _, line := range strings.Split(strings.TrimRight(output.String(), "\n"), "\n") {
+		if line != "" {
+			FprintBody(w, "  "+line)
+		}
+	}
+
+	switch {
+	case atomic.LoadInt32(&timedOut) == 1:
+		return fmt.Errorf("%s timed out after %s", description, r.Timeout)
+	case err != nil:
+		return fmt.Errorf("%s failed (%s)", description, err)
+	}
+
+	return nil
+}
+
+// Stop reports whether a failing hook stops what it precedes
+func (r *HookRunner) Stop() bool {
+	return r.OnFailure != HookFailureContinue
+}
+
+// entryHookEnv will return the environment variables that describe the
+// entry `name` to its hooks.
+func entryHookEnv(name string, p EntryPaths) []string {
+	return []string{
+		"DOT_NAME=" + name,
+		"DOT_TARGET=" + p.Target,
+		"DOT_REPO=" + p.Repo,
+		"DOT_BACKUP=" + p.Backup,
+	}
+}
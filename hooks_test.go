@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewHookRunner(t *testing.T) {
+	c := &Config{DotPath: "/srv/dotfiles"}
+
+	r, err := NewHookRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Timeout != DefaultHookTimeout || !r.Stop() || r.Dir != "/srv/dotfiles" {
+		t.Errorf("unexpected defaults: %+v", r)
+	}
+
+	c.Hooks = &Hooks{Timeout: "5s", OnFailure: HookFailureContinue}
+	r, err = NewHookRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Timeout != 5*time.Second || r.Stop() {
+		t.Errorf("expected the settings of the config, got %+v", r)
+	}
+
+	for _, hooks := range []*Hooks{{Timeout: "soon"}, {Timeout: "-1s"}, {OnFailure: "retry"}} {
+		c.Hooks = hooks
+		if _, err := NewHookRunner(c, false); err == nil {
+			t.Errorf("%+v: expected an error", hooks)
+		}
+
+		if errs := c.Validate(); len(errs) != 1 {
+			t.Errorf("%+v: expected the config to be invalid, got %v", hooks, errs)
+		}
+	}
+}
+
+func TestHookRunnerRun(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	r := &HookRunner{Dir: tempDir, Timeout: time.Second}
+
+	var output bytes.Buffer
+	err = r.Run(&output, "post_link", `echo "$DOT_HOOK $DOT_NAME $DOT_PATH"; pwd`, []string{"DOT_NAME=vim"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "... Running the post_link hook: echo \"$DOT_HOOK $DOT_NAME $DOT_PATH\"; pwd\n" +
+		"...   post_link vim " + tempDir + "\n" +
+		"...   " + tempDir + "\n"
+	if output.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, output.String())
+	}
+
+	if err := r.Run(&output, "pre_link", "exit 3", nil); err == nil {
+		t.Error("expected an error for a failing hook")
+	}
+
+	// an empty hook doesn't run
+	output.Reset()
+	if err := r.Run(&output, "pre_link", "", nil); err != nil || output.Len() > 0 {
+		t.Errorf("expected nothing to happen, got %q (%v)", output.String(), err)
+	}
+
+	// processes started by the hook are killed as well
+	r.Timeout = 100 * time.Millisecond
+	start := time.Now()
+	err = r.Run(&output, "pre_sync", "sleep 10 & sleep 10", nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected the hook to time out, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the hook to be killed, it took %s", elapsed)
+	}
+
+	// a dry run only reports the hook
+	r.DryRun = true
+	output.Reset()
+	err = r.Run(&output, "post_sync", "touch "+filepath.Join(tempDir, "ran"), nil)
+	if err != nil || !strings.HasPrefix(output.String(), "... Would run the post_sync hook") {
+		t.Errorf("expected a dry run, got %q (%v)", output.String(), err)
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, "ran")); !os.IsNotExist(err) {
+		t.Error("expected the hook not to run")
+	}
+}
+
+func TestPlanExecuteHooks(t *testing.T) {
+	c, tempDir := newTestPlanConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	log := filepath.Join(tempDir, "log")
+	record := `echo "$DOT_HOOK $DOT_NAME $DOT_TARGET" >> ` + log
+	for _, name := range []string{"linked", "missing"} {
+		c.Files[name].Hooks = &EntryHooks{PreLink: record, PostLink: record}
+	}
+
+	// a failing pre_link hook skips the entry
+	c.Files["both"].Hooks = &EntryHooks{PreLink: "exit 1", PostLink: record}
+
+	hooks, err := NewHookRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan := &Plan{Hooks: hooks}
+	for _, name := range []string{"linked", "missing", "both"} {
+		plan.Steps = append(plan.Steps, PlanEntry(c, name, c.Files[name]))
+	}
+
+	var output bytes.Buffer
+	if failures := plan.Execute(&output, 1); failures != 1 {
+		t.Errorf("expected 1 failure, got %d:\n%s", failures, output.String())
+	}
+
+	// only the hooks of the entry that changed have run
+	target := filepath.Join(tempDir, "home", ".missing")
+	expected := "pre_link missing " + target + "\npost_link missing " + target + "\n"
+	content, _ := ioutil.ReadFile(log)
+	if string(content) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, content)
+	}
+
+	if _, err := os.Readlink(filepath.Join(tempDir, "home", ".both")); err == nil {
+		t.Error("expected both to be skipped")
+	}
+
+	// the failure can be ignored
+	hooks.OnFailure = HookFailureContinue
+	plan = &Plan{Hooks: hooks, Steps: []*Step{PlanEntry(c, "both", c.Files["both"])}}
+	if failures := plan.Execute(&output, 1); failures != 0 {
+		t.Errorf("expected no failures, got %d:\n%s", failures, output.String())
+	}
+
+	if _, err := os.Readlink(filepath.Join(tempDir, "home", ".both")); err != nil {
+		t.Errorf("expected both to be symlinked: %s", err)
+	}
+}
+
+func TestUntrackFilesHooks(t *testing.T) {
+	c, tempDir := newTestPlanConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	home := filepath.Join(tempDir, "home")
+	writeTestFile(t, filepath.Join(c.Root(), "backup", "linked", ".linked"), "original")
+	c.Files["linked"].Hooks = &EntryHooks{PreUnlink: "test -L $DOT_TARGET && exit 1"}
+	defer useTestConfig(t, c)()
+
+	ask := Ask
+	defer func() { Ask = ask }()
+	Ask = func(question string) string { return "Y" }
+
+	err := UntrackFiles([]string{"linked"}, UntrackOptions{Mode: UntrackBackup})
+	if err == nil {
+		t.Fatal("expected the failing hook to stop the removal")
+	}
+
+	if _, err := os.Readlink(filepath.Join(home, ".linked")); err != nil {
+		t.Errorf("expected the symlink to be left untouched: %s", err)
+	}
+
+	c.Files["linked"].Hooks.PreUnlink = "test -L $DOT_TARGET"
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = UntrackFiles([]string{"linked"}, UntrackOptions{Mode: UntrackBackup})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ := ioutil.ReadFile(filepath.Join(home, ".linked"))
+	if string(content) != "original" {
+		t.Errorf("expected the backup to be restored, got %q", content)
+	}
+}
@@ -0,0 +1,273 @@
+// ignore.go will hold the glob patterns that decide which paths inside a
+// tracked folder are kept out of git.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// name of the file, in the root of the dot_path, that holds the exclude
+	// patterns for every tracked folder
+	DotIgnoreFileName = ".dotignore"
+
+	// first line of the .gitignore files that are generated by dot
+	gitIgnoreHeader = "# generated by dot from the include and exclude patterns, do not edit"
+)
+
+// pattern is a single glob pattern, the syntax is a subset of the one of
+// .gitignore files:
+//
+//   - `*`, `?` and `[...]` match like path.Match, `**` matches any number
+//     of folders
+//   - a pattern with a trailing slash will only match folders
+//   - a pattern with a leading or inner slash is matched against the path
+//     relative to the tracked folder, otherwise it is matched against the
+//     name of the file or folder at any depth
+type pattern struct {
+	segments []string
+	anchored bool
+	dirOnly  bool
+}
+
+func newPattern(source string) pattern {
+	p := pattern{}
+
+	if strings.HasSuffix(source, "/") {
+		p.dirOnly = true
+		source = strings.TrimRight(source, "/")
+	}
+
+	if strings.Contains(source, "/") {
+		p.anchored = true
+		source = strings.TrimLeft(source, "/")
+	}
+
+	p.segments = strings.Split(source, "/")
+	return p
+}
+
+// match reports whether the pattern matches the path `segments`, `isDir`
+// tells whether the path is a folder.
+func (p pattern) match(segments []string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+
+	if !p.anchored {
+		ok, _ := path.Match(p.segments[0], segments[len(segments)-1])
+		return ok
+	}
+
+	return matchSegments(p.segments, segments)
+}
+
+func matchSegments(pattern []string, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(segments) == 0 {
+		return false
+	}
+
+	ok, _ := path.Match(pattern[0], segments[0])
+	return ok && matchSegments(pattern[1:], segments[1:])
+}
+
+// Ignore decides which paths inside a tracked folder are tracked. When
+// there are include patterns only the files that match one of them are
+// tracked, the exclude patterns always win.
+type Ignore struct {
+	include []pattern
+	exclude []pattern
+}
+
+// NewIgnore will create an Ignore out of the include and exclude patterns,
+// invalid patterns will result in an error.
+func NewIgnore(include, exclude []string) (*Ignore, error) {
+	i := &Ignore{}
+
+	for _, list := range []struct {
+		sources  []string
+		patterns *[]pattern
+	}{
+		{include, &i.include},
+		{exclude, &i.exclude},
+	} {
+		for _, source := range list.sources {
+			source = strings.TrimSpace(source)
+			if source == "" || strings.Trim(source, "/") == "" {
+				return nil, fmt.Errorf("invalid pattern: '%s'", source)
+			}
+
+			p := newPattern(source)
+			for _, segment := range p.segments {
+				if _, err := path.Match(segment, ""); err != nil {
+					return nil, fmt.Errorf("invalid pattern: '%s'", source)
+				}
+			}
+
+			*list.patterns = append(*list.patterns, p)
+		}
+	}
+
+	return i, nil
+}
+
+// Empty reports whether there are no patterns at all.
+func (i *Ignore) Empty() bool {
+	return i == nil || (len(i.include) == 0 && len(i.exclude) == 0)
+}
+
+// Ignored reports whether `relPath`, a slash separated path relative to the
+// tracked folder, should be left out of the repository. `isDir` tells
+// whether `relPath` is a folder.
+func (i *Ignore) Ignored(relPath string, isDir bool) bool {
+	if i.Empty() {
+		return false
+	}
+
+	segments := strings.Split(path.Clean(filepath.ToSlash(relPath)), "/")
+
+	// a path is excluded when it, or one of its parent folders, matches
+	included := len(i.include) == 0 || isDir
+	for n := 1; n <= len(segments); n++ {
+		prefixIsDir := n < len(segments) || isDir
+
+		for _, p := range i.exclude {
+			if p.match(segments[:n], prefixIsDir) {
+				return true
+			}
+		}
+
+		for _, p := range i.include {
+			if p.match(segments[:n], prefixIsDir) {
+				included = true
+			}
+		}
+	}
+
+	return !included
+}
+
+// GitIgnore will return the contents of a .gitignore file, to be placed
+// next to the tracked folder `base`, that leaves the same paths out of
+// the repository.
+func (i *Ignore) GitIgnore(base string) string {
+	var b strings.Builder
+
+	b.WriteString(gitIgnoreHeader + "\n")
+
+	rule := func(prefix string, p pattern) string {
+		rule := "/" + base + "/"
+		if !p.anchored {
+			rule += "**/"
+		}
+		rule = prefix + rule + strings.Join(p.segments, "/")
+		if p.dirOnly {
+			rule += "/"
+		}
+		return rule
+	}
+
+	if len(i.include) > 0 {
+		// ignore everything, but keep descending into folders
+		fmt.Fprintf(&b, "/%s/**\n!/%s/**/\n", base, base)
+		for _, p := range i.include {
+			r := strings.TrimSuffix(rule("!", p), "/")
+			fmt.Fprintf(&b, "%s\n%s/**\n", r, r)
+		}
+	}
+
+	for _, p := range i.exclude {
+		fmt.Fprintf(&b, "%s\n", rule("", p))
+	}
+
+	return b.String()
+}
+
+// LoadDotIgnore will read the exclude patterns from the .dotignore file in
+// the folder `root`. Empty lines and lines starting with `#` are skipped.
+func LoadDotIgnore(root string) ([]string, error) {
+	file, err := os.Open(filepath.Join(root, DotIgnoreFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+
+	return patterns, scanner.Err()
+}
+
+// Ignore will return the Ignore for the entry `e`, it combines the patterns
+// from the .dotignore file of the repository of the entry with the ones of
+// the entry.
+func (c *Config) Ignore(e *Entry) (*Ignore, error) {
+	exclude, err := LoadDotIgnore(c.LayerRoot(e.layer))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewIgnore(e.Include, append(exclude, e.Exclude...))
+}
+
+// WriteEntryGitIgnore will write the .gitignore file for the tracked folder
+// `base` of an entry, in the folder of the entry `dir`, e.g.
+// `files/[name]/.gitignore`. When there are no patterns a previously
+// generated .gitignore will be removed.
+func WriteEntryGitIgnore(dir, base string, ignore *Ignore) error {
+	pathGitIgnore := filepath.Join(dir, ".gitignore")
+
+	current, err := ioutil.ReadFile(pathGitIgnore)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// never touch a .gitignore that wasn't generated by dot
+	if err == nil && !strings.HasPrefix(string(current), gitIgnoreHeader) {
+		return nil
+	}
+
+	if ignore.Empty() {
+		if err == nil {
+			return os.Remove(pathGitIgnore)
+		}
+		return nil
+	}
+
+	content := ignore.GitIgnore(base)
+	if string(current) == content {
+		return nil
+	}
+
+	return ioutil.WriteFile(pathGitIgnore, []byte(content), 0644)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnored(t *testing.T) {
+	tests := []struct {
+		include []string
+		exclude []string
+		relPath string
+		isDir   bool
+		ignored bool
+	}{
+		// without patterns nothing is ignored
+		{nil, nil, "History", false, false},
+
+		// patterns without a slash match at any depth
+		{nil, []string{"*.swp"}, ".init.vim.swp", false, true},
+		{nil, []string{"*.swp"}, "lua/.plugins.lua.swp", false, true},
+		{nil, []string{"*.swp"}, "init.vim", false, false},
+
+		// patterns with a trailing slash only match folders, and
+		// everything inside them
+		{nil, []string{"Cache/"}, "Cache", true, true},
+		{nil, []string{"Cache/"}, "Cache", false, false},
+		{nil, []string{"Cache/"}, "Cache/data_0", false, true},
+		{nil, []string{"Cache/"}, "User/Cache/data_0", false, true},
+
+		// patterns with a slash are relative to the tracked folder
+		{nil, []string{"/History"}, "History", false, true},
+		{nil, []string{"/History"}, "User/History", false, false},
+		{nil, []string{"User/workspaceStorage"}, "User/workspaceStorage/1/state", false, true},
+		{nil, []string{"**/state.vscdb"}, "User/globalStorage/state.vscdb", false, true},
+		{nil, []string{"**/state.vscdb"}, "state.vscdb", false, true},
+
+		// include patterns only track what matches
+		{[]string{"User/"}, nil, "User/settings.json", false, false},
+		{[]string{"User/"}, nil, "Cache/data_0", false, true},
+		{[]string{"User/"}, nil, "Cache", true, false},
+		{[]string{"*.json"}, nil, "User/keybindings.json", false, false},
+		{[]string{"*.json"}, nil, "User/History/1", false, true},
+
+		// exclude patterns always win
+		{[]string{"User/"}, []string{"History/"}, "User/History/1", false, true},
+	}
+
+	for _, test := range tests {
+		ignore, err := NewIgnore(test.include, test.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ignore.Ignored(test.relPath, test.isDir) != test.ignored {
+			t.Errorf("include: %v, exclude: %v, path: %s: expected ignored to be %v",
+				test.include, test.exclude, test.relPath, test.ignored)
+		}
+	}
+}
+
+func TestNewIgnoreInvalid(t *testing.T) {
+	for _, p := range []string{"", "/", "[", "a/[b"} {
+		if _, err := NewIgnore(nil, []string{p}); err == nil {
+			t.Errorf("expected an error for pattern '%s'", p)
+		}
+	}
+}
+
+func TestGitIgnore(t *testing.T) {
+	ignore, err := NewIgnore([]string{"User/"}, []string{"*.swp", "/History"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := gitIgnoreHeader + `
+/Code/**
+!/Code/**/
+!/Code/**/User
+!/Code/**/User/**
+/Code/**/*.swp
+/Code/History
+`
+	if content := ignore.GitIgnore("Code"); content != expected {
+		t.Errorf("unexpected .gitignore:\n%s", content)
+	}
+}
+
+func TestLoadDotIgnore(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// a missing .dotignore file isn't an error
+	patterns, err := LoadDotIgnore(tempDir)
+	if err != nil || len(patterns) != 0 {
+		t.Errorf("unexpected result: %v, %v", patterns, err)
+	}
+
+	ioutil.WriteFile(
+		filepath.Join(tempDir, DotIgnoreFileName),
+		[]byte("# editor files\n*.swp\n\n  Cache/  \n"),
+		0644,
+	)
+
+	patterns, err = LoadDotIgnore(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(patterns) != 2 || patterns[0] != "*.swp" || patterns[1] != "Cache/" {
+		t.Errorf("unexpected patterns: %v", patterns)
+	}
+}
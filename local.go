@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// the local config is stored next to the config, e.g.
+	// `~/.dotconfig.local`. It isn't part of the repository, so it's never
+	// committed.
+	LocalConfigSuffix = ".local"
+
+	// name of the layer of the entries of the local config
+	LocalLayer = "local"
+)
+
+// LocalConfig holds the settings that only apply to this machine, they are
+// merged over the config when it's loaded. See Config.merge.
+type LocalConfig struct {
+	// folder where the files of the local entries will reside, defaults to
+	// DefaultLocalRoot
+	DotPath string `json:"dot_path,omitempty"`
+
+	// entries of this machine, they override the entries with the same name
+	Files map[string]*Entry `json:"files,omitempty"`
+
+	// names of the entries that aren't synced on this machine
+	Disabled []string `json:"disabled,omitempty"`
+
+	// profile of this machine
+	Profile string `json:"profile,omitempty"`
+
+	// values of this machine for the template fragments, see Machine
+	Values map[string]string `json:"values,omitempty"`
+
+	// path of the file the local config was loaded from
+	path string
+
+	// the local config as it was loaded or last saved, it's only written
+	// when it changes
+	saved []byte
+}
+
+// DefaultLocalRoot will return the folder where the files of the local
+// entries reside, `$XDG_DATA_HOME/dot`, which defaults to
+// `~/.local/share/dot`. It is outside of the repository, so the local
+// entries are never committed either.
+func DefaultLocalRoot() string {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" || !filepath.IsAbs(dir) {
+		dir = filepath.Join(HomeDir(), ".local", "share")
+	}
+
+	return filepath.Join(dir, "dot")
+}
+
+// loadLocal will load the local config from `path`, the local config is
+// empty when the file doesn't exist.
+func (c *Config) loadLocal(path string) error {
+	c.local = &LocalConfig{Files: make(map[string]*Entry), path: path}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		c.local.saved, err = json.MarshalIndent(c.local, "", "\t")
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(data, c.local)
+	if err != nil {
+		return fmt.Errorf("not able to read %s (%s)", path, err)
+	}
+
+	if c.local.Files == nil {
+		c.local.Files = make(map[string]*Entry)
+	}
+
+	for name, e := range c.local.Files {
+		if e == nil {
+			return fmt.Errorf("entry '%s' doesn't have a path", name)
+		}
+		if err := ValidateName(name); err != nil {
+			return fmt.Errorf("invalid entry in %s: %s", path, err)
+		}
+		if err := e.validateMode(); err != nil {
+			return fmt.Errorf("invalid entry '%s' in %s: %s", name, path, err)
+		}
+	}
+
+	c.local.saved, err = json.MarshalIndent(c.local, "", "\t")
+	return err
+}
+
+// saveLocal will write the local entries to the local config, when they
+// changed.
+func (c *Config) saveLocal() error {
+	if c.local == nil {
+		path := c.path
+		if path == "" {
+			path = PathDotConfig
+		}
+
+		c.local = &LocalConfig{path: path + LocalConfigSuffix}
+	}
+
+	files := make(map[string]*Entry)
+	for name, e := range c.Files {
+		if e.layer == LocalLayer {
+			files[name] = e
+		}
+	}
+	c.local.Files = files
+
+	b, err := json.MarshalIndent(c.local, "", "\t")
+	if err != nil || string(b) == string(c.local.saved) {
+		return err
+	}
+
+	// an empty local config that doesn't exist yet isn't created
+	if c.local.saved == nil && len(files) == 0 {
+		return nil
+	}
+
+	err = WriteFileAtomic(c.local.path, b, 0644)
+	if err != nil {
+		return err
+	}
+
+	c.local.saved = b
+	return nil
+}
+
+// LocalRoot will return the absolute path to the folder where the files of
+// the local entries reside.
+func (c *Config) LocalRoot() string {
+	if c.local != nil && c.local.DotPath != "" {
+		return ExpandPath(c.local.DotPath)
+	}
+
+	return DefaultLocalRoot()
+}
+
+// Profile will return the profile of this machine, as it is set in the
+// local config.
+func (c *Config) Profile() string {
+	if c.local == nil {
+		return ""
+	}
+
+	return c.local.Profile
+}
+
+// Values will return the template values of this machine, as they are set
+// in the local config.
+func (c *Config) Values() map[string]string {
+	if c.local == nil {
+		return nil
+	}
+
+	return c.local.Values
+}
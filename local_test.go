@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigLocal(t *testing.T) {
+	c, tempDir := newTestLayerConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	c.Files["bash"] = &Entry{Path: "~/.bashrc"}
+	c.Files["tmux"] = &Entry{Path: "~/.tmux.conf"}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(c.path + LocalConfigSuffix); !os.IsNotExist(err) {
+		t.Error("expected no local config without local entries")
+	}
+
+	local := filepath.Join(tempDir, "local")
+	writeTestFile(t, c.path+LocalConfigSuffix, `{
+		"dot_path": "`+local+`",
+		"files": {"bash": "~/.bashrc-work"},
+		"disabled": ["tmux", "shared"],
+		"profile": "work",
+		"values": {"email": "jane@example.com"}
+	}`)
+
+	c, err := NewConfig(c.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e := c.Files["bash"]; e.Layer() != LocalLayer || e.Path != "~/.bashrc-work" {
+		t.Errorf("expected the local entry to override bash, got %+v", e)
+	}
+
+	if _, ok := c.Files["tmux"]; ok {
+		t.Error("expected tmux to be disabled")
+	}
+
+	if _, ok := c.Files["shared"]; ok {
+		t.Error("expected the entry of the layer to be disabled")
+	}
+
+	if p := c.Paths("bash"); p.RepoDir != filepath.Join(local, "files", "bash") {
+		t.Errorf("expected the files of bash in the local folder, got %s", p.RepoDir)
+	}
+
+	if c.Profile() != "work" {
+		t.Errorf("expected the profile work, got '%s'", c.Profile())
+	}
+
+	if m := c.Machine(); m.Profile != "work" || m.Values["email"] != "jane@example.com" {
+		t.Errorf("expected the profile and the values of the local config, got %+v", m)
+	}
+
+	// the overridden and disabled entries are kept
+	c.Files["ssh"] = &Entry{Path: "~/.ssh/config", layer: LocalLayer}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := ioutil.ReadFile(c.path)
+	for _, s := range []string{"~/.bashrc\"", "~/.tmux.conf"} {
+		if !strings.Contains(string(data), s) {
+			t.Errorf("expected %s in the config, got %s", s, data)
+		}
+	}
+	if strings.Contains(string(data), "ssh") {
+		t.Errorf("expected the local entry in the local config only, got %s", data)
+	}
+
+	data, _ = ioutil.ReadFile(filepath.Join(tempDir, "team", ConfigFileName))
+	if !strings.Contains(string(data), "shared") {
+		t.Errorf("expected the disabled entry of the layer to be kept, got %s", data)
+	}
+
+	c, err = NewConfig(c.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e := c.Files["ssh"]; e == nil || e.Layer() != LocalLayer {
+		t.Errorf("expected ssh in the local config, got %v", e)
+	}
+
+	if c.Profile() != "work" || len(c.local.Disabled) != 2 || c.Values()["email"] == "" {
+		t.Errorf("expected the settings of the local config to be kept, got %+v", c.local)
+	}
+}
+
+func TestTrackFilesLocal(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+	defer useTestConfig(t, c)()
+
+	local := filepath.Join(tempDir, "local")
+	writeTestFile(t, c.path+LocalConfigSuffix, `{"dot_path": "`+local+`"}`)
+
+	path := filepath.Join(tempDir, "home", ".vimrc")
+	writeTestFile(t, path, "vim")
+
+	err := TrackFiles([]string{""}, []*Entry{{Path: path, layer: LocalLayer}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := filepath.Join(local, "files", "vimrc", ".vimrc")
+	if link, _ := os.Readlink(path); link != repo {
+		t.Errorf("expected %s to be symlinked to %s, got %s", path, repo, link)
+	}
+
+	c, err = NewConfig(c.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e := c.Files["vimrc"]; e == nil || e.Layer() != LocalLayer {
+		t.Fatalf("expected a local entry, got %v", e)
+	}
+
+	err = UntrackFiles([]string{"vimrc"}, UntrackOptions{Yes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(local, "files", "vimrc")); !os.IsNotExist(err) {
+		t.Error("expected the files of the local entry to be removed")
+	}
+}
@@ -0,0 +1,119 @@
+// lock.go will hold the advisory lock that prevents two dot processes from
+// changing the same repository at the same time.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ErrLocked is returned by TryLockRepo when another process holds the lock
+var ErrLocked = errors.New("repository is locked by another dot process")
+
+// TryLockRepo will take an exclusive advisory lock on the folder `root`
+// without waiting, when another process already holds the lock ErrLocked is
+// returned. The returned function releases the lock. The lock is released
+// as well when the process exits.
+func TryLockRepo(root string) (func(), error) {
+	return lockRepo(root, false)
+}
+
+// LockRepo will take an exclusive advisory lock on the folder `root`, it
+// waits until the lock is released when another process holds it.
+func LockRepo(root string) (func(), error) {
+	unlock, err := lockRepo(root, false)
+	if err != ErrLocked {
+		return unlock, err
+	}
+
+	PrintBody(fmt.Sprintf("Waiting for another dot process to finish with %s", root))
+	return lockRepo(root, true)
+}
+
+// lockDotRepo will lock the folders the config the .dotconfig file points to
+// writes to, see lockRoots, it is used by the commands that make changes.
+// When there is no valid .dotconfig file nothing is locked, the command
+// itself will report that.
+func lockDotRepo() func() {
+	c, err := NewConfig(PathDotConfig)
+	if err != nil {
+		return func() {}
+	}
+
+	unlock, err := LockRoots(c.lockRoots())
+	if err != nil {
+		PrintBodyError(err.Error())
+		os.Exit(1)
+	}
+
+	return unlock
+}
+
+// lockRoots will return the folders that are written when the config `c`
+// is saved: the repository, the folders of the layers and of the local
+// entries, and the folder of the .dotconfig.local file.
+func (c *Config) lockRoots() []string {
+	roots := append(c.Roots(), c.LocalRoot())
+
+	path := c.path
+	if path == "" {
+		path = PathDotConfig
+	}
+
+	return append(roots, filepath.Dir(path))
+}
+
+// LockRoots will lock the folders `roots` with LockRepo. They are locked in
+// sorted order, so two processes that lock the same folders can't wait on
+// each other. Folders that don't exist yet are skipped. The returned
+// function releases every lock.
+func LockRoots(roots []string) (func(), error) {
+	sorted := make([]string, 0, len(roots))
+	seen := make(map[string]bool)
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		if !seen[root] {
+			seen[root] = true
+			sorted = append(sorted, root)
+		}
+	}
+	sort.Strings(sorted)
+
+	var unlocks []func()
+	unlockAll := func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+
+	for _, root := range sorted {
+		if _, err := os.Stat(root); os.IsNotExist(err) {
+			continue
+		}
+
+		unlock, err := LockRepo(root)
+		if err != nil {
+			unlockAll()
+			return nil, fmt.Errorf("not able to lock %s (%s)", root, err)
+		}
+		unlocks = append(unlocks, unlock)
+	}
+
+	return unlockAll, nil
+}
+
+// lockOrExit will lock the repository `root`, and exit when that isn't
+// possible.
+func lockOrExit(root string) func() {
+	unlock, err := LockRepo(root)
+	if err != nil {
+		PrintBodyError(fmt.Sprintf("not able to lock %s (%s)", root, err))
+		os.Exit(1)
+	}
+
+	return unlock
+}
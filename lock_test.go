@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTryLockRepo(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	unlock, err := TryLockRepo(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a second lock on the same repository should fail
+	if _, err := TryLockRepo(tempDir); err != ErrLocked {
+		t.Errorf("expected ErrLocked, got %v", err)
+	}
+
+	// after unlocking it should succeed again
+	unlock()
+
+	unlock, err = TryLockRepo(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+
+	// no lock file should end up in the repository
+	files, _ := ioutil.ReadDir(tempDir)
+	if len(files) != 0 {
+		t.Errorf("expected no files, got %d", len(files))
+	}
+}
+
+func TestLockRoots(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	repo := filepath.Join(tempDir, "repo")
+	layer := filepath.Join(tempDir, "layer")
+	os.Mkdir(repo, 0755)
+	os.Mkdir(layer, 0755)
+
+	// folders that don't exist are skipped, duplicates are locked once
+	unlock, err := LockRoots([]string{repo, layer, repo + "/", filepath.Join(tempDir, "local")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, root := range []string{repo, layer} {
+		if _, err := TryLockRepo(root); err != ErrLocked {
+			t.Errorf("expected %s to be locked, got %v", root, err)
+		}
+	}
+
+	unlock()
+
+	for _, root := range []string{repo, layer} {
+		unlock, err := TryLockRepo(root)
+		if err != nil {
+			t.Errorf("expected %s to be unlocked, got %v", root, err)
+			continue
+		}
+		unlock()
+	}
+}
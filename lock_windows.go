@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	errorLockViolation syscall.Errno = 33
+)
+
+// lockRepo will take an exclusive lock with LockFileEx for the folder
+// `root`, without waiting unless `wait` is set. Folders can't be locked on
+// Windows, so a file in the temporary folder is locked instead, its name is
+// derived from the path of `root`. This way no lock file ends up in the
+// repository.
+func lockRepo(root string, wait bool) (func(), error) {
+	if _, err := os.Stat(root); err != nil {
+		return nil, err
+	}
+
+	path, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	// paths on Windows aren't case sensitive
+	hash := sha256.Sum256([]byte(strings.ToLower(filepath.Clean(path))))
+	lockPath := filepath.Join(os.TempDir(), "dot-"+hex.EncodeToString(hash[:8])+".lock")
+
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := uintptr(lockfileExclusiveLock)
+	if !wait {
+		flags |= lockfileFailImmediately
+	}
+
+	// the first byte of the file is locked
+	ol := new(syscall.Overlapped)
+	r, _, err := procLockFileEx.Call(f.Fd(), flags, 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+	if r == 0 {
+		f.Close()
+		if err == errorLockViolation {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+
+	return func() {
+		procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+		f.Close()
+	}, nil
+}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"runtime"
 )
 
 const (
@@ -11,21 +12,96 @@ const (
 )
 
 var (
-	syncCmd = flag.NewFlagSet("sync", flag.ExitOnError)
-	addCmd  = flag.NewFlagSet("add", flag.ExitOnError)
-	rmCmd   = flag.NewFlagSet("rm", flag.ExitOnError)
-	listCmd = flag.NewFlagSet("list", flag.ExitOnError)
+	initCmd     = flag.NewFlagSet("init", flag.ExitOnError)
+	syncCmd     = flag.NewFlagSet("sync", flag.ExitOnError)
+	addCmd      = flag.NewFlagSet("add", flag.ExitOnError)
+	rmCmd       = flag.NewFlagSet("rm", flag.ExitOnError)
+	forgetCmd   = flag.NewFlagSet("forget", flag.ExitOnError)
+	mvCmd       = flag.NewFlagSet("mv", flag.ExitOnError)
+	listCmd     = flag.NewFlagSet("list", flag.ExitOnError)
+	adoptCmd    = flag.NewFlagSet("adopt", flag.ExitOnError)
+	collectCmd  = flag.NewFlagSet("collect", flag.ExitOnError)
+	diffCmd     = flag.NewFlagSet("diff", flag.ExitOnError)
+	statusCmd   = flag.NewFlagSet("status", flag.ExitOnError)
+	configCmd   = flag.NewFlagSet("config", flag.ExitOnError)
+	scriptsCmd  = flag.NewFlagSet("scripts", flag.ExitOnError)
+	packagesCmd = flag.NewFlagSet("packages", flag.ExitOnError)
+
+	// Flags for 'init' command
+	initGit = initCmd.Bool("git", false, "Initialize a git repository that ignores the backup folder")
+
+	// Flags for 'sync' command
+	syncJobs         = syncCmd.Int("jobs", runtime.NumCPU(), "Number of entries that are synced concurrently")
+	syncDryRun       = syncCmd.Bool("dry-run", false, "Only show what would be done")
+	syncAdopt        = syncCmd.Bool("adopt", false, "Replace the repository version of entries that aren't symlinked with the local version")
+	syncAllowSystem  = syncCmd.Bool("allow-system", false, "Sync entries outside the home folder, and install system entries")
+	syncPrivilege    = syncCmd.String("privilege", "auto", "Privilege helper for system entries: auto, sudo, doas or none")
+	syncSystemRoot   = syncCmd.String("system-root", "/", "Directory in which system entries will be installed")
+	syncSystemDryRun = syncCmd.Bool("system-dry-run", false, "Only show which system entries would be installed")
 
 	// Flags for 'add' command
-	addName = addCmd.String("name", "", "Name for the data")
-	addPath = addCmd.String("path", "", "Path to the data")
-	addPush = addCmd.Bool("push", false, "Push changes to a git repository")
+	addName        = addCmd.String("name", "", "Name for the data, derived from the path when omitted")
+	addPath        = addCmd.String("path", "", "Path to the data, more paths can be passed as arguments")
+	addPush        = addCmd.Bool("push", false, "Push changes to a git repository")
+	addAllowSystem = addCmd.Bool("allow-system", false, "Allow a path outside the home folder")
+	addBlock       = addCmd.Bool("block", false, "Only manage a block inside the file, delimited by '# BEGIN dot:[name]' and '# END dot:[name]'")
+	addComment     = addCmd.String("comment", DefaultBlockComment, "Comment prefix of the markers of a block")
+	addFragments   = addCmd.Bool("fragments", false, "Assemble the file from the fragments in the repository, the file becomes the first fragment")
+	addMerge       = addCmd.Bool("merge", false, "Only manage the keys of a JSON, YAML, TOML or INI file that are in the repository, they are merged into the file")
+	addFormat      = addCmd.String("format", "", "Format of a merged file: json, yaml, toml or ini, derived from its extension when omitted")
+	addLocal       = addCmd.Bool("local", false, "Add the entries to the local config of this machine, .dotconfig.local, which is never committed")
+	addLayer       = addCmd.String("layer", MainLayer, "Layer of the repository to add the entries to, see the layers in .dotconfig")
+	addSystem      = addCmd.Bool("system", false, "Add as system entry, installed as a copy with explicit owner and mode")
+	addOwner       = addCmd.String("owner", "", "Owner of a system entry, defaults to the current owner")
+	addGroup       = addCmd.String("group", "", "Group of a system entry, defaults to the current group")
+	addMode        = addCmd.String("mode", "", "Octal mode of a system entry, defaults to the current mode")
+	addOrder       = addCmd.Int("order", 0, "Entries with a lower order are synced first")
+	addInclude     StringList
+	addExclude     StringList
+	addAfter       StringList
+
+	// Flags for 'mv' command
+	mvName        = mvCmd.String("name", "", "New name of the entry")
+	mvPath        = mvCmd.String("path", "", "New path of the entry")
+	mvPush        = mvCmd.Bool("push", false, "Push changes to a git repository")
+	mvAllowSystem = mvCmd.Bool("allow-system", false, "Allow a path outside the home folder")
+
+	// Flags for 'collect' command
+	collectDryRun = collectCmd.Bool("dry-run", false, "Only show which keys would be collected")
+	collectPush   = collectCmd.Bool("push", false, "Push changes to a git repository")
+
+	// Flags for 'diff' command
+	diffBackup   = diffCmd.Bool("backup", false, "Compare with the backup folder instead of the repository")
+	diffRevision = diffCmd.String("rev", "", "Compare with a git revision of the repository, e.g. HEAD~1")
+	diffStat     = diffCmd.Bool("stat", false, "Only show a summary of the changed files")
 
 	// Flags for 'rm' command
-	rmName = rmCmd.String("name", "", "Name of the data to remove")
-	rmPush = rmCmd.Bool("push", false, "Push changes to a git repository")
+	rmName          = rmCmd.String("name", "", "Name of the data to remove, more names or glob patterns can be passed as arguments")
+	rmPush          = rmCmd.Bool("push", false, "Push changes to a git repository")
+	rmKeepRepo      = rmCmd.Bool("keep-repo", false, "Only replace the symlink with a copy on this machine, keep the entry in the repository")
+	rmRestoreBackup = rmCmd.Bool("restore-backup", false, "Restore the version in the backup folder instead of the one in the repository")
+	rmDryRun        = rmCmd.Bool("dry-run", false, "Only show what would be done")
+	rmYes           = rmCmd.Bool("y", false, "Don't ask for confirmation")
+
+	// Flags for 'scripts' command
+	scriptsDryRun = scriptsCmd.Bool("dry-run", false, "Only show which scripts would run")
+
+	// Flags for 'packages' command
+	packagesDryRun    = packagesCmd.Bool("dry-run", false, "Only show which packages would be installed")
+	packagesPrivilege = packagesCmd.String("privilege", "auto", "Privilege helper for the package managers of the system: auto, sudo, doas or none")
+
+	// Flags for 'forget' command
+	forgetPush   = forgetCmd.Bool("push", false, "Push changes to a git repository")
+	forgetDryRun = forgetCmd.Bool("dry-run", false, "Only show what would be done")
+	forgetYes    = forgetCmd.Bool("y", false, "Don't ask for confirmation")
 )
 
+func init() {
+	addCmd.Var(&addInclude, "include", "Glob pattern of paths inside a folder to track, can be repeated")
+	addCmd.Var(&addExclude, "exclude", "Glob pattern of paths inside a folder to ignore, can be repeated")
+	addCmd.Var(&addAfter, "after", "Name of an entry that has to be synced first, can be repeated")
+}
+
 func main() {
 	if len(os.Args) < 2 {
 		printUsage()
@@ -33,6 +109,15 @@ func main() {
 	}
 
 	switch os.Args[1] {
+	case "init":
+		initCmd.Parse(os.Args[2:])
+
+		if len(initCmd.Args()) > 1 {
+			printUsage()
+			os.Exit(1)
+		}
+
+		CommandInit(initCmd.Arg(0), *initGit)
 	case "sync":
 		syncCmd.Parse(os.Args[2:])
 
@@ -41,25 +126,109 @@ func main() {
 			os.Exit(1)
 		}
 
-		CommandSync()
+		CommandSync(SyncOptions{
+			AllowSystem:  *syncAllowSystem,
+			Jobs:         *syncJobs,
+			DryRun:       *syncDryRun,
+			Adopt:        *syncAdopt,
+			Privilege:    *syncPrivilege,
+			SystemRoot:   *syncSystemRoot,
+			SystemDryRun: *syncSystemDryRun,
+		})
 	case "add":
-		addCmd.Parse(os.Args[2:])
+		paths := parseArgs(addCmd, os.Args[2:])
+		if *addPath != "" {
+			paths = append([]string{*addPath}, paths...)
+		}
 
-		if *addName == "" || *addPath == "" {
+		if len(paths) == 0 {
 			addCmd.PrintDefaults()
 			os.Exit(1)
 		}
 
-		CommandAdd(*addName, *addPath, *addPush, false)
+		if *addSystem {
+			if *addName == "" || len(paths) != 1 {
+				addCmd.PrintDefaults()
+				os.Exit(1)
+			}
+
+			CommandAddSystem(*addName, paths[0], *addOwner, *addGroup, *addMode, *addPush)
+			return
+		}
+
+		if (*addLocal && *addLayer != MainLayer) || (*addBlock && *addFragments) ||
+			(*addMerge && (*addBlock || *addFragments)) || (*addFormat != "" && !*addMerge) {
+			addCmd.PrintDefaults()
+			os.Exit(1)
+		}
+
+		e := &Entry{
+			Include: addInclude,
+			Exclude: addExclude,
+			Order:   *addOrder,
+			After:   addAfter,
+			layer:   *addLayer,
+		}
+		if *addLocal {
+			e.layer = LocalLayer
+		}
+		if *addFragments {
+			e.Mode = EntryModeFragments
+		}
+		if *addMerge {
+			e.Mode = EntryModeMerge
+			e.Format = *addFormat
+		}
+		if *addBlock {
+			e.Mode = EntryModeBlock
+			if *addComment != DefaultBlockComment {
+				e.Comment = *addComment
+			}
+		}
+		CommandAdd(paths, *addName, e, *addPush, false, *addAllowSystem)
 	case "rm":
-		rmCmd.Parse(os.Args[2:])
+		names := parseArgs(rmCmd, os.Args[2:])
+		if *rmName != "" {
+			names = append([]string{*rmName}, names...)
+		}
 
-		if *rmName == "" {
+		if len(names) == 0 || (*rmKeepRepo && *rmRestoreBackup) {
 			rmCmd.PrintDefaults()
 			os.Exit(1)
 		}
 
-		CommandRemove(*rmName, *rmPush)
+		opts := UntrackOptions{DryRun: *rmDryRun, Yes: *rmYes, Push: *rmPush}
+		switch {
+		case *rmKeepRepo:
+			opts.Mode = UntrackUnlink
+		case *rmRestoreBackup:
+			opts.Mode = UntrackBackup
+		}
+
+		CommandRemove(names, opts)
+	case "forget":
+		names := parseArgs(forgetCmd, os.Args[2:])
+
+		if len(names) == 0 {
+			forgetCmd.PrintDefaults()
+			os.Exit(1)
+		}
+
+		CommandRemove(names, UntrackOptions{
+			Mode:   UntrackForget,
+			DryRun: *forgetDryRun,
+			Yes:    *forgetYes,
+			Push:   *forgetPush,
+		})
+	case "mv":
+		args := parseArgs(mvCmd, os.Args[2:])
+
+		if len(args) != 1 || (*mvName == "" && *mvPath == "") {
+			mvCmd.PrintDefaults()
+			os.Exit(1)
+		}
+
+		CommandMove(args[0], *mvName, *mvPath, *mvPush, *mvAllowSystem)
 	case "list":
 		listCmd.Parse(os.Args[2:])
 
@@ -69,12 +238,110 @@ func main() {
 		}
 
 		CommandList()
+	case "adopt":
+		args := parseArgs(adoptCmd, os.Args[2:])
+
+		if len(args) != 1 {
+			printUsage()
+			os.Exit(1)
+		}
+
+		CommandAdopt(args[0])
+	case "collect":
+		names := parseArgs(collectCmd, os.Args[2:])
+
+		CommandCollect(names, *collectDryRun, *collectPush)
+	case "diff":
+		args := parseArgs(diffCmd, os.Args[2:])
+
+		if *diffBackup && *diffRevision != "" {
+			diffCmd.PrintDefaults()
+			os.Exit(1)
+		}
+
+		CommandDiff(args, DiffOptions{
+			Backup:   *diffBackup,
+			Revision: *diffRevision,
+			Stat:     *diffStat,
+		})
+	case "status":
+		names := parseArgs(statusCmd, os.Args[2:])
+
+		CommandStatus(names)
+	case "config":
+		configCmd.Parse(os.Args[2:])
+
+		if len(configCmd.Args()) != 1 || configCmd.Arg(0) != "validate" {
+			printUsage()
+			os.Exit(1)
+		}
+
+		if !CommandConfigValidate() {
+			os.Exit(1)
+		}
+	case "scripts":
+		args := parseArgs(scriptsCmd, os.Args[2:])
+
+		if len(args) == 0 {
+			args = []string{"list"}
+		}
+
+		switch args[0] {
+		case "list":
+			CommandScriptsList()
+		case "run":
+			CommandScriptsRun(args[1:], *scriptsDryRun)
+		case "reset":
+			CommandScriptsReset(args[1:])
+		default:
+			printUsage()
+			os.Exit(1)
+		}
+	case "packages":
+		args := parseArgs(packagesCmd, os.Args[2:])
+
+		if len(args) == 0 {
+			args = []string{"status"}
+		}
+
+		if len(args) != 1 {
+			printUsage()
+			os.Exit(1)
+		}
+
+		switch args[0] {
+		case "status":
+			CommandPackagesStatus()
+		case "install":
+			if !CommandPackagesInstall(*packagesDryRun, *packagesPrivilege) {
+				os.Exit(1)
+			}
+		default:
+			printUsage()
+			os.Exit(1)
+		}
 	default:
 		printUsage()
 		os.Exit(0)
 	}
 }
 
+// parseArgs will parse the flags of `fs` from `args`, flags may come after
+// the positional arguments, e.g. `dot mv vim -name vi`. It returns the
+// positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			return positional
+		}
+
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
 func printUsage() {
 	usage := fmt.Sprintf(`Dot - simple dotfile manager
 
@@ -86,10 +353,20 @@ Version: %s
 
 Commands:
 
+    init    create a new dot repository in the given folder
     sync    syncs all files that are being tracked
-    add     add a file or folder for tracking
-    rm      remove a file from tracking
+    add     add files or folders for tracking
+    rm      remove entries from tracking
+    forget  remove entries from the repository without restoring them
+    mv      rename an entry or move it to another path
     list    list all files that are being tracked
+    adopt   replace the repository version of an entry with the local one
+    collect copy the values of the keys of merged entries from the system into the repository
+    diff    show the differences between the entries and the repository
+    status  show what sync would do with the entries, and the keys of merged entries that drifted
+    config  'config validate' checks the .dotconfig file for problems
+    scripts 'scripts list|run|reset' manages the scripts that run on sync
+    packages 'packages status|install' checks and installs the packages of packages.json
 
 Use "dot [command] -help" for more information about a command.
 `, version)
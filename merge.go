@@ -0,0 +1,286 @@
+// merge.go will hold the entries of which dot only manages some keys of a
+// structured config file, e.g. the `settings.json` of VS Code or
+// `.gitconfig`, which are written by the applications themselves. The file
+// of the entry in the files folder is a partial document with the keys dot
+// manages, it's deep merged into the target by key path, the values in the
+// repository win. See structured.go for the formats.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// planMerge will determine whether keys of the entry of `s` have to be
+// merged into the target, see PlanEntry.
+func planMerge(s *Step) {
+	p := EntryPaths{Target: s.Target, Repo: s.Repo, Backup: s.Backup}
+
+	format, err := s.Entry.format()
+	if err != nil {
+		s.Action = ActionSkip
+		s.Reason = err.Error()
+		return
+	}
+
+	ours, err := ioutil.ReadFile(s.Repo)
+	if err != nil {
+		s.Action = ActionSkip
+		s.Reason = fmt.Sprintf("the keys of the entry aren't present in %s", s.Repo)
+		return
+	}
+
+	_, content, _, err := readBlockTarget(s.config, p)
+	if err != nil {
+		s.Action = ActionSkip
+		s.Reason = err.Error()
+		return
+	}
+
+	_, keys, err := MergeDocument(format, string(ours), content)
+	if err != nil {
+		s.Action = ActionSkip
+		s.Reason = fmt.Sprintf("not able to merge %s into %s (%s)", s.Repo, s.Target, err)
+		return
+	}
+
+	s.Keys = keys
+	s.Action = ActionNone
+	if len(keys) > 0 {
+		s.Action = ActionMerge
+	}
+}
+
+// driftedKeys will return the keys of the merged entry `name` of which the
+// target has a different value than the repository, e.g. because the
+// application changed them, and the keys that are missing in the target.
+func driftedKeys(c *Config, name string) ([]string, []string, error) {
+	format, err := c.Files[name].format()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p := c.Paths(name)
+	ours, err := ioutil.ReadFile(p.Repo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, content, _, err := readBlockTarget(c, p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	o, err := ParseDocument(format, string(ours))
+	if err != nil {
+		return nil, nil, fmt.Errorf("not able to read %s as %s (%s)", p.Repo, format, err)
+	}
+
+	t, err := ParseDocument(format, content)
+	if err != nil {
+		return nil, nil, fmt.Errorf("not able to read %s as %s (%s)", p.Target, format, err)
+	}
+
+	var drifted, missing []string
+	for _, leaf := range o.Leaves() {
+		value, ok := t.Get(leaf.Path)
+		switch {
+		case !ok:
+			missing = append(missing, leaf.Key())
+		case value != leaf.Value:
+			drifted = append(drifted, leaf.Key())
+		}
+	}
+
+	return drifted, missing, nil
+}
+
+// mergeKeys will merge the keys in the repository into the target. The
+// first time keys are merged into an existing file, the file is copied to
+// the backup folder.
+func (s *Step) mergeKeys() error {
+	p := EntryPaths{Target: s.Target, Repo: s.Repo, Backup: s.Backup}
+
+	format, err := s.Entry.format()
+	if err != nil {
+		return err
+	}
+
+	ours, err := ioutil.ReadFile(s.Repo)
+	if err != nil {
+		return err
+	}
+
+	target, content, mode, err := readBlockTarget(s.config, p)
+	if err != nil {
+		return err
+	}
+
+	merged, keys, err := MergeDocument(format, string(ours), content)
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+
+	if _, err := os.Lstat(s.Backup); content != "" && os.IsNotExist(err) {
+		err = os.MkdirAll(filepath.Dir(s.Backup), 0755)
+		if err != nil {
+			return err
+		}
+
+		err = CopyFile(target, s.Backup)
+		if err != nil {
+			return fmt.Errorf("not able to copy %s to %s (%s)", target, s.Backup, err)
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(target, []byte(merged), mode)
+}
+
+// trackMerge will track the entry `e` of which dot manages the keys in the
+// target. The keys are copied from the target, remove the ones you don't
+// want to manage from the file in the repository afterwards.
+func trackMerge(c *Config, name string, e *Entry) error {
+	p := c.LayerPaths(e.layer, name, e.Path)
+
+	if err := e.validateMode(); err != nil {
+		return err
+	}
+
+	format, err := e.format()
+	if err != nil {
+		return err
+	}
+
+	children, err := CheckOverlap(c, name, p.Target)
+	if err != nil {
+		return err
+	}
+	if len(children) > 0 {
+		return fmt.Errorf("not able to merge keys into %s, it %s", p.Target, children[0])
+	}
+
+	if _, err := os.Lstat(p.Repo); err == nil {
+		return fmt.Errorf("%s is already present", p.Repo)
+	}
+
+	_, content, _, err := readBlockTarget(c, p)
+	if err != nil {
+		return err
+	}
+
+	if _, err := ParseDocument(format, content); err != nil {
+		return fmt.Errorf("not able to read %s as %s (%s)", p.Target, format, err)
+	}
+
+	err = os.MkdirAll(p.RepoDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	PrintBody(fmt.Sprintf("Copying the keys of %s to %s", p.Target, p.Repo))
+	return ioutil.WriteFile(p.Repo, []byte(content), 0644)
+}
+
+// collectKeys will return the keys in `repo` of the merged entry `name`,
+// with the values that differ replaced by the ones in its target, see
+// CollectDocument.
+func collectKeys(c *Config, name, repo string) (string, []string, error) {
+	format, err := c.Files[name].format()
+	if err != nil {
+		return "", nil, err
+	}
+
+	ours, err := ioutil.ReadFile(repo)
+	if err != nil {
+		return "", nil, err
+	}
+
+	p := c.Paths(name)
+	_, content, _, err := readBlockTarget(c, p)
+	if err != nil {
+		return "", nil, err
+	}
+
+	collected, keys, err := CollectDocument(format, string(ours), content)
+	if err != nil {
+		return "", nil, fmt.Errorf("not able to collect the keys of %s from %s (%s)", name, p.Target, err)
+	}
+
+	return collected, keys, nil
+}
+
+// CollectEntries will replace the values of the keys of the merged entries
+// `names`, or of all merged entries when empty, in the repository with the
+// values in their targets that differ. This pulls the changes applications
+// made to the keys dot manages back into the repository. The changes are
+// written to `w`, nothing is written to the repository when `dryRun` is
+// set. It returns the names of the entries that changed.
+func CollectEntries(w io.Writer, c *Config, names []string, dryRun bool) ([]string, error) {
+	if len(names) == 0 {
+		for _, name := range c.SortedNames() {
+			if c.Files[name].Mode == EntryModeMerge {
+				names = append(names, name)
+			}
+		}
+	}
+
+	var changed []string
+	for _, name := range names {
+		e, ok := c.Files[name]
+		if !ok {
+			return changed, fmt.Errorf("'%s' is not being tracked. Get the list of tracked files with `dot list`", name)
+		}
+		if e.Mode != EntryModeMerge {
+			return changed, fmt.Errorf("'%s' doesn't merge keys, use `dot adopt` instead", name)
+		}
+
+		repo := c.Paths(name).Repo
+		collected, keys, err := collectKeys(c, name, repo)
+		if err != nil {
+			return changed, err
+		}
+
+		if len(keys) == 0 {
+			FprintBody(w, fmt.Sprintf("%s is up to date", name))
+			continue
+		}
+
+		FprintBody(w, fmt.Sprintf("%s: collecting %s", name, strings.Join(keys, ", ")))
+		changed = append(changed, name)
+		if dryRun {
+			continue
+		}
+
+		err = WriteFileAtomic(repo, []byte(collected), 0644)
+		if err != nil {
+			return changed, err
+		}
+	}
+
+	return changed, nil
+}
+
+// collectFile will write the keys in `repo` of the merged entry `name`,
+// with the values of its target, to `dst` and return `dst`. When `repo`
+// doesn't exist nothing is written, so `dst` doesn't exist.
+func collectFile(c *Config, name, repo, dst string) (string, error) {
+	collected, _, err := collectKeys(c, name, repo)
+	if os.IsNotExist(err) {
+		return dst, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return dst, ioutil.WriteFile(dst, []byte(collected), 0600)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSyncMerge(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	target := filepath.Join(tempDir, "home", ".config", "app", "settings.json")
+	writeTestFile(t, target, "{\n\t\"font\": {\"size\": 12},\n\t\"telemetry\": true\n}\n")
+	repo := filepath.Join(c.Root(), "files", "app", "settings.json")
+	writeTestFile(t, repo, "{\n\t\"font\": {\"size\": 14}\n}\n")
+	c.Files["app"] = &Entry{Path: target, Mode: EntryModeMerge}
+
+	plan, err := PlanSync(c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step := plan.Steps[0]
+	if step.Action != ActionMerge || !reflect.DeepEqual(step.Keys, []string{"font.size"}) {
+		t.Fatalf("expected font.size to be merged, got %s %v", step.Action, step.Keys)
+	}
+
+	if plan.Execute(ioutil.Discard, 1) > 0 {
+		t.Fatal("expected the plan to succeed")
+	}
+
+	expected := "{\n\t\"font\": {\"size\": 14},\n\t\"telemetry\": true\n}\n"
+	if data, _ := ioutil.ReadFile(target); string(data) != expected {
+		t.Errorf("expected %q, got %q", expected, data)
+	}
+
+	if _, err := os.Stat(filepath.Join(c.Root(), "backup", "app", "settings.json")); err != nil {
+		t.Error("expected a backup of the original")
+	}
+
+	// the application changes a key dot manages, and one it doesn't
+	writeTestFile(t, target, "{\"font\": {\"size\": 16}, \"telemetry\": false}")
+
+	var out bytes.Buffer
+	differs, err := DiffEntries(&out, c, []string{"app"}, DiffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !differs || !strings.Contains(out.String(), "+\t\"font\": {\"size\": 16}") {
+		t.Errorf("expected the diff to show the changed key, got %q", out.String())
+	}
+
+	out.Reset()
+	changed, err := CollectEntries(&out, c, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changed, []string{"app"}) || !strings.Contains(out.String(), "font.size") {
+		t.Errorf("expected font.size of app to be collected, got %v %q", changed, out.String())
+	}
+
+	expected = "{\n\t\"font\": {\"size\": 16}\n}\n"
+	if data, _ := ioutil.ReadFile(repo); string(data) != expected {
+		t.Errorf("expected %q, got %q", expected, data)
+	}
+
+	plan, _ = PlanSync(c, true)
+	if plan.Steps[0].Action != ActionNone {
+		t.Errorf("expected the keys to be up to date, got %s", plan.Steps[0].Action)
+	}
+
+	defer useTestConfig(t, c)()
+	if err := UntrackFiles([]string{"app"}, UntrackOptions{Yes: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := ioutil.ReadFile(target); !strings.Contains(string(data), "16") {
+		t.Errorf("expected the merged keys to remain, got %q", data)
+	}
+}
+
+func TestTrackMerge(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	target := filepath.Join(tempDir, "home", ".gitconfig")
+	writeTestFile(t, target, "[user]\n\tname = Jane\n")
+
+	if err := trackMerge(c, "git", &Entry{Path: target, Mode: EntryModeMerge}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := ioutil.ReadFile(filepath.Join(c.Root(), "files", "git", ".gitconfig"))
+	if string(data) != "[user]\n\tname = Jane\n" {
+		t.Errorf("expected the keys to be copied, got %q", data)
+	}
+
+	other := filepath.Join(tempDir, "home", ".bashrc")
+	if err := trackMerge(c, "bash", &Entry{Path: other, Mode: EntryModeMerge}); err == nil {
+		t.Error("expected an error for a file of an unknown format")
+	}
+
+	writeTestFile(t, other, "export A=1\n")
+	if err := trackMerge(c, "bash", &Entry{Path: other, Mode: EntryModeMerge, Format: FormatYAML}); err == nil {
+		t.Error("expected an error for a file that isn't valid YAML")
+	}
+}
+
+func TestStatusEntriesMerge(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	target := filepath.Join(tempDir, "home", ".gitconfig")
+	writeTestFile(t, target, "[user]\n\tname = John\n[core]\n\teditor = vim\n")
+	writeTestFile(t, filepath.Join(c.Root(), "files", "git", ".gitconfig"),
+		"[user]\n\tname = Jane\n\temail = jane@example.com\n[core]\n\teditor = vim\n")
+	c.Files["git"] = &Entry{Path: target, Mode: EntryModeMerge}
+
+	var out bytes.Buffer
+	changes, err := StatusEntries(&out, c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !changes || !strings.Contains(out.String(), "drifted: user.name\n") ||
+		!strings.Contains(out.String(), "missing: user.email\n") {
+		t.Errorf("expected user.name to drift and user.email to be missing, got %q", out.String())
+	}
+
+	writeTestFile(t, target, "[user]\n\tname = Jane\n\temail = jane@example.com\n[core]\n\teditor = vim\n")
+
+	out.Reset()
+	changes, err = StatusEntries(&out, c, []string{"git"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes || !strings.Contains(out.String(), "git: up to date") {
+		t.Errorf("expected git to be up to date, got %q", out.String())
+	}
+
+	if _, err := StatusEntries(&out, c, []string{"unknown"}); err == nil {
+		t.Error("expected an error for an unknown entry")
+	}
+}
+
+func TestStatusEntriesLayers(t *testing.T) {
+	c, tempDir := newTestLayerConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	c.Files["bash"] = &Entry{Path: "~/.bashrc", layer: LocalLayer}
+
+	var out bytes.Buffer
+	if _, err := StatusEntries(&out, c, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, prefix := range []string{"bash (local): ", "shared (team): ", "vim (main): "} {
+		if !strings.Contains(out.String(), prefix) {
+			t.Errorf("expected a line starting with %q, got %q", prefix, out.String())
+		}
+	}
+}
+
+func TestCommandAddMerge(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+	defer useTestConfig(t, c)()
+
+	os.Setenv("XDG_DATA_HOME", filepath.Join(tempDir, "data"))
+	defer os.Unsetenv("XDG_DATA_HOME")
+
+	// the format can't be detected from the name of the file
+	target := filepath.Join(tempDir, "home", ".config", "app", "settings.conf")
+	writeTestFile(t, target, "{\"font\": {\"size\": 12}}\n")
+
+	e := &Entry{Mode: EntryModeMerge, Format: FormatJSON}
+	CommandAdd([]string{target}, "app", e, false, false, true)
+
+	c, err := NewConfig(c.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e, ok := c.Files["app"]; !ok || e.Mode != EntryModeMerge || e.Format != FormatJSON {
+		t.Fatalf("expected app to merge keys as %s, got %+v", FormatJSON, c.Files["app"])
+	}
+
+	if _, err := os.Stat(c.Paths("app").Repo); err != nil {
+		t.Errorf("expected the keys to be copied to the repository (%s)", err)
+	}
+}
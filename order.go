@@ -0,0 +1,177 @@
+// order.go will hold the ordering of the tracked entries, this makes sure
+// entries are always processed in the same order and that entries are
+// synced after the entries they depend on.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SortedNames will return the names of the tracked entries, sorted
+// alphabetically.
+func (c *Config) SortedNames() []string {
+	names := make([]string, 0, len(c.Files))
+	for name := range c.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// SortedSystemNames will return the names of the system entries, sorted
+// alphabetically.
+func (c *Config) SortedSystemNames() []string {
+	names := make([]string, 0, len(c.System))
+	for name := range c.System {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Dependencies will return the names of the entries that have to be synced
+// before the entry `name`, sorted alphabetically. These are the entries
+// listed in its `after` setting, and the entries of which the target is a
+// parent folder of its target, e.g. `~/.config` before `~/.config/nvim`.
+func (c *Config) Dependencies(name string) []string {
+	e := c.Files[name]
+
+	deps := make(map[string]bool)
+	for _, dep := range e.After {
+		deps[dep] = true
+	}
+
+	target := ExpandPath(e.Path)
+	for other, o := range c.Files {
+		otherTarget := ExpandPath(o.Path)
+		if other != name && otherTarget != target && isNested(otherTarget, target) &&
+			len(otherTarget) < len(target) {
+			deps[other] = true
+		}
+	}
+
+	names := make([]string, 0, len(deps))
+	for dep := range deps {
+		names = append(names, dep)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// RenameDependency will make the entries that have to be synced after
+// `name` be synced after `newName` instead.
+func (c *Config) RenameDependency(name, newName string) {
+	for _, e := range c.Files {
+		for i, dep := range e.After {
+			if dep == name {
+				e.After[i] = newName
+			}
+		}
+	}
+}
+
+// SortEntries will return the names of the tracked entries in the order
+// they have to be synced. An entry always comes after its dependencies,
+// otherwise entries are sorted by their `order` setting and then by name.
+// An error is returned when an entry depends on an unknown entry, or when
+// the dependencies contain a cycle.
+func SortEntries(c *Config) ([]string, error) {
+	names := c.SortedNames()
+
+	// number of unresolved dependencies, and the reverse dependencies
+	pending := make(map[string]int)
+	dependents := make(map[string][]string)
+	for _, name := range names {
+		for _, dep := range c.Dependencies(name) {
+			if _, ok := c.Files[dep]; !ok {
+				return nil, fmt.Errorf("'%s' has to be synced after '%s', "+
+					"but '%s' isn't being tracked", name, dep, dep)
+			}
+
+			pending[name]++
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+
+	less := func(a, b string) bool {
+		if c.Files[a].Order != c.Files[b].Order {
+			return c.Files[a].Order < c.Files[b].Order
+		}
+		return a < b
+	}
+
+	var ready []string
+	for _, name := range names {
+		if pending[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool { return less(ready[i], ready[j]) })
+
+		name := ready[0]
+		ready = ready[1:]
+		sorted = append(sorted, name)
+
+		for _, dependent := range dependents[name] {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(sorted) < len(names) {
+		return nil, fmt.Errorf("the dependencies of the entries contain a "+
+			"cycle: %s", strings.Join(findCycle(c, pending), " -> "))
+	}
+
+	return sorted, nil
+}
+
+// findCycle will return a cycle among the entries that still have pending
+// dependencies, the first entry is repeated at the end.
+func findCycle(c *Config, pending map[string]int) []string {
+	var start string
+	for _, name := range c.SortedNames() {
+		if pending[name] > 0 {
+			start = name
+			break
+		}
+	}
+
+	// every entry with pending dependencies has a dependency with pending
+	// dependencies, so following them will end up in a cycle
+	visited := make(map[string]int)
+	path := []string{}
+	name := start
+	for {
+		if i, ok := visited[name]; ok {
+			return append(path[i:], name)
+		}
+
+		visited[name] = len(path)
+		path = append(path, name)
+
+		next := ""
+		for _, dep := range c.Dependencies(name) {
+			if pending[dep] > 0 {
+				next = dep
+				break
+			}
+		}
+
+		if next == "" {
+			return path
+		}
+		name = next
+	}
+}
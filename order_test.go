@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSortEntries(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]*Entry
+		expected []string
+	}{
+		{
+			name: "by name",
+			files: map[string]*Entry{
+				"zsh":  {Path: "/home/user/.zshrc"},
+				"bash": {Path: "/home/user/.bashrc"},
+				"git":  {Path: "/home/user/.gitconfig"},
+			},
+			expected: []string{"bash", "git", "zsh"},
+		},
+		{
+			name: "by order",
+			files: map[string]*Entry{
+				"zsh":  {Path: "/home/user/.zshrc", Order: -1},
+				"bash": {Path: "/home/user/.bashrc", Order: 1},
+				"git":  {Path: "/home/user/.gitconfig"},
+			},
+			expected: []string{"zsh", "git", "bash"},
+		},
+		{
+			name: "parent before child",
+			files: map[string]*Entry{
+				"a-nvim": {Path: "/home/user/.config/nvim"},
+				"config": {Path: "/home/user/.config", Order: 1},
+				"b-git":  {Path: "/home/user/.gitconfig"},
+			},
+			expected: []string{"b-git", "config", "a-nvim"},
+		},
+		{
+			name: "after",
+			files: map[string]*Entry{
+				"a": {Path: "/home/user/.a", After: []string{"c"}},
+				"b": {Path: "/home/user/.b"},
+				"c": {Path: "/home/user/.c", After: []string{"b"}},
+			},
+			expected: []string{"b", "c", "a"},
+		},
+	}
+
+	for _, test := range tests {
+		c := &Config{Files: test.files}
+
+		sorted, err := SortEntries(c)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(sorted, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, sorted)
+		}
+	}
+}
+
+func TestSortEntriesErrors(t *testing.T) {
+	c := &Config{Files: map[string]*Entry{
+		"a": {Path: "/home/user/.a", After: []string{"missing"}},
+	}}
+
+	_, err := SortEntries(c)
+	if err == nil || !strings.Contains(err.Error(), "'missing' isn't being tracked") {
+		t.Errorf("expected an unknown dependency error, got %v", err)
+	}
+
+	c = &Config{Files: map[string]*Entry{
+		"a": {Path: "/home/user/.a", After: []string{"c"}},
+		"b": {Path: "/home/user/.b", After: []string{"a"}},
+		"c": {Path: "/home/user/.c", After: []string{"b"}},
+		"d": {Path: "/home/user/.d"},
+	}}
+
+	_, err = SortEntries(c)
+	if err == nil || !strings.HasSuffix(err.Error(), "a -> c -> b -> a") {
+		t.Errorf("expected a cycle error, got %v", err)
+	}
+
+	// a parent that has to be synced after its child
+	c = &Config{Files: map[string]*Entry{
+		"config": {Path: "/home/user/.config", After: []string{"nvim"}},
+		"nvim":   {Path: "/home/user/.config/nvim"},
+	}}
+
+	_, err = SortEntries(c)
+	if err == nil || !strings.HasSuffix(err.Error(), "config -> nvim -> config") {
+		t.Errorf("expected a cycle error, got %v", err)
+	}
+}
+
+func TestPlanGroupsAfter(t *testing.T) {
+	plan := &Plan{Steps: []*Step{
+		{Name: "a", Target: "/home/user/.a", Entry: &Entry{}},
+		{Name: "b", Target: "/home/user/.b", Entry: &Entry{}},
+		{Name: "c", Target: "/home/user/.c", Entry: &Entry{After: []string{"a"}}},
+	}}
+
+	expected := [][]int{{0, 2}, {1}}
+	if groups := plan.groups(); !reflect.DeepEqual(groups, expected) {
+		t.Errorf("expected %v, got %v", expected, groups)
+	}
+}
+
+func TestEntryJSONOrder(t *testing.T) {
+	e := &Entry{Path: "~/.vimrc", Order: 2, After: []string{"vim"}}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"path":"~/.vimrc","order":2,"after":["vim"]}`
+	if string(data) != expected {
+		t.Errorf("expected %s, got %s", expected, data)
+	}
+}
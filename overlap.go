@@ -0,0 +1,245 @@
+// overlap.go will hold the detection of tracked entries that overlap, e.g.
+// `~/.config` and `~/.config/nvim`, and of targets that are located inside
+// the dot repository.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// OverlapKind describes how two entries overlap
+type OverlapKind int
+
+const (
+	// both entries have the same target
+	OverlapSame OverlapKind = iota
+
+	// the target of the other entry is a parent folder of the target
+	OverlapParent
+
+	// the target of the other entry is inside the target
+	OverlapChild
+)
+
+// Overlap is an entry that overlaps with a target, see Config.Overlaps
+type Overlap struct {
+	Name   string
+	Target string
+	Kind   OverlapKind
+}
+
+// String will return a description of the overlap, from the point of view
+// of the target it overlaps with
+func (o Overlap) String() string {
+	switch o.Kind {
+	case OverlapSame:
+		return fmt.Sprintf("is already tracked by '%s'", o.Name)
+	case OverlapParent:
+		return fmt.Sprintf("is inside %s, which is tracked by '%s'", o.Target, o.Name)
+	default:
+		return fmt.Sprintf("contains %s, which is tracked by '%s'", o.Target, o.Name)
+	}
+}
+
+// Overlaps will return the entries, other than `name`, of which the target
+// overlaps with the absolute path `target`, sorted by name.
+func (c *Config) Overlaps(name, target string) []Overlap {
+	target = filepath.Clean(target)
+
+	var overlaps []Overlap
+	for _, other := range c.SortedNames() {
+		if other == name {
+			continue
+		}
+
+		otherTarget := filepath.Clean(c.TargetPath(other))
+		if !isNested(target, otherTarget) {
+			continue
+		}
+
+		o := Overlap{Name: other, Target: otherTarget}
+		switch {
+		case otherTarget == target:
+			o.Kind = OverlapSame
+		case len(otherTarget) < len(target):
+			o.Kind = OverlapParent
+		default:
+			o.Kind = OverlapChild
+		}
+
+		overlaps = append(overlaps, o)
+	}
+
+	return overlaps
+}
+
+// InsideRepo reports whether the absolute path `path`, after resolving the
+// symlinks, is located inside the dot repository or one of its layers.
+// Missing parts of `path` are left as they are.
+func (c *Config) InsideRepo(path string) bool {
+	return c.insideRoot(path) != ""
+}
+
+// insideRoot will return the folder of the repository or layer that holds
+// the absolute path `path`, or an empty string when there is none
+func (c *Config) insideRoot(path string) string {
+	for _, root := range c.Roots() {
+		resolved, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			resolved = root
+		}
+
+		relPath, err := filepath.Rel(resolved, resolvePath(path))
+		if err == nil && !IsOutsideDir(relPath) {
+			return root
+		}
+	}
+
+	return ""
+}
+
+// resolvePath will resolve the symlinks in the absolute path `path`, the
+// parts of `path` that don't exist are appended to the resolved path of
+// the longest part that does.
+func resolvePath(path string) string {
+	path = filepath.Clean(path)
+
+	var missing []string
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...)
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return filepath.Join(append([]string{path}, missing...)...)
+		}
+
+		missing = append([]string{filepath.Base(path)}, missing...)
+		path = parent
+	}
+}
+
+// CheckOverlap will return an error when the entry `name` with the target
+// `target` can't be added, because it is inside the dot repository or
+// inside another entry. Entries that are inside `target` are returned, they
+// can be merged into the new entry with MergeEntry.
+func CheckOverlap(c *Config, name, target string) ([]Overlap, error) {
+	if _, ok := c.Files[name]; ok {
+		return nil, fmt.Errorf("'%s' is already being tracked", name)
+	}
+
+	return checkOverlap(c, name, target)
+}
+
+// checkOverlap is CheckOverlap for the entry `name`, which may already be
+// tracked.
+func checkOverlap(c *Config, name, target string) ([]Overlap, error) {
+	if root := c.insideRoot(target); root != "" {
+		return nil, fmt.Errorf("%s is located inside the dot repository %s", target, root)
+	}
+
+	for _, root := range c.Roots() {
+		relPath, err := filepath.Rel(resolvePath(target), resolvePath(root))
+		if err == nil && !IsOutsideDir(relPath) {
+			return nil, fmt.Errorf("%s contains the dot repository %s", target, root)
+		}
+	}
+
+	var children []Overlap
+	for _, o := range c.Overlaps(name, target) {
+		if o.Kind != OverlapChild {
+			return nil, fmt.Errorf("not able to track %s, it %s", target, o)
+		}
+		children = append(children, o)
+	}
+
+	return children, nil
+}
+
+// MergeEntry will stop tracking the entry `name` and put its files back
+// at its target, so they become part of the entry `into` that is added for
+// one of the parent folders of the target. Entries that had to be synced
+// after `name` will be synced after `into`. The config isn't saved.
+func MergeEntry(c *Config, name, into string) error {
+	p := c.Paths(name)
+	target, repo := p.Target, p.Repo
+
+	f, err := os.Lstat(target)
+	if err == nil && f.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("%s isn't symlinked, sync or remove '%s' first", target, name)
+	}
+
+	if err == nil {
+		err = os.Remove(target)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Lstat(repo); err == nil {
+		err = MakeAndMoveToDir(repo, target)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.RemoveAll(p.RepoDir)
+	if err != nil {
+		return err
+	}
+
+	delete(c.Files, name)
+
+	c.RenameDependency(name, into)
+
+	return nil
+}
+
+// Validate will check the config for problems: entries that depend on
+// unknown entries or on each other, invalid settings of the hooks, entries
+// that overlap, and entries of which the target is located inside the dot
+// repository.
+func (c *Config) Validate() []error {
+	var errs []error
+
+	if _, err := SortEntries(c); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := NewHookRunner(c, false); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, name := range c.SortedNames() {
+		target := c.TargetPath(name)
+
+		// the target itself is a symlink into the repository, only its
+		// parent folder may not be located inside of it
+		if c.InsideRepo(filepath.Dir(target)) {
+			errs = append(errs, fmt.Errorf("'%s': %s is located inside the dot "+
+				"repository %s", name, target, c.Root()))
+		}
+
+		for _, o := range c.Overlaps(name, target) {
+			// report every pair once
+			if o.Kind == OverlapChild || (o.Kind == OverlapSame && o.Name > name) {
+				continue
+			}
+
+			// the blocks of several entries can share a target
+			if o.Kind == OverlapSame && c.Files[name].Mode == EntryModeBlock &&
+				c.Files[o.Name].Mode == EntryModeBlock {
+				continue
+			}
+
+			errs = append(errs, fmt.Errorf("'%s': %s %s", name, target, o))
+		}
+	}
+
+	return errs
+}
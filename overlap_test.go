@@ -0,0 +1,192 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOverlaps(t *testing.T) {
+	c := &Config{Files: map[string]*Entry{
+		"config": {Path: "/home/user/.config"},
+		"nvim":   {Path: "/home/user/.config/nvim"},
+		"vim":    {Path: "/home/user/.vim"},
+		"vim2":   {Path: "/home/user/.vim/"},
+	}}
+
+	tests := []struct {
+		name     string
+		target   string
+		expected []Overlap
+	}{
+		{"nvim", "/home/user/.config/nvim", []Overlap{
+			{"config", "/home/user/.config", OverlapParent},
+		}},
+		{"config", "/home/user/.config", []Overlap{
+			{"nvim", "/home/user/.config/nvim", OverlapChild},
+		}},
+		{"vim", "/home/user/.vim", []Overlap{
+			{"vim2", "/home/user/.vim", OverlapSame},
+		}},
+		{"new", "/home/user/.configs", nil},
+	}
+
+	for _, test := range tests {
+		overlaps := c.Overlaps(test.name, test.target)
+		if len(overlaps) != len(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, overlaps)
+			continue
+		}
+
+		for i := range overlaps {
+			if overlaps[i] != test.expected[i] {
+				t.Errorf("%s: expected %v, got %v", test.name, test.expected[i], overlaps[i])
+			}
+		}
+	}
+}
+
+func TestCheckOverlap(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	home := filepath.Join(tempDir, "home")
+	writeTestFile(t, filepath.Join(c.Root(), "files", "config", ".config", "nvim", "init.vim"), "")
+	os.MkdirAll(home, 0755)
+	os.Symlink(filepath.Join(c.Root(), "files", "config", ".config"), filepath.Join(home, ".config"))
+
+	c.Files["config"] = &Entry{Path: filepath.Join(home, ".config")}
+	c.Files["zsh"] = &Entry{Path: filepath.Join(home, ".zsh", "zshrc")}
+
+	tests := []struct {
+		name   string
+		target string
+		err    string
+	}{
+		{"config", filepath.Join(home, ".bashrc"), "already being tracked"},
+		{"nvim", filepath.Join(home, ".config", "nvim"), "inside the dot repository"},
+		{"files", filepath.Join(c.Root(), "files"), "inside the dot repository"},
+		{"everything", tempDir, "contains the dot repository"},
+		{"zshrc", filepath.Join(home, ".zsh", "zshrc"), "already tracked by 'zsh'"},
+	}
+
+	for _, test := range tests {
+		_, err := CheckOverlap(c, test.name, test.target)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %q, got %v", test.name, test.err, err)
+		}
+	}
+
+	// a parent of an entry can be added when it's merged
+	children, err := CheckOverlap(c, "zshdir", filepath.Join(home, ".zsh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(children) != 1 || children[0].Name != "zsh" {
+		t.Errorf("expected zsh to be a child, got %v", children)
+	}
+}
+
+func TestMergeEntry(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	home := filepath.Join(tempDir, "home")
+	repo := filepath.Join(c.Root(), "files", "nvim", "nvim")
+	target := filepath.Join(home, ".config", "nvim")
+
+	writeTestFile(t, filepath.Join(repo, "init.vim"), "init")
+	os.MkdirAll(filepath.Dir(target), 0755)
+	os.Symlink(repo, target)
+
+	c.Files["nvim"] = &Entry{Path: target}
+	c.Files["plugins"] = &Entry{Path: filepath.Join(home, ".plugins"), After: []string{"nvim"}}
+
+	err := MergeEntry(c, "nvim", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(target, "init.vim"))
+	if err != nil || string(data) != "init" {
+		t.Errorf("expected the files to be moved back, got %q (%v)", data, err)
+	}
+
+	if f, err := os.Lstat(target); err != nil || f.Mode()&os.ModeSymlink != 0 {
+		t.Errorf("expected %s to be a folder", target)
+	}
+
+	if _, err := os.Lstat(filepath.Join(c.Root(), "files", "nvim")); !os.IsNotExist(err) {
+		t.Error("expected the files of nvim to be removed from the repository")
+	}
+
+	if _, ok := c.Files["nvim"]; ok {
+		t.Error("expected nvim to be removed from the config")
+	}
+
+	if after := c.Files["plugins"].After; len(after) != 1 || after[0] != "config" {
+		t.Errorf("expected plugins to be synced after config, got %v", after)
+	}
+}
+
+func TestMergeEntryNotSymlinked(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	target := filepath.Join(tempDir, "home", ".vimrc")
+	writeTestFile(t, target, "local")
+	writeTestFile(t, filepath.Join(c.Root(), "files", "vim", ".vimrc"), "repo")
+
+	c.Files["vim"] = &Entry{Path: target}
+
+	if err := MergeEntry(c, "vim", "home"); err == nil {
+		t.Error("expected an error when the target isn't symlinked")
+	}
+
+	if _, ok := c.Files["vim"]; !ok {
+		t.Error("expected vim to be left untouched")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	home := filepath.Join(tempDir, "home")
+
+	c.Files["config"] = &Entry{Path: filepath.Join(home, ".config")}
+	c.Files["nvim"] = &Entry{Path: filepath.Join(home, ".config", "nvim")}
+	c.Files["vim"] = &Entry{Path: filepath.Join(home, ".vimrc")}
+	c.Files["vim2"] = &Entry{Path: filepath.Join(home, ".vimrc")}
+	c.Files["repo"] = &Entry{Path: filepath.Join(c.Root(), "files", "x")}
+	c.Files["zsh"] = &Entry{Path: filepath.Join(home, ".zshrc")}
+
+	errs := c.Validate()
+
+	expected := []string{
+		"'nvim': " + filepath.Join(home, ".config", "nvim") + " is inside",
+		"'repo': " + filepath.Join(c.Root(), "files", "x") + " is located inside the dot repository",
+		"'vim2': " + filepath.Join(home, ".vimrc") + " is already tracked by 'vim'",
+	}
+
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+
+	for i, err := range errs {
+		if !strings.HasPrefix(err.Error(), expected[i]) {
+			t.Errorf("expected %q, got %q", expected[i], err)
+		}
+	}
+
+	delete(c.Files, "nvim")
+	delete(c.Files, "vim2")
+	delete(c.Files, "repo")
+
+	if errs := c.Validate(); len(errs) != 0 {
+		t.Errorf("expected no errors, got %v", errs)
+	}
+}
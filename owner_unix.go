@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner will return the uid and gid of the file info `f`, it reports
+// whether they are known
+func fileOwner(f os.FileInfo) (int, int, bool) {
+	stat, ok := f.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+
+	return int(stat.Uid), int(stat.Gid), true
+}
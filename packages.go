@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// name of the package manifest in the root of the repository
+const PackagesFileName = "packages.json"
+
+// Manifest holds the packages that have to be installed, by the name of the
+// package manager that installs them, e.g.:
+//
+//	{
+//		"apt": ["neovim", "tmux"],
+//		"go": ["golang.org/x/tools/gopls@latest"]
+//	}
+type Manifest map[string][]string
+
+// LoadManifest will load the package manifest of the repository `root`. The
+// manifest is optional, when it doesn't exist an empty manifest is
+// returned.
+func LoadManifest(root string) (Manifest, error) {
+	path := filepath.Join(root, PackagesFileName)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Manifest{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return nil, fmt.Errorf("not able to read %s (%s)", path, err)
+	}
+
+	return m, nil
+}
+
+// Managers will return the names of the package managers in the manifest,
+// sorted by name. An error is returned for a package manager that isn't in
+// `managers`.
+func (m Manifest) Managers(managers map[string]PackageManager) ([]string, error) {
+	var names []string
+	for name := range m {
+		if _, ok := managers[name]; !ok {
+			return nil, fmt.Errorf("unknown package manager in %s: '%s'", PackagesFileName, name)
+		}
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// PackageManager checks and installs the packages of a single package
+// manager.
+type PackageManager interface {
+	// Available reports whether the package manager is present on this
+	// machine
+	Available() bool
+
+	// Installed will return which of `packages` are installed
+	Installed(packages []string) (map[string]bool, error)
+
+	// Install will install `packages`, the output is shown to the user
+	Install(packages []string) error
+}
+
+// NewPackageManagers will return the supported package managers by the
+// name they have in the manifest. The package managers of the system use
+// `helper` to install packages.
+func NewPackageManagers(helper PrivilegeHelper) map[string]PackageManager {
+	return map[string]PackageManager{
+		"apt":    &aptManager{helper},
+		"dnf":    &dnfManager{helper},
+		"pacman": &pacmanManager{helper},
+		"brew":   &brewManager{},
+		"go":     &goManager{},
+		"cargo":  &cargoManager{},
+	}
+}
+
+// PackageStatus is the state of a package of the manifest on this machine
+type PackageStatus struct {
+	Manager string
+	Package string
+
+	// the package manager isn't present on this machine
+	Unavailable bool
+
+	Installed bool
+}
+
+// String will return a description of the state of the package
+func (s PackageStatus) String() string {
+	switch {
+	case s.Unavailable:
+		return fmt.Sprintf("%s isn't available", s.Manager)
+	case s.Installed:
+		return "installed"
+	default:
+		return "missing"
+	}
+}
+
+// PackagesStatus will check which packages of the manifest `m` are installed
+// with `managers`. The packages are in the order of the manifest, grouped by
+// package manager.
+func PackagesStatus(m Manifest, managers map[string]PackageManager) ([]PackageStatus, error) {
+	names, err := m.Managers(managers)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []PackageStatus
+	for _, name := range names {
+		manager := managers[name]
+		available := manager.Available()
+
+		var installed map[string]bool
+		if available && len(m[name]) > 0 {
+			installed, err = manager.Installed(m[name])
+			if err != nil {
+				return nil, fmt.Errorf("not able to check the packages of %s (%s)", name, err)
+			}
+		}
+
+		for _, pkg := range m[name] {
+			statuses = append(statuses, PackageStatus{
+				Manager:     name,
+				Package:     pkg,
+				Unavailable: !available,
+				Installed:   installed[pkg],
+			})
+		}
+	}
+
+	return statuses, nil
+}
+
+// InstallPackages will install the missing packages of the manifest `m`
+// with `managers`, the progress is written to `w`. Package managers that
+// aren't available on this machine are skipped. A package manager that
+// fails doesn't stop the others. With `dryRun` it only reports what would
+// be installed.
+func InstallPackages(w io.Writer, m Manifest, managers map[string]PackageManager, dryRun bool) error {
+	statuses, err := PackagesStatus(m, managers)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	missing := make(map[string][]string)
+	for _, s := range statuses {
+		if s.Unavailable || s.Installed {
+			continue
+		}
+
+		if len(missing[s.Manager]) == 0 {
+			names = append(names, s.Manager)
+		}
+		missing[s.Manager] = append(missing[s.Manager], s.Package)
+	}
+
+	if len(names) == 0 {
+		FprintBody(w, "Every package is installed")
+		return nil
+	}
+
+	var failed []string
+	for _, name := range names {
+		list := strings.Join(missing[name], ", ")
+		if dryRun {
+			FprintBody(w, fmt.Sprintf("Would install with %s: %s", name, list))
+			continue
+		}
+
+		FprintBody(w, fmt.Sprintf("Installing with %s: %s", name, list))
+		err = managers[name].Install(missing[name])
+		if err != nil {
+			FprintBodyError(w, fmt.Sprintf("%s: %s", name, err))
+			failed = append(failed, name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("not able to install the packages of %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// runInteractive will run `cmd` with the input and output of dot, so the
+// user sees the progress and can answer the questions of the command
+func runInteractive(cmd *exec.Cmd) error {
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// available reports whether all `commands` can be found in the PATH
+func available(commands ...string) bool {
+	for _, command := range commands {
+		if _, err := exec.LookPath(command); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseFirstFields will return the first field of every line of `output`,
+// this is the name of the package for most package managers
+func parseFirstFields(output []byte) map[string]bool {
+	fields := make(map[string]bool)
+
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		if f := strings.Fields(scanner.Text()); len(f) > 0 {
+			fields[f[0]] = true
+		}
+	}
+
+	return fields
+}
+
+// filterInstalled will return which of `packages` are in `installed`
+func filterInstalled(packages []string, installed map[string]bool) map[string]bool {
+	result := make(map[string]bool)
+	for _, pkg := range packages {
+		result[pkg] = installed[pkg]
+	}
+
+	return result
+}
+
+type aptManager struct {
+	helper PrivilegeHelper
+}
+
+func (m *aptManager) Available() bool {
+	return available("apt-get", "dpkg-query")
+}
+
+func (m *aptManager) Installed(packages []string) (map[string]bool, error) {
+	// dpkg-query fails for unknown packages, but still reports the others
+	args := append([]string{"-W", "-f=${Package} ${db:Status-Status}\n"}, packages...)
+	output, _ := exec.Command("dpkg-query", args...).Output()
+	return filterInstalled(packages, parseDpkgQuery(output)), nil
+}
+
+// parseDpkgQuery will return the installed packages in the output of
+// dpkg-query
+func parseDpkgQuery(output []byte) map[string]bool {
+	installed := make(map[string]bool)
+
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) == 2 && f[1] == "installed" {
+			installed[f[0]] = true
+		}
+	}
+
+	return installed
+}
+
+func (m *aptManager) Install(packages []string) error {
+	args := append([]string{"install", "-y"}, packages...)
+	return runInteractive(m.helper.Command("apt-get", args...))
+}
+
+type dnfManager struct {
+	helper PrivilegeHelper
+}
+
+func (m *dnfManager) Available() bool {
+	return available("dnf", "rpm")
+}
+
+func (m *dnfManager) Installed(packages []string) (map[string]bool, error) {
+	// rpm reports the packages that aren't installed on stdout as well,
+	// these lines don't start with the name of the package
+	args := append([]string{"-q", "--qf", "%{NAME}\n"}, packages...)
+	output, _ := exec.Command("rpm", args...).Output()
+	return filterInstalled(packages, parseFirstFields(output)), nil
+}
+
+func (m *dnfManager) Install(packages []string) error {
+	args := append([]string{"install", "-y"}, packages...)
+	return runInteractive(m.helper.Command("dnf", args...))
+}
+
+type pacmanManager struct {
+	helper PrivilegeHelper
+}
+
+func (m *pacmanManager) Available() bool {
+	return available("pacman")
+}
+
+func (m *pacmanManager) Installed(packages []string) (map[string]bool, error) {
+	output, _ := exec.Command("pacman", append([]string{"-Q"}, packages...)...).Output()
+	return filterInstalled(packages, parseFirstFields(output)), nil
+}
+
+func (m *pacmanManager) Install(packages []string) error {
+	args := append([]string{"-S", "--needed", "--noconfirm"}, packages...)
+	return runInteractive(m.helper.Command("pacman", args...))
+}
+
+// brewManager doesn't need a privilege helper, brew refuses to run as root
+type brewManager struct{}
+
+func (m *brewManager) Available() bool {
+	return available("brew")
+}
+
+func (m *brewManager) Installed(packages []string) (map[string]bool, error) {
+	args := append([]string{"list", "--versions"}, packages...)
+	output, _ := exec.Command("brew", args...).Output()
+	listed := parseFirstFields(output)
+
+	// brew lists formulae of a tap, e.g. `user/tap/formula`, by their name
+	installed := make(map[string]bool)
+	for _, pkg := range packages {
+		installed[pkg] = listed[pkg] || listed[pkg[strings.LastIndex(pkg, "/")+1:]]
+	}
+
+	return installed, nil
+}
+
+func (m *brewManager) Install(packages []string) error {
+	return runInteractive(exec.Command("brew", append([]string{"install"}, packages...)...))
+}
+
+// goManager installs commands with `go install`, the packages are import
+// paths of commands with an optional version, e.g.
+// `golang.org/x/tools/gopls@latest`. A command is installed when its
+// binary is present in the bin folder of go.
+type goManager struct{}
+
+func (m *goManager) Available() bool {
+	return available("go")
+}
+
+func (m *goManager) Installed(packages []string) (map[string]bool, error) {
+	output, err := exec.Command("go", "env", "GOBIN", "GOPATH").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	bin, err := parseGoBin(output)
+	if err != nil {
+		return nil, err
+	}
+
+	installed := make(map[string]bool)
+	for _, pkg := range packages {
+		_, err := os.Stat(filepath.Join(bin, goBinaryName(pkg)))
+		installed[pkg] = err == nil
+	}
+
+	return installed, nil
+}
+
+// parseGoBin will return the folder `go install` writes binaries to from
+// the `output` of `go env GOBIN GOPATH`, the bin folder of the first folder
+// of GOPATH when GOBIN isn't set
+func parseGoBin(output []byte) (string, error) {
+	lines := strings.Split(string(output), "\n")
+	if bin := strings.TrimSpace(lines[0]); bin != "" {
+		return bin, nil
+	}
+
+	var gopath []string
+	if len(lines) > 1 {
+		gopath = filepath.SplitList(strings.TrimSpace(lines[1]))
+	}
+	if len(gopath) == 0 || gopath[0] == "" {
+		return "", fmt.Errorf("not able to find the bin folder of go, GOBIN and GOPATH are empty")
+	}
+
+	return filepath.Join(gopath[0], "bin"), nil
+}
+
+// majorVersion matches the major version suffix of a module path, e.g. v2
+var majorVersion = regexp.MustCompile(`^v[0-9]+$`)
+
+// goBinaryName will return the name of the binary that `go install`
+// creates for the package `pkg`, with the `.exe` suffix on Windows
+func goBinaryName(pkg string) string {
+	if i := strings.Index(pkg, "@"); i >= 0 {
+		pkg = pkg[:i]
+	}
+
+	elems := strings.Split(strings.Trim(pkg, "/"), "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && majorVersion.MatchString(name) {
+		name = elems[len(elems)-2]
+	}
+
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+
+	return name
+}
+
+func (m *goManager) Install(packages []string) error {
+	for _, pkg := range packages {
+		// outside of a module a version is required
+		if !strings.Contains(pkg, "@") {
+			pkg += "@latest"
+		}
+
+		err := runInteractive(exec.Command("go", "install", pkg))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type cargoManager struct{}
+
+func (m *cargoManager) Available() bool {
+	return available("cargo")
+}
+
+func (m *cargoManager) Installed(packages []string) (map[string]bool, error) {
+	output, err := exec.Command("cargo", "install", "--list").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	return filterInstalled(packages, parseCargoList(output)), nil
+}
+
+// parseCargoList will return the installed crates in the output of
+// `cargo install --list`, the crates are the lines that aren't indented,
+// followed by their binaries
+func parseCargoList(output []byte) map[string]bool {
+	installed := make(map[string]bool)
+
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+
+		if f := strings.Fields(line); len(f) > 0 {
+			installed[f[0]] = true
+		}
+	}
+
+	return installed
+}
+
+func (m *cargoManager) Install(packages []string) error {
+	return runInteractive(exec.Command("cargo", append([]string{"install"}, packages...)...))
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeManager is a package manager that only keeps track of the packages
+// in memory
+type fakeManager struct {
+	available bool
+	installed map[string]bool
+	installs  [][]string
+	err       error
+}
+
+func (m *fakeManager) Available() bool {
+	return m.available
+}
+
+func (m *fakeManager) Installed(packages []string) (map[string]bool, error) {
+	installed := make(map[string]bool)
+	for _, pkg := range packages {
+		installed[pkg] = m.installed[pkg]
+	}
+	return installed, nil
+}
+
+func (m *fakeManager) Install(packages []string) error {
+	m.installs = append(m.installs, packages)
+	if m.err != nil {
+		return m.err
+	}
+
+	for _, pkg := range packages {
+		m.installed[pkg] = true
+	}
+	return nil
+}
+
+func TestLoadManifest(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	if m, err := LoadManifest(c.Root()); err != nil || len(m) != 0 {
+		t.Errorf("expected an empty manifest without %s, got %v (%v)", PackagesFileName, m, err)
+	}
+
+	path := filepath.Join(c.Root(), PackagesFileName)
+	writeTestFile(t, path, `{"apt": ["tmux", "neovim"], "cargo": ["ripgrep"]}`)
+
+	m, err := LoadManifest(c.Root())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(m["apt"], []string{"tmux", "neovim"}) || len(m["cargo"]) != 1 {
+		t.Errorf("unexpected manifest %v", m)
+	}
+
+	names, err := m.Managers(NewPackageManagers(nil))
+	if err != nil || !reflect.DeepEqual(names, []string{"apt", "cargo"}) {
+		t.Errorf("expected the managers apt and cargo, got %v (%v)", names, err)
+	}
+
+	writeTestFile(t, path, `{"apt": "tmux"}`)
+	if _, err := LoadManifest(c.Root()); err == nil {
+		t.Error("expected an error for an invalid manifest")
+	}
+
+	m = Manifest{"npm": {"prettier"}}
+	if _, err := m.Managers(NewPackageManagers(nil)); err == nil {
+		t.Error("expected an error for an unknown package manager")
+	}
+}
+
+func TestPackagesStatus(t *testing.T) {
+	managers := map[string]PackageManager{
+		"apt":  &fakeManager{available: true, installed: map[string]bool{"tmux": true}},
+		"brew": &fakeManager{installed: map[string]bool{}},
+	}
+	m := Manifest{"brew": {"fzf"}, "apt": {"tmux", "neovim"}}
+
+	statuses, err := PackagesStatus(m, managers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []PackageStatus{
+		{Manager: "apt", Package: "tmux", Installed: true},
+		{Manager: "apt", Package: "neovim"},
+		{Manager: "brew", Package: "fzf", Unavailable: true},
+	}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected %+v, got %+v", expected, statuses)
+	}
+
+	for i, s := range []string{"installed", "missing", "brew isn't available"} {
+		if statuses[i].String() != s {
+			t.Errorf("expected '%s', got '%s'", s, statuses[i])
+		}
+	}
+}
+
+func TestInstallPackages(t *testing.T) {
+	apt := &fakeManager{available: true, installed: map[string]bool{"tmux": true}}
+	brew := &fakeManager{installed: map[string]bool{}}
+	cargo := &fakeManager{available: true, installed: map[string]bool{}, err: errors.New("exit status 101")}
+	managers := map[string]PackageManager{"apt": apt, "brew": brew, "cargo": cargo}
+	m := Manifest{"apt": {"tmux", "neovim", "git"}, "brew": {"fzf"}, "cargo": {"ripgrep"}}
+
+	var out bytes.Buffer
+	if err := InstallPackages(&out, m, managers, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(apt.installs) != 0 || len(cargo.installs) != 0 {
+		t.Error("expected nothing to be installed with dry-run")
+	}
+
+	if !strings.Contains(out.String(), "Would install with apt: neovim, git") {
+		t.Errorf("expected the missing packages in the output, got %s", out.String())
+	}
+
+	out.Reset()
+	err := InstallPackages(&out, m, managers, false)
+	if err == nil || !strings.Contains(err.Error(), "cargo") {
+		t.Errorf("expected an error for cargo, got %v", err)
+	}
+
+	if !reflect.DeepEqual(apt.installs, [][]string{{"neovim", "git"}}) {
+		t.Errorf("expected only the missing packages to be installed, got %v", apt.installs)
+	}
+
+	if len(brew.installs) != 0 {
+		t.Error("expected an unavailable package manager to be skipped")
+	}
+
+	if len(cargo.installs) != 1 {
+		t.Errorf("expected cargo to install ripgrep, got %v", cargo.installs)
+	}
+
+	cargo.err = nil
+	out.Reset()
+	if err := InstallPackages(&out, m, managers, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(apt.installs) != 1 || len(cargo.installs) != 2 {
+		t.Errorf("expected only cargo to install again, got %v and %v", apt.installs, cargo.installs)
+	}
+
+	out.Reset()
+	if err := InstallPackages(&out, m, managers, false); err != nil || !strings.Contains(out.String(), "Every package is installed") {
+		t.Errorf("expected every package to be installed, got %s (%v)", out.String(), err)
+	}
+}
+
+func TestParsePackageOutput(t *testing.T) {
+	dpkg := parseDpkgQuery([]byte("tmux installed\nneovim not-installed\ngit installed\n"))
+	if !reflect.DeepEqual(dpkg, map[string]bool{"tmux": true, "git": true}) {
+		t.Errorf("unexpected packages of dpkg-query: %v", dpkg)
+	}
+
+	fields := parseFirstFields([]byte("tmux 3.3a-1\n\nfzf 0.44.1\n"))
+	if !reflect.DeepEqual(fields, map[string]bool{"tmux": true, "fzf": true}) {
+		t.Errorf("unexpected packages: %v", fields)
+	}
+
+	cargo := parseCargoList([]byte("ripgrep v14.0.3:\n    rg\nfd-find v9.0.0:\n    fd\n"))
+	if !reflect.DeepEqual(cargo, map[string]bool{"ripgrep": true, "fd-find": true}) {
+		t.Errorf("unexpected crates of cargo: %v", cargo)
+	}
+
+	bins := map[string]string{
+		"/go/bin\n/root/go\n": "/go/bin",
+		"\n/a:/b\n":           "/a/bin",
+	}
+	for output, expected := range bins {
+		if bin, err := parseGoBin([]byte(output)); bin != expected || err != nil {
+			t.Errorf("expected the bin folder %s for %q, got %s (%v)", expected, output, bin, err)
+		}
+	}
+
+	for _, output := range []string{"", "\n", "\n\n"} {
+		if _, err := parseGoBin([]byte(output)); err == nil {
+			t.Errorf("expected an error for %q", output)
+		}
+	}
+
+	tests := map[string]string{
+		"golang.org/x/tools/gopls@latest":           "gopls",
+		"github.com/go-delve/delve/cmd/dlv@v1.21.0": "dlv",
+		"github.com/jesseduffield/lazygit":          "lazygit",
+		"github.com/google/go-jsonnet/cmd/jsonnet/": "jsonnet",
+		"example.com/tool/v2@latest":                "tool",
+	}
+	for pkg, expected := range tests {
+		if runtime.GOOS == "windows" {
+			expected += ".exe"
+		}
+		if name := goBinaryName(pkg); name != expected {
+			t.Errorf("expected the binary %s for %s, got %s", expected, pkg, name)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxNameLength is the maximum length of the name of an entry, most file
+// systems don't allow longer file names
+const maxNameLength = 255
+
+// EntryPaths are the absolute locations that belong to an entry
+type EntryPaths struct {
+	// original location of the file or folder on the system
+	Target string
+
+	// the copy in the files folder, `files/[name]/[base]`
+	Repo string
+
+	// the copy in the backup folder, `backup/[name]/[base]`
+	Backup string
+
+	// the folders of the entry in the files and backup folder,
+	// `files/[name]` and `backup/[name]`
+	RepoDir   string
+	BackupDir string
+}
+
+// ValidateName will return an error when `name` can't be used as the name
+// of an entry. The name becomes a folder inside the files and backup folder,
+// so it has to be a single, non-empty path element.
+func ValidateName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("the name of an entry can't be empty")
+	case name == "." || name == "..":
+		return fmt.Errorf("'%s' can't be used as the name of an entry", name)
+	case len(name) > maxNameLength:
+		return fmt.Errorf("the name '%s...' is longer than %d characters", name[:16], maxNameLength)
+	case strings.ContainsAny(name, `/\`):
+		return fmt.Errorf("the name '%s' can't contain a slash", name)
+	case !utf8.ValidString(name):
+		return fmt.Errorf("the name %q isn't valid UTF-8", name)
+	}
+
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("the name %q can't contain control characters", name)
+		}
+	}
+
+	return nil
+}
+
+// ResolvePaths will return the locations of the entry `name` with the path
+// `path`, the entry doesn't have to be tracked yet. The file or folder is
+// stored under its base name, e.g. `~/.config/nvim/` of the entry `nvim`
+// is stored in `files/nvim/nvim`.
+func (c *Config) ResolvePaths(name, path string) EntryPaths {
+	return c.LayerPaths("", name, path)
+}
+
+// LayerPaths is ResolvePaths for an entry in the repository of the layer
+// `layer`, see Entry.Layer.
+func (c *Config) LayerPaths(layer, name, path string) EntryPaths {
+	target := ExpandPath(path)
+	base := filepath.Base(target)
+	root := c.LayerRoot(layer)
+
+	p := EntryPaths{
+		Target:    target,
+		RepoDir:   filepath.Join(root, "files", name),
+		BackupDir: filepath.Join(root, "backup", name),
+	}
+	p.Repo = filepath.Join(p.RepoDir, base)
+	p.Backup = filepath.Join(p.BackupDir, base)
+
+	return p
+}
+
+// RemoveAll will remove `path` and everything it contains, just like
+// os.RemoveAll. It refuses to remove anything that isn't located inside the
+// files or backup folder of the repository or one of its layers, so a bad
+// name or path can never remove anything else. The symlinks in the parent
+// folders of `path` are resolved first, so a symlinked folder inside the
+// repository can't point the removal outside of it.
+func (c *Config) RemoveAll(path string) error {
+	real := filepath.Join(resolvePath(filepath.Dir(path)), filepath.Base(path))
+	for _, root := range c.Roots() {
+		for _, folder := range []string{"files", "backup"} {
+			dir := filepath.Join(root, folder)
+			if IsInsideDir(dir, path) && IsInsideDir(resolvePath(dir), real) {
+				return os.RemoveAll(path)
+			}
+		}
+	}
+
+	return fmt.Errorf("refusing to remove %s, it isn't located inside the "+
+		"files or backup folder of %s", path, c.Root())
+}
+
+// Paths will return the locations of the tracked entry `name`, which is
+// either an entry or a system entry. For a system entry the target is the
+// path it is installed to.
+func (c *Config) Paths(name string) EntryPaths {
+	if e, ok := c.System[name]; ok {
+		return c.ResolvePaths(name, e.Path)
+	}
+
+	e := c.Files[name]
+	return c.LayerPaths(e.layer, name, e.Path)
+}
+
+// Linked reports whether the target is symlinked to the copy in the files
+// folder.
+func (p EntryPaths) Linked() bool {
+	link, err := os.Readlink(p.Target)
+	return err == nil && link == p.Repo
+}
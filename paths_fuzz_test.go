@@ -0,0 +1,71 @@
+//go:build go1.18
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func FuzzValidateName(f *testing.F) {
+	for _, name := range []string{"vim", "..", "../..", "a/b", "", ".zshrc", "a\\b", "vim\x00"} {
+		f.Add(name)
+	}
+
+	c := &Config{DotPath: "/srv/dotfiles"}
+	files := filepath.Join(c.Root(), "files")
+
+	f.Fuzz(func(t *testing.T, name string) {
+		if ValidateName(name) != nil {
+			return
+		}
+
+		// a valid name is always a single folder inside the files folder
+		p := c.ResolvePaths(name, "/home/user/.vimrc")
+		if filepath.Dir(p.RepoDir) != files || filepath.Base(p.RepoDir) != name {
+			t.Errorf("%q: %s isn't a folder inside %s", name, p.RepoDir, files)
+		}
+
+		if !IsInsideDir(files, p.Repo) || !IsInsideDir(files, p.RepoDir) {
+			t.Errorf("%q: %s escapes %s", name, p.Repo, files)
+		}
+	})
+}
+
+func FuzzNewName(f *testing.F) {
+	for _, path := range []string{"/home/user/.vimrc", "/", "/home/user/..", "/home/user/.../", "a\x00"} {
+		f.Add(path)
+	}
+
+	c := &Config{Files: map[string]*Entry{"vimrc": {Path: "/home/user/.vimrc"}}}
+
+	f.Fuzz(func(t *testing.T, path string) {
+		name := c.NewName(path)
+		if err := ValidateName(name); err != nil {
+			t.Errorf("%q: derived an invalid name: %s", path, err)
+		}
+
+		if _, ok := c.Files[name]; ok {
+			t.Errorf("%q: derived a name that is already taken: %s", path, name)
+		}
+	})
+}
+
+func FuzzIsInsideDir(f *testing.F) {
+	for _, path := range []string{"/srv/dotfiles/files/vim", "/srv/dotfiles/files/../x", "/srv/dotfiles/filesx", "vim"} {
+		f.Add(path)
+	}
+
+	dir := "/srv/dotfiles/files"
+
+	f.Fuzz(func(t *testing.T, path string) {
+		if !IsInsideDir(dir, path) {
+			return
+		}
+
+		if !strings.HasPrefix(filepath.Clean(path), dir+string(filepath.Separator)) {
+			t.Errorf("%q is reported to be inside %s", path, dir)
+		}
+	})
+}
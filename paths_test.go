@@ -0,0 +1,267 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolvePaths(t *testing.T) {
+	c := &Config{DotPath: "/srv/dotfiles"}
+	home := HomeDir()
+
+	tests := []struct {
+		name     string
+		path     string
+		expected EntryPaths
+	}{
+		{"vimrc", "~/.vimrc", EntryPaths{
+			Target: filepath.Join(home, ".vimrc"),
+			Repo:   "/srv/dotfiles/files/vimrc/.vimrc",
+			Backup: "/srv/dotfiles/backup/vimrc/.vimrc",
+		}},
+		{"nvim", "~/.config/nvim", EntryPaths{
+			Target: filepath.Join(home, ".config", "nvim"),
+			Repo:   "/srv/dotfiles/files/nvim/nvim",
+			Backup: "/srv/dotfiles/backup/nvim/nvim",
+		}},
+		{"nvim", "~/.config/nvim/", EntryPaths{
+			Target: filepath.Join(home, ".config", "nvim"),
+			Repo:   "/srv/dotfiles/files/nvim/nvim",
+			Backup: "/srv/dotfiles/backup/nvim/nvim",
+		}},
+		{"init", "~/.config/nvim/lua/init.lua", EntryPaths{
+			Target: filepath.Join(home, ".config", "nvim", "lua", "init.lua"),
+			Repo:   "/srv/dotfiles/files/init/init.lua",
+			Backup: "/srv/dotfiles/backup/init/init.lua",
+		}},
+		{"hosts", "/etc/hosts", EntryPaths{
+			Target: "/etc/hosts",
+			Repo:   "/srv/dotfiles/files/hosts/hosts",
+			Backup: "/srv/dotfiles/backup/hosts/hosts",
+		}},
+		{"sysctl", "/etc/sysctl.d//", EntryPaths{
+			Target: "/etc/sysctl.d",
+			Repo:   "/srv/dotfiles/files/sysctl/sysctl.d",
+			Backup: "/srv/dotfiles/backup/sysctl/sysctl.d",
+		}},
+	}
+
+	for _, test := range tests {
+		test.expected.RepoDir = filepath.Join("/srv/dotfiles/files", test.name)
+		test.expected.BackupDir = filepath.Join("/srv/dotfiles/backup", test.name)
+
+		if p := c.ResolvePaths(test.name, test.path); p != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.path, test.expected, p)
+		}
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	valid := []string{"vim", "vimrc-2", ".zshrc", "Application Support", "nvim.lua", "..vim"}
+	for _, name := range valid {
+		if err := ValidateName(name); err != nil {
+			t.Errorf("%q: %s", name, err)
+		}
+	}
+
+	invalid := []string{
+		"", ".", "..", "../..", "a/b", "/etc", "a\\b", "vim\x00", "vim\n",
+		"\xff", strings.Repeat("a", maxNameLength+1),
+	}
+	for _, name := range invalid {
+		if err := ValidateName(name); err == nil {
+			t.Errorf("%q: expected an error", name)
+		}
+	}
+}
+
+func TestConfigRemoveAll(t *testing.T) {
+	c, tempDir := newTestPlanConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	home := filepath.Join(tempDir, "home")
+
+	refused := []string{
+		c.Root(),
+		filepath.Join(c.Root(), "files"),
+		filepath.Join(c.Root(), "backup", ".."),
+		filepath.Join(c.Root(), "files", "..", ".."),
+		filepath.Join(home, ".both"),
+		"files/linked",
+	}
+
+	for _, path := range refused {
+		if err := c.RemoveAll(path); err == nil {
+			t.Errorf("%s: expected the removal to be refused", path)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(home, ".both")); err != nil {
+		t.Errorf("expected %s to be left untouched", filepath.Join(home, ".both"))
+	}
+
+	// a symlinked folder inside the repository that points outside of it
+	link := filepath.Join(c.Root(), "files", "outside")
+	if err := os.Symlink(home, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.RemoveAll(filepath.Join(link, ".both")); err == nil {
+		t.Error("expected the removal through a symlinked folder to be refused")
+	}
+
+	if _, err := os.Stat(filepath.Join(home, ".both")); err != nil {
+		t.Errorf("expected %s to be left untouched", filepath.Join(home, ".both"))
+	}
+
+	// the symlink itself is inside the repository
+	if err := c.RemoveAll(link); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := os.Stat(home); err != nil {
+		t.Error("expected the folder the symlink points to to be left untouched")
+	}
+
+	if err := c.RemoveAll(filepath.Join(c.Root(), "files", "both")); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(c.Root(), "files", "both")); !os.IsNotExist(err) {
+		t.Error("expected the files of both to be removed")
+	}
+}
+
+func TestConfigInvalidName(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	for _, name := range []string{"../..", "a/b"} {
+		c.Files = map[string]*Entry{name: {Path: filepath.Join(tempDir, "home", ".vimrc")}}
+		if err := c.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := NewConfig(c.path); err == nil {
+			t.Errorf("%s: expected an error when loading the config", name)
+		}
+
+		// a config that wasn't loaded from a file is never synced
+		step := PlanEntry(c, name, c.Files[name])
+		if step.Action != ActionSkip {
+			t.Errorf("%s: expected to be skipped, got %s", name, step.Action)
+		}
+	}
+
+	c.Files = make(map[string]*Entry)
+	defer useTestConfig(t, c)()
+
+	if err := TrackFiles([]string{"../escape"}, []*Entry{{Path: filepath.Join(tempDir, "x")}}, false); err == nil {
+		t.Error("expected an error for an invalid name")
+	}
+}
+
+func TestConfigPaths(t *testing.T) {
+	c := &Config{
+		DotPath: "/srv/dotfiles",
+		Files: map[string]*Entry{
+			"nvim": {Path: "/home/user/.config/nvim/"},
+		},
+		System: map[string]*SystemEntry{
+			"sysctl": {Path: "/etc/sysctl.d/99-dot.conf"},
+		},
+	}
+
+	if p := c.Paths("nvim"); p.Target != "/home/user/.config/nvim" || p.Repo != "/srv/dotfiles/files/nvim/nvim" {
+		t.Errorf("unexpected paths for nvim: %+v", p)
+	}
+
+	if p := c.Paths("sysctl"); p.Target != "/etc/sysctl.d/99-dot.conf" || p.Repo != "/srv/dotfiles/files/sysctl/99-dot.conf" {
+		t.Errorf("unexpected paths for sysctl: %+v", p)
+	}
+}
+
+func TestEntryPathsLinked(t *testing.T) {
+	c, tempDir := newTestPlanConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	tests := map[string]bool{
+		"linked":  true,
+		"both":    false,
+		"missing": false,
+	}
+
+	for name, expected := range tests {
+		if linked := c.Paths(name).Linked(); linked != expected {
+			t.Errorf("%s: expected linked to be %t", name, expected)
+		}
+	}
+}
+
+// TestUntrackFilesNested makes sure the files of entries that aren't
+// located directly in a folder are found in the repository
+func TestUntrackFilesNested(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	home := filepath.Join(tempDir, "home")
+	for _, name := range []string{"nvim", "kitty"} {
+		target := filepath.Join(home, ".config", name)
+		repo := filepath.Join(c.Root(), "files", name, name)
+
+		writeTestFile(t, filepath.Join(repo, "config"), name)
+		os.MkdirAll(filepath.Dir(target), 0755)
+		os.Symlink(repo, target)
+
+		// the trailing slash mustn't matter
+		c.Files[name] = &Entry{Path: target + "/"}
+	}
+	defer useTestConfig(t, c)()
+
+	ask := Ask
+	defer func() { Ask = ask }()
+	Ask = func(question string) string { return "Y" }
+
+	err := UntrackFiles([]string{"nvim"}, UntrackOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = UntrackFiles([]string{"kitty"}, UntrackOptions{Mode: UntrackUnlink})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"nvim", "kitty"} {
+		path := filepath.Join(home, ".config", name)
+		if f, err := os.Lstat(path); err != nil || !f.IsDir() {
+			t.Errorf("%s: expected %s to be a folder (%v)", name, path, err)
+		}
+
+		content, _ := ioutil.ReadFile(filepath.Join(path, "config"))
+		if string(content) != name {
+			t.Errorf("%s: expected the files to be restored, got %q", name, content)
+		}
+	}
+
+	c, err = NewConfig(c.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := c.Files["nvim"]; ok {
+		t.Error("expected nvim to be removed from the config")
+	}
+
+	// kitty remains in the repository
+	if _, ok := c.Files["kitty"]; !ok {
+		t.Error("expected kitty to remain in the config")
+	}
+
+	if _, err := os.Stat(filepath.Join(c.Root(), "files", "kitty", "kitty", "config")); err != nil {
+		t.Errorf("expected kitty to remain in the repository: %s", err)
+	}
+}
@@ -0,0 +1,666 @@
+// plan.go will hold the planning and execution of a sync. Planning only
+// reads from disk, questions for the user are asked before anything is
+// executed, and the execution of independent entries happens concurrently.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Action is what has to happen with a tracked entry to sync it
+type Action int
+
+const (
+	// the entry is already symlinked, nothing to do
+	ActionNone Action = iota
+
+	// the entry isn't present on the system but it is in the repository,
+	// it only has to be symlinked
+	ActionLink
+
+	// the entry is present on the system and in the repository, the
+	// original will be moved to the backup folder before it is symlinked
+	ActionBackupAndLink
+
+	// the entry is present on the system but not in the repository, it will
+	// be moved into the repository before it is symlinked
+	ActionMoveAndLink
+
+	// the entry is present on the system and in the repository, the
+	// version in the repository is replaced by the one on the system, the
+	// version in the repository is moved to the backup folder
+	ActionAdoptAndLink
+
+	// the block of the entry is missing in the target or differs from the
+	// one in the repository, see blocks.go
+	ActionUpdateBlock
+
+	// the target has to be assembled from the fragments of the entry, see
+	// fragments.go
+	ActionAssemble
+
+	// keys of the entry are missing in the target or differ from the ones
+	// in the repository, see merge.go
+	ActionMerge
+
+	// the entry can't be synced, see Step.Reason
+	ActionSkip
+)
+
+// String will return a description of the action, used for dry runs
+func (a Action) String() string {
+	switch a {
+	case ActionNone:
+		return "already symlinked"
+	case ActionLink:
+		return "symlink"
+	case ActionBackupAndLink:
+		return "move to backup and symlink"
+	case ActionMoveAndLink:
+		return "move into repository and symlink"
+	case ActionAdoptAndLink:
+		return "replace repository version and symlink"
+	case ActionUpdateBlock:
+		return "update block"
+	case ActionAssemble:
+		return "assemble fragments"
+	case ActionMerge:
+		return "merge keys"
+	default:
+		return "skip"
+	}
+}
+
+// Step is the planned action for a single tracked entry
+type Step struct {
+	Name   string
+	Entry  *Entry
+	Action Action
+
+	// why the step is skipped
+	Reason string
+
+	// absolute paths of the original location, the copy in the files
+	// folder and the copy in the backup folder
+	Target string
+	Repo   string
+	Backup string
+
+	// the backup folder already holds a copy, it has to be removed first
+	BackupExists bool
+
+	// the target is a folder
+	IsDir bool
+
+	// the assembled target was changed since dot wrote it, see
+	// planFragments
+	Drifted bool
+
+	// key paths of a merged target that will be written, see planMerge
+	Keys []string
+
+	ignore *Ignore
+	config *Config
+}
+
+// Plan holds the steps to sync the tracked entries, in the order they have
+// to be executed
+type Plan struct {
+	Steps []*Step
+
+	// runs the hooks of the entries that change, no hooks run when it's
+	// nil
+	Hooks *HookRunner
+}
+
+// Ask will print `question` and return the answer of the user, it can be
+// replaced in tests.
+var Ask = func(question string) string {
+	PrintBody(question)
+
+	var input string
+	_, err := fmt.Scan(&input)
+	if err != nil {
+		return ""
+	}
+
+	return input
+}
+
+// PlanSync will create the plan for syncing all entries in `c`, it only
+// reads from disk. The steps are in the order of SortEntries. Entries
+// outside the home folder are skipped unless `allowSystem` is set.
+func PlanSync(c *Config, allowSystem bool) (*Plan, error) {
+	names, err := SortEntries(c)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{}
+	for _, name := range names {
+		step := PlanEntry(c, name, c.Files[name])
+
+		if err := CheckTarget(step.Target, allowSystem); err != nil {
+			step.Action = ActionSkip
+			step.Reason = err.Error()
+		}
+
+		plan.Steps = append(plan.Steps, step)
+	}
+
+	return plan, nil
+}
+
+// PlanEntry will determine what has to happen to sync the entry `e` with
+// the name `name`, it only reads from disk:
+//
+//  1. The target is a symlink, nothing has to happen.
+//
+//  2. The target isn't present but it is in the repository, this happens
+//     when you run dot on a new 'additional machine'. It only needs to be
+//     symlinked.
+//
+//  3. The target and the repository are both present, the original file is
+//     moved to the backup folder and then symlinked.
+//
+//  4. The target is present but not in the repository, this is a new entry.
+//     It will be moved to the files folder and then symlinked.
+func PlanEntry(c *Config, name string, e *Entry) *Step {
+	p := c.LayerPaths(e.layer, name, e.Path)
+
+	step := &Step{
+		Name:   name,
+		Entry:  e,
+		Target: p.Target,
+		Repo:   p.Repo,
+		Backup: p.Backup,
+		config: c,
+	}
+
+	if err := ValidateName(name); err != nil {
+		step.Action = ActionSkip
+		step.Reason = err.Error()
+		return step
+	}
+
+	ignore, err := c.Ignore(e)
+	if err != nil {
+		step.Action = ActionSkip
+		step.Reason = err.Error()
+		return step
+	}
+	step.ignore = ignore
+
+	switch e.Mode {
+	case EntryModeBlock:
+		planBlock(step)
+		return step
+	case EntryModeFragments:
+		planFragments(step)
+		return step
+	case EntryModeMerge:
+		planMerge(step)
+		return step
+	}
+
+	_, repoErr := os.Lstat(step.Repo)
+
+	f, err := os.Lstat(step.Target)
+	switch {
+	case os.IsNotExist(err) && repoErr != nil:
+		step.Action = ActionSkip
+		step.Reason = fmt.Sprintf("%s isn't present on the system, nor in the repository", step.Target)
+	case os.IsNotExist(err):
+		step.Action = ActionLink
+	case err != nil:
+		step.Action = ActionSkip
+		step.Reason = err.Error()
+	case f.Mode()&os.ModeSymlink != 0:
+		step.Action = ActionNone
+	case repoErr == nil:
+		step.Action = ActionBackupAndLink
+		step.IsDir = f.IsDir()
+		if _, err := os.Lstat(step.Backup); err == nil {
+			step.BackupExists = true
+		}
+	default:
+		step.Action = ActionMoveAndLink
+		step.IsDir = f.IsDir()
+	}
+
+	return step
+}
+
+// Adopt will make sure entries that are present on the system and in the
+// repository won't be moved to the backup folder, instead the version on
+// the system replaces the one in the repository. This is useful when the
+// symlink was replaced by a regular file, e.g. by an editor.
+func (p *Plan) Adopt() {
+	for _, step := range p.Steps {
+		if step.Action == ActionBackupAndLink {
+			step.Action = ActionAdoptAndLink
+		}
+	}
+}
+
+// Prompt will ask the user all the questions that are needed to execute the
+// plan, in the order of the steps. Steps the user declines are skipped.
+func (p *Plan) Prompt() {
+	linkAll := false
+
+	for _, step := range p.Steps {
+		switch {
+		case step.Action == ActionLink && !linkAll:
+			question := fmt.Sprintf("%s isn't present on the system, symlink it to its destination? [All/Y/N]", step.Target)
+			switch Ask(question) {
+			case "All":
+				linkAll = true
+			case "Y":
+			case "N":
+				step.Action = ActionSkip
+				step.Reason = "ignoring"
+			default:
+				step.Action = ActionSkip
+				step.Reason = "invalid input"
+			}
+		case step.Action == ActionBackupAndLink && step.BackupExists:
+			question := fmt.Sprintf("%s is already present, remove it? [Y/N]", step.Backup)
+			if Ask(question) != "Y" {
+				step.Action = ActionSkip
+				step.Reason = "ignoring"
+			}
+		case step.Action == ActionAdoptAndLink:
+			step.promptAdopt()
+		case step.Action == ActionAssemble && step.Drifted:
+			question := fmt.Sprintf("%s was changed since it was assembled, move it to the backup folder and assemble it again? [Y/N]", step.Target)
+			if Ask(question) != "Y" {
+				step.Action = ActionSkip
+				step.Reason = "ignoring"
+			}
+		}
+	}
+}
+
+// promptAdopt will show the differences between the version in the
+// repository and the one on the system, before asking whether the version
+// on the system should replace the one in the repository.
+func (s *Step) promptAdopt() {
+	root := s.config.Root()
+	if s.Entry != nil {
+		root = s.config.LayerRoot(s.Entry.layer)
+	}
+
+	repoLabel, err := filepath.Rel(root, s.Repo)
+	if err != nil {
+		repoLabel = s.Repo
+	}
+
+	differs, err := DiffTrees(os.Stdout, s.Repo, s.Target, repoLabel, ContractPath(s.Target))
+	if err != nil {
+		s.Action = ActionSkip
+		s.Reason = err.Error()
+		return
+	}
+
+	if !differs {
+		PrintBody(fmt.Sprintf("%s is the same as the version in the repository", s.Target))
+	}
+
+	question := fmt.Sprintf("Replace the version of %s in the repository with %s? [Y/N]", s.Name, s.Target)
+	if Ask(question) != "Y" {
+		s.Action = ActionSkip
+		s.Reason = "ignoring"
+		return
+	}
+
+	if s.BackupExists {
+		question := fmt.Sprintf("%s is already present, remove it? [Y/N]", s.Backup)
+		if Ask(question) != "Y" {
+			s.Action = ActionSkip
+			s.Reason = "ignoring"
+		}
+	}
+}
+
+// Print will print the plan without executing it.
+func (p *Plan) Print(w io.Writer) {
+	for _, step := range p.Steps {
+		if step.Action == ActionSkip {
+			FprintBodyError(w, fmt.Sprintf("%s: %s (%s)", step.Name, step.Action, step.Reason))
+			continue
+		}
+
+		FprintBody(w, fmt.Sprintf("%s: %s", step.Name, step.describe()))
+		if step.Drifted {
+			FprintBody(w, fmt.Sprintf("  %s was changed since it was assembled", step.Target))
+		}
+		if len(step.Keys) > 0 {
+			FprintBody(w, fmt.Sprintf("  keys: %s", strings.Join(step.Keys, ", ")))
+		}
+
+		if step.Changes() && p.Hooks != nil {
+			hooks := &HookRunner{DryRun: true}
+			hooks.Run(w, "pre_link", step.hooks().PreLink, nil)
+			hooks.Run(w, "post_link", step.hooks().PostLink, nil)
+		}
+	}
+}
+
+// Execute will execute the plan, running at most `jobs` steps concurrently.
+// Steps that depend on each other are executed one after the other, in the
+// order of the plan.
+// The output is written to `w` in the order of the steps. It returns the
+// number of steps that failed.
+func (p *Plan) Execute(w io.Writer, jobs int) int {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	outputs := make([]bytes.Buffer, len(p.Steps))
+	failed := make([]bool, len(p.Steps))
+	done := make([]chan struct{}, len(p.Steps))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	groups := make(chan []int)
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range groups {
+				for _, i := range group {
+					failed[i] = p.Steps[i].execute(&outputs[i], p.Hooks) != nil
+					close(done[i])
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for _, group := range p.groups() {
+			groups <- group
+		}
+		close(groups)
+	}()
+
+	// write the output in order, as soon as it is available
+	failures := 0
+	for i := range p.Steps {
+		<-done[i]
+		w.Write(outputs[i].Bytes())
+		if failed[i] {
+			failures++
+		}
+	}
+
+	wg.Wait()
+	return failures
+}
+
+// groups will divide the steps into groups of steps that depend on each
+// other, either because their targets are nested, e.g. `~/.config` and
+// `~/.config/nvim`, or because of the `after` setting of an entry. Groups
+// can be executed independently.
+func (p *Plan) groups() [][]int {
+	// union find over the indices of the steps
+	parent := make([]int, len(p.Steps))
+	for i := range parent {
+		parent[i] = i
+	}
+
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	index := make(map[string]int)
+	for i, step := range p.Steps {
+		index[step.Name] = i
+	}
+
+	for i, step := range p.Steps {
+		for j := i + 1; j < len(p.Steps); j++ {
+			if isNested(step.Target, p.Steps[j].Target) {
+				parent[find(j)] = find(i)
+			}
+		}
+
+		if step.Entry == nil {
+			continue
+		}
+
+		for _, dep := range step.Entry.After {
+			if j, ok := index[dep]; ok {
+				parent[find(i)] = find(j)
+			}
+		}
+	}
+
+	var groups [][]int
+	groupIndex := make(map[int]int)
+	for i := range p.Steps {
+		root := find(i)
+		if _, ok := groupIndex[root]; !ok {
+			groupIndex[root] = len(groups)
+			groups = append(groups, nil)
+		}
+		groups[groupIndex[root]] = append(groups[groupIndex[root]], i)
+	}
+
+	return groups
+}
+
+// isNested reports whether one of the absolute paths `a` and `b` is inside
+// the other, or whether they are the same.
+func isNested(a, b string) bool {
+	relPath, err := filepath.Rel(a, b)
+	if err == nil && !IsOutsideDir(relPath) {
+		return true
+	}
+
+	relPath, err = filepath.Rel(b, a)
+	return err == nil && !IsOutsideDir(relPath)
+}
+
+// execute will execute a single step, writing its output to `w`. The hooks
+// of the entry are run with `hooks`, when the entry changes.
+func (s *Step) execute(w io.Writer, hooks *HookRunner) error {
+	var err error
+
+	entryHooks := s.hooks()
+	if s.Changes() && hooks != nil {
+		err = hooks.Run(w, "pre_link", entryHooks.PreLink, s.hookEnv())
+		if err != nil {
+			FprintBodyError(w, fmt.Sprintf("%s: %s", s.Name, err))
+			if hooks.Stop() {
+				return err
+			}
+		}
+	}
+
+	switch s.Action {
+	case ActionNone:
+		FprintBody(w, fmt.Sprintf("%s is %s", s.Name, s.describe()))
+	case ActionSkip:
+		FprintBodyError(w, fmt.Sprintf("skipping %s: %s", s.Name, s.Reason))
+		return nil
+	case ActionLink:
+		FprintBody(w, fmt.Sprintf("Symlinking: %s", s.Name))
+		err = s.link()
+	case ActionBackupAndLink:
+		FprintBody(w, fmt.Sprintf("Symlinking: %s", s.Name))
+		err = s.backupAndLink()
+	case ActionMoveAndLink:
+		FprintBody(w, fmt.Sprintf("Symlinking: %s", s.Name))
+		err = s.moveAndLink()
+	case ActionAdoptAndLink:
+		FprintBody(w, fmt.Sprintf("Adopting: %s", s.Name))
+		err = s.adoptAndLink()
+	case ActionUpdateBlock:
+		FprintBody(w, fmt.Sprintf("Updating the block of: %s", s.Name))
+		err = s.updateBlock()
+	case ActionAssemble:
+		FprintBody(w, fmt.Sprintf("Assembling: %s", s.Name))
+		err = s.assembleFragments()
+	case ActionMerge:
+		FprintBody(w, fmt.Sprintf("Merging the keys of: %s", s.Name))
+		err = s.mergeKeys()
+	}
+
+	if err != nil {
+		FprintBodyError(w, fmt.Sprintf("%s: %s", s.Name, err))
+		return err
+	}
+
+	err = s.writeGitIgnore()
+	if err != nil {
+		FprintBodyError(w, fmt.Sprintf("not able to write .gitignore for %s (%s)", s.Name, err))
+	}
+
+	if s.Changes() && hooks != nil {
+		err = hooks.Run(w, "post_link", entryHooks.PostLink, s.hookEnv())
+		if err != nil {
+			FprintBodyError(w, fmt.Sprintf("%s: %s", s.Name, err))
+			if hooks.Stop() {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// describe will return a description of the action of the step
+func (s *Step) describe() string {
+	switch {
+	case s.Action != ActionNone || s.Entry == nil:
+	case s.Entry.Mode == EntryModeBlock || s.Entry.Mode == EntryModeFragments ||
+		s.Entry.Mode == EntryModeMerge:
+		return "up to date"
+	}
+
+	return s.Action.String()
+}
+
+// Changes reports whether executing the step changes the entry
+func (s *Step) Changes() bool {
+	return s.Action != ActionNone && s.Action != ActionSkip
+}
+
+// hooks will return the hooks of the entry, they are empty when the entry
+// doesn't have any
+func (s *Step) hooks() *EntryHooks {
+	if s.Entry == nil || s.Entry.Hooks == nil {
+		return &EntryHooks{}
+	}
+
+	return s.Entry.Hooks
+}
+
+// hookEnv will return the environment variables for the hooks of the entry
+func (s *Step) hookEnv() []string {
+	return entryHookEnv(s.Name, EntryPaths{Target: s.Target, Repo: s.Repo, Backup: s.Backup})
+}
+
+// Changed will return the names of the entries that change when the plan
+// is executed
+func (p *Plan) Changed() []string {
+	var names []string
+	for _, step := range p.Steps {
+		if step.Changes() {
+			names = append(names, step.Name)
+		}
+	}
+
+	return names
+}
+
+// link will create the symlink at the target that points to the repository
+func (s *Step) link() error {
+	err := makeParents(s.Repo, s.Target)
+	if err != nil {
+		return err
+	}
+
+	// create symlink (os.Symlink(oldname, newname))
+	return os.Symlink(s.Repo, s.Target)
+}
+
+func (s *Step) backupAndLink() error {
+	if s.BackupExists {
+		err := s.config.RemoveAll(s.Backup)
+		if err != nil {
+			return err
+		}
+	}
+
+	// put in backup folder, set named folder based on `name`, e.g.:
+	// `/home/jpbruinsslot/dotfiles/backup/[name]/[base]`
+	err := MakeAndMoveToDir(s.Target, s.Backup)
+	if err != nil {
+		return fmt.Errorf("not able to move files to %s (%s)", s.Backup, err)
+	}
+
+	return s.link()
+}
+
+func (s *Step) moveAndLink() error {
+	// put in files folder, set named folder based on `name`, e.g.:
+	// `/home/jpbruinsslot/dotfiles/files/[name]/[base]`
+	// ignored paths of a folder are moved as well, the application still
+	// finds them through the symlink, the .gitignore keeps them out of git
+	err := MakeAndMoveToDir(s.Target, s.Repo)
+	if err != nil {
+		return err
+	}
+
+	return s.link()
+}
+
+// adoptAndLink will move the version in the repository to the backup
+// folder and the version on the system into the repository. Ignored paths
+// of a folder end up in the repository as well, just like they do when the
+// folder is symlinked, the .gitignore keeps them out of git.
+func (s *Step) adoptAndLink() error {
+	if s.BackupExists {
+		err := s.config.RemoveAll(s.Backup)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := MakeAndMoveToDir(s.Repo, s.Backup)
+	if err != nil {
+		return fmt.Errorf("not able to move files to %s (%s)", s.Backup, err)
+	}
+
+	err = MakeAndMoveToDir(s.Target, s.Repo)
+	if err != nil {
+		return err
+	}
+
+	return s.link()
+}
+
+// writeGitIgnore will keep the .gitignore of a tracked folder in line with
+// its patterns, see WriteEntryGitIgnore.
+func (s *Step) writeGitIgnore() error {
+	f, err := os.Stat(s.Repo)
+	if err != nil || !f.IsDir() {
+		return nil
+	}
+
+	return WriteEntryGitIgnore(filepath.Dir(s.Repo), filepath.Base(s.Repo), s.ignore)
+}
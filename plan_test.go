@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestConfig will create a repository with the files and backup folders
+// in a temporary directory, and a config for it. Entries are placed
+// outside the home folder, so the tests never touch it.
+func newTestConfig(tb testing.TB) (*Config, string) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	root := filepath.Join(tempDir, "dotfiles")
+	os.Mkdir(root, 0755)
+	err = CreateDotFolders(root)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	c := &Config{
+		Version: ConfigVersion,
+		DotPath: root,
+		Files:   make(map[string]*Entry),
+		path:    filepath.Join(tempDir, ConfigFileName),
+	}
+
+	return c, tempDir
+}
+
+// writeTestFile will create the file `path`, including its parent folders
+func writeTestFile(tb testing.TB, path, content string) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// newTestPlanConfig will create a config with an entry for every action
+func newTestPlanConfig(t *testing.T) (*Config, string) {
+	c, tempDir := newTestConfig(t)
+	home := filepath.Join(tempDir, "home")
+
+	os.MkdirAll(home, 0755)
+
+	// already symlinked
+	writeTestFile(t, filepath.Join(c.Root(), "files", "linked", ".linked"), "linked")
+	os.Symlink(filepath.Join(c.Root(), "files", "linked", ".linked"), filepath.Join(home, ".linked"))
+
+	// only in the repository
+	writeTestFile(t, filepath.Join(c.Root(), "files", "missing", ".missing"), "missing")
+
+	// in the repository and on the system
+	writeTestFile(t, filepath.Join(c.Root(), "files", "both", ".both"), "repo")
+	writeTestFile(t, filepath.Join(home, ".both"), "local")
+
+	// only on the system
+	writeTestFile(t, filepath.Join(home, ".config", "new", "config"), "new")
+
+	// nowhere
+	for _, name := range []string{"linked", "missing", "both", "nowhere"} {
+		c.Files[name] = &Entry{Path: filepath.Join(home, "."+name)}
+	}
+	c.Files["new"] = &Entry{Path: filepath.Join(home, ".config", "new")}
+
+	return c, tempDir
+}
+
+func TestPlanSync(t *testing.T) {
+	c, tempDir := newTestPlanConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	plan, err := PlanSync(c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		name   string
+		action Action
+	}{
+		{"both", ActionBackupAndLink},
+		{"linked", ActionNone},
+		{"missing", ActionLink},
+		{"new", ActionMoveAndLink},
+		{"nowhere", ActionSkip},
+	}
+
+	if len(plan.Steps) != len(expected) {
+		t.Fatalf("expected %d steps, got %d", len(expected), len(plan.Steps))
+	}
+
+	for i, step := range plan.Steps {
+		if step.Name != expected[i].name || step.Action != expected[i].action {
+			t.Errorf("step %d: expected %s (%s), got %s (%s)", i,
+				expected[i].name, expected[i].action, step.Name, step.Action)
+		}
+	}
+
+	// planning shouldn't have changed anything
+	if _, err := os.Lstat(filepath.Join(tempDir, "home", ".missing")); err == nil {
+		t.Error("planning created a file")
+	}
+
+	// without allowSystem every entry outside the home folder is skipped
+	plan, err = PlanSync(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range plan.Steps {
+		if step.Action != ActionSkip {
+			t.Errorf("%s: expected to be skipped", step.Name)
+		}
+	}
+}
+
+func TestPlanPrompt(t *testing.T) {
+	c, tempDir := newTestPlanConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	// an existing backup requires confirmation
+	writeTestFile(t, filepath.Join(c.Root(), "backup", "both", ".both"), "old")
+
+	ask := Ask
+	defer func() { Ask = ask }()
+
+	var questions []string
+	Ask = func(question string) string {
+		questions = append(questions, question)
+		return "N"
+	}
+
+	plan, err := PlanSync(c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan.Prompt()
+
+	if len(questions) != 2 {
+		t.Errorf("expected 2 questions, got %v", questions)
+	}
+
+	for _, step := range plan.Steps {
+		if (step.Name == "both" || step.Name == "missing") && step.Action != ActionSkip {
+			t.Errorf("%s: expected to be skipped", step.Name)
+		}
+	}
+}
+
+func TestPlanExecute(t *testing.T) {
+	c, tempDir := newTestPlanConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	home := filepath.Join(tempDir, "home")
+
+	ask := Ask
+	defer func() { Ask = ask }()
+	Ask = func(question string) string { return "All" }
+
+	plan, err := PlanSync(c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan.Prompt()
+
+	var output bytes.Buffer
+	if failures := plan.Execute(&output, 4); failures != 0 {
+		t.Errorf("expected no failures, got %d:\n%s", failures, output.String())
+	}
+
+	links := map[string]string{
+		".linked":     filepath.Join(c.Root(), "files", "linked", ".linked"),
+		".missing":    filepath.Join(c.Root(), "files", "missing", ".missing"),
+		".both":       filepath.Join(c.Root(), "files", "both", ".both"),
+		".config/new": filepath.Join(c.Root(), "files", "new", "new"),
+	}
+
+	for name, expected := range links {
+		target, err := os.Readlink(filepath.Join(home, name))
+		if err != nil || target != expected {
+			t.Errorf("%s: expected symlink to %s, got %s (%v)", name, expected, target, err)
+		}
+	}
+
+	content, _ := ioutil.ReadFile(filepath.Join(c.Root(), "backup", "both", ".both"))
+	if string(content) != "local" {
+		t.Errorf("expected local file in backup, got %q", content)
+	}
+
+	content, _ = ioutil.ReadFile(filepath.Join(c.Root(), "files", "new", "new", "config"))
+	if string(content) != "new" {
+		t.Errorf("expected new file in repository, got %q", content)
+	}
+
+	// output should be in the order of the steps
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	expected := []string{
+		"... Symlinking: both",
+		"... linked is already symlinked",
+		"... Symlinking: missing",
+		"... Symlinking: new",
+		"... ERROR: skipping nowhere: " + plan.Steps[4].Reason,
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("unexpected output:\n%s", output.String())
+	}
+}
+
+// Test if the ignored paths of a new folder stay in the folder, which is
+// symlinked, and are kept out of git by the .gitignore
+func TestPlanExecuteIgnored(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	target := filepath.Join(tempDir, "home", "app")
+	writeTestFile(t, filepath.Join(target, "config"), "config")
+	writeTestFile(t, filepath.Join(target, "history"), "history")
+	c.Files["app"] = &Entry{Path: target, Exclude: []string{"history"}}
+
+	plan, err := PlanSync(c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures := plan.Execute(ioutil.Discard, 1); failures != 0 {
+		t.Fatalf("expected no failures, got %d", failures)
+	}
+
+	content, _ := ioutil.ReadFile(filepath.Join(target, "history"))
+	if string(content) != "history" {
+		t.Errorf("expected the ignored file to remain in the folder, got %q", content)
+	}
+
+	if _, err := os.Lstat(filepath.Join(c.Root(), "backup", "app")); !os.IsNotExist(err) {
+		t.Error("expected nothing to be moved to the backup folder")
+	}
+
+	gitignore, _ := ioutil.ReadFile(filepath.Join(c.Root(), "files", "app", ".gitignore"))
+	if !strings.Contains(string(gitignore), "history") {
+		t.Errorf("expected the ignored file in the .gitignore, got %q", gitignore)
+	}
+}
+
+func TestPlanAdopt(t *testing.T) {
+	c, tempDir := newTestPlanConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	home := filepath.Join(tempDir, "home")
+
+	ask := Ask
+	defer func() { Ask = ask }()
+
+	var questions []string
+	Ask = func(question string) string {
+		questions = append(questions, question)
+		return "Y"
+	}
+
+	plan, err := PlanSync(c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan.Adopt()
+
+	// only entries that are present on the system and in the repository
+	// are adopted
+	var steps []*Step
+	for _, step := range plan.Steps {
+		if step.Action == ActionAdoptAndLink {
+			steps = append(steps, step)
+		}
+	}
+
+	if len(steps) != 1 || steps[0].Name != "both" {
+		t.Fatalf("expected only both to be adopted, got %v", steps)
+	}
+
+	plan = &Plan{Steps: steps}
+	plan.Prompt()
+
+	if len(questions) != 1 || !strings.Contains(questions[0], "Replace the version of both") {
+		t.Errorf("expected to be asked to replace both, got %v", questions)
+	}
+
+	var output bytes.Buffer
+	if failures := plan.Execute(&output, 1); failures != 0 {
+		t.Fatalf("expected no failures, got %d:\n%s", failures, output.String())
+	}
+
+	repo := filepath.Join(c.Root(), "files", "both", ".both")
+	if target, err := os.Readlink(filepath.Join(home, ".both")); err != nil || target != repo {
+		t.Errorf("expected symlink to %s, got %s (%v)", repo, target, err)
+	}
+
+	content, _ := ioutil.ReadFile(repo)
+	if string(content) != "local" {
+		t.Errorf("expected local file in repository, got %q", content)
+	}
+
+	content, _ = ioutil.ReadFile(filepath.Join(c.Root(), "backup", "both", ".both"))
+	if string(content) != "repo" {
+		t.Errorf("expected repository file in backup, got %q", content)
+	}
+}
+
+func TestPlanGroups(t *testing.T) {
+	plan := &Plan{}
+	for _, target := range []string{
+		"/home/user/.config",
+		"/home/user/.config/nvim",
+		"/home/user/.configs",
+		"/home/user/.vimrc",
+		"/home/user/.config/nvim/lua",
+	} {
+		plan.Steps = append(plan.Steps, &Step{Target: target})
+	}
+
+	expected := [][]int{{0, 1, 4}, {2}, {3}}
+	if groups := plan.groups(); !reflect.DeepEqual(groups, expected) {
+		t.Errorf("expected %v, got %v", expected, groups)
+	}
+}
+
+// BenchmarkPlanExecute will sync a fresh machine with a number of folders
+// that have to be moved into the repository
+func BenchmarkPlanExecute(b *testing.B) {
+	for _, jobs := range []int{1, 8} {
+		b.Run(fmt.Sprintf("jobs=%d", jobs), func(b *testing.B) {
+			c, tempDir := newTestConfig(b)
+			defer os.RemoveAll(tempDir)
+
+			home := filepath.Join(tempDir, "home")
+			for i := 0; i < 150; i++ {
+				name := fmt.Sprintf("entry%03d", i)
+				for j := 0; j < 4; j++ {
+					file := fmt.Sprintf("file%d", j)
+					writeTestFile(b, filepath.Join(home, name, file), strings.Repeat("dot", 1000))
+				}
+				c.Files[name] = &Entry{
+					Path:    filepath.Join(home, name),
+					Exclude: []string{"*.swp"},
+				}
+			}
+
+			for n := 0; n < b.N; n++ {
+				b.StopTimer()
+				// move everything back to its original location
+				for name := range c.Files {
+					target := filepath.Join(home, name)
+					os.Remove(target)
+					os.Rename(filepath.Join(c.Root(), "files", name, name), target)
+				}
+				b.StartTimer()
+
+				plan, err := PlanSync(c, true)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if failures := plan.Execute(ioutil.Discard, jobs); failures != 0 {
+					b.Fatalf("%d failures", failures)
+				}
+			}
+		})
+	}
+}
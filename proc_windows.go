@@ -0,0 +1,12 @@
+package main
+
+import "os/exec"
+
+// setProcessGroup is a no-op, there are no process groups on Windows
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup will kill the started `cmd`, the processes it started
+// keep running
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// folder in the repository with the scripts that are run by sync
+	ScriptsFolder = "scripts"
+
+	// scripts of which the name starts with these prefixes are run once
+	// per machine, or every time their contents change
+	ScriptOncePrefix     = "run_once_"
+	ScriptOnChangePrefix = "run_onchange_"
+)
+
+// Script is a script in the scripts folder of the repository
+type Script struct {
+	// file name of the script
+	Name string
+
+	// absolute path of the script
+	Path string
+
+	// the script only runs once, otherwise it runs when its contents change
+	Once bool
+
+	// sha256 of the contents of the script
+	Hash string
+}
+
+// ScriptRun is the last successful run of a script on this machine
+type ScriptRun struct {
+	Hash  string    `json:"hash"`
+	RanAt time.Time `json:"ran_at"`
+}
+
+// ScriptState keeps track of the scripts that were run on this machine, it
+// is stored outside of the repository, see ScriptStatePath.
+type ScriptState struct {
+	// runs by the absolute path of the script
+	Scripts map[string]*ScriptRun `json:"scripts"`
+
+	// path of the file the state was loaded from
+	path string
+}
+
+// ScriptStatePath will return the location of the state of the scripts on
+// this machine, `$XDG_STATE_HOME/dot/scripts.json`, which defaults to
+// `~/.local/state/dot/scripts.json`.
+func ScriptStatePath() string {
+	return filepath.Join(StateDir(), "scripts.json")
+}
+
+// StateDir will return the folder with the state of dot on this machine,
+// `$XDG_STATE_HOME/dot`, which defaults to `~/.local/state/dot`.
+func StateDir() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" || !filepath.IsAbs(dir) {
+		dir = filepath.Join(HomeDir(), ".local", "state")
+	}
+
+	return filepath.Join(dir, "dot")
+}
+
+// LoadScriptState will load the state of the scripts from `path`, the state
+// is empty when the file doesn't exist yet.
+func LoadScriptState(path string) (*ScriptState, error) {
+	s := &ScriptState{Scripts: make(map[string]*ScriptRun), path: path}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, s)
+	if err != nil {
+		return nil, fmt.Errorf("not able to read %s (%s)", path, err)
+	}
+
+	if s.Scripts == nil {
+		s.Scripts = make(map[string]*ScriptRun)
+	}
+
+	return s, nil
+}
+
+// Save will write the state to the path it was loaded from
+func (s *ScriptState) Save() error {
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(s.path), 0755)
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(s.path, append(data, '\n'), 0644)
+}
+
+// Pending reports whether the script `script` has to run on this machine
+func (s *ScriptState) Pending(script *Script) bool {
+	run, ok := s.Scripts[script.Path]
+	if !ok {
+		return true
+	}
+
+	return !script.Once && run.Hash != script.Hash
+}
+
+// Status will return a description of the state of the script `script`
+func (s *ScriptState) Status(script *Script) string {
+	run, ok := s.Scripts[script.Path]
+	switch {
+	case !ok:
+		return "pending"
+	case s.Pending(script):
+		return fmt.Sprintf("changed since %s", run.RanAt.Format(time.RFC3339))
+	default:
+		return fmt.Sprintf("ran %s", run.RanAt.Format(time.RFC3339))
+	}
+}
+
+// FindScripts will return the scripts in the scripts folder of the
+// repository `root`, sorted by name. Files without one of the prefixes, and
+// folders, are skipped, so scripts can use helpers in the same folder.
+func FindScripts(root string) ([]*Script, error) {
+	dir := filepath.Join(root, ScriptsFolder)
+
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var scripts []*Script
+	for _, f := range files {
+		name := f.Name()
+		once := strings.HasPrefix(name, ScriptOncePrefix)
+		if f.IsDir() || (!once && !strings.HasPrefix(name, ScriptOnChangePrefix)) {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		hash := sha256.Sum256(data)
+		scripts = append(scripts, &Script{
+			Name: name,
+			Path: path,
+			Once: once,
+			Hash: hex.EncodeToString(hash[:]),
+		})
+	}
+
+	sort.Slice(scripts, func(i, j int) bool {
+		return scripts[i].Name < scripts[j].Name
+	})
+
+	return scripts, nil
+}
+
+// MatchScripts will return the scripts with the names `names`, an error is
+// returned for a name that isn't a script.
+func MatchScripts(scripts []*Script, names []string) ([]*Script, error) {
+	var matched []*Script
+	for _, name := range names {
+		found := false
+		for _, script := range scripts {
+			if script.Name == name {
+				matched = append(matched, script)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("'%s' isn't a script in the %s folder", name, ScriptsFolder)
+		}
+	}
+
+	return matched, nil
+}
+
+// RunScripts will run `scripts` in the folder of `hooks`. An executable
+// script is run directly, any other script with `sh`. Scripts run in the
+// foreground with the input and output of dot, without a time limit, as
+// they may ask for a password or install packages. A successful run is
+// recorded in `state`, which is saved after every run. A failing script
+// stops the remaining scripts, unless the failures of hooks are ignored. It
+// returns the number of scripts that failed.
+func RunScripts(w io.Writer, scripts []*Script, state *ScriptState, hooks *HookRunner) int {
+	failures := 0
+	for _, script := range scripts {
+		if hooks.DryRun {
+			FprintBody(w, fmt.Sprintf("Would run the script %s", script.Name))
+			continue
+		}
+
+		FprintBody(w, fmt.Sprintf("Running the script %s", script.Name))
+
+		cmd := exec.Command("sh", script.Path)
+		if f, err := os.Stat(script.Path); err == nil && f.Mode()&0111 != 0 {
+			cmd = exec.Command(script.Path)
+		}
+
+		cmd.Dir = hooks.Dir
+		cmd.Env = append(os.Environ(), "DOT_PATH="+hooks.Dir, "DOT_SCRIPT="+script.Name)
+		err := runInteractive(cmd)
+		if err != nil {
+			FprintBodyError(w, fmt.Sprintf("the script %s failed (%s)", script.Name, err))
+			failures++
+			if hooks.Stop() {
+				return failures
+			}
+			continue
+		}
+
+		state.Scripts[script.Path] = &ScriptRun{Hash: script.Hash, RanAt: time.Now()}
+		err = state.Save()
+		if err != nil {
+			FprintBodyError(w, fmt.Sprintf("not able to save the state of the scripts (%s)", err))
+			return failures + 1
+		}
+	}
+
+	return failures
+}
+
+// SyncScripts will run the scripts of the config `c` that are pending on
+// this machine. With `dryRun` it only reports which scripts would run.
+func SyncScripts(c *Config, dryRun bool) error {
+	scripts, err := FindScripts(c.Root())
+	if err != nil || len(scripts) == 0 {
+		return err
+	}
+
+	state, err := LoadScriptState(ScriptStatePath())
+	if err != nil {
+		return err
+	}
+
+	var pending []*Script
+	for _, script := range scripts {
+		if state.Pending(script) {
+			pending = append(pending, script)
+		}
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	hooks, err := NewHookRunner(c, dryRun)
+	if err != nil {
+		return err
+	}
+
+	PrintHeader("Running scripts ...")
+	if failures := RunScripts(os.Stdout, pending, state, hooks); failures > 0 {
+		return fmt.Errorf("%d of the %d scripts failed", failures, len(pending))
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindScripts(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	if scripts, err := FindScripts(c.Root()); err != nil || len(scripts) != 0 {
+		t.Errorf("expected no scripts without a scripts folder, got %v (%v)", scripts, err)
+	}
+
+	dir := filepath.Join(c.Root(), ScriptsFolder)
+	writeTestFile(t, filepath.Join(dir, "run_onchange_b.sh"), "echo b")
+	writeTestFile(t, filepath.Join(dir, "run_once_a.sh"), "echo a")
+	writeTestFile(t, filepath.Join(dir, "helpers.sh"), "")
+	writeTestFile(t, filepath.Join(dir, "run_once_folder", "script"), "")
+
+	scripts, err := FindScripts(c.Root())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(scripts) != 2 {
+		t.Fatalf("expected 2 scripts, got %v", scripts)
+	}
+
+	if scripts[0].Name != "run_once_a.sh" || !scripts[0].Once {
+		t.Errorf("expected run_once_a.sh to run once, got %+v", scripts[0])
+	}
+
+	if scripts[1].Name != "run_onchange_b.sh" || scripts[1].Once {
+		t.Errorf("expected run_onchange_b.sh to run on change, got %+v", scripts[1])
+	}
+
+	if scripts[0].Hash == scripts[1].Hash || len(scripts[0].Hash) != 64 {
+		t.Errorf("expected the hashes of the contents, got %s and %s", scripts[0].Hash, scripts[1].Hash)
+	}
+
+	if _, err := MatchScripts(scripts, []string{"helpers.sh"}); err == nil {
+		t.Error("expected an error for a file that isn't a script")
+	}
+}
+
+func TestRunScripts(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	dir := filepath.Join(c.Root(), ScriptsFolder)
+	log := filepath.Join(tempDir, "log")
+	writeTestFile(t, filepath.Join(dir, "run_once_a.sh"), `echo "a $DOT_SCRIPT" >> `+log)
+	writeTestFile(t, filepath.Join(dir, "run_onchange_b.sh"), `echo b >> `+log)
+
+	// executable scripts are run directly
+	executable := filepath.Join(dir, "run_onchange_c")
+	writeTestFile(t, executable, "#!/bin/sh\necho c >> "+log)
+	os.Chmod(executable, 0755)
+
+	state, err := LoadScriptState(filepath.Join(tempDir, "state", "dot", "scripts.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hooks, err := NewHookRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func() string {
+		os.Remove(log)
+
+		scripts, err := FindScripts(c.Root())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var pending []*Script
+		for _, script := range scripts {
+			if state.Pending(script) {
+				pending = append(pending, script)
+			}
+		}
+
+		var output bytes.Buffer
+		if failures := RunScripts(&output, pending, state, hooks); failures != 0 {
+			t.Fatalf("expected no failures, got %d:\n%s", failures, output.String())
+		}
+
+		content, _ := ioutil.ReadFile(log)
+		return string(content)
+	}
+
+	if output := run(); output != "a run_once_a.sh\nb\nc\n" {
+		t.Errorf("expected every script to run, got %q", output)
+	}
+
+	if output := run(); output != "" {
+		t.Errorf("expected no scripts to run, got %q", output)
+	}
+
+	// only the script that runs on change runs again
+	writeTestFile(t, filepath.Join(dir, "run_once_a.sh"), `echo "a changed" >> `+log)
+	writeTestFile(t, filepath.Join(dir, "run_onchange_b.sh"), `echo "b changed" >> `+log)
+	if output := run(); output != "b changed\n" {
+		t.Errorf("expected b to run again, got %q", output)
+	}
+
+	// the state is kept on disk
+	state, err = LoadScriptState(state.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(state.Scripts) != 3 {
+		t.Errorf("expected 3 scripts in the state, got %v", state.Scripts)
+	}
+}
+
+func TestRunScriptsFailure(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	dir := filepath.Join(c.Root(), ScriptsFolder)
+	writeTestFile(t, filepath.Join(dir, "run_once_a.sh"), "exit 1")
+	writeTestFile(t, filepath.Join(dir, "run_once_b.sh"), "true")
+
+	scripts, err := FindScripts(c.Root())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := LoadScriptState(filepath.Join(tempDir, "scripts.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hooks, err := NewHookRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a failing script stops the others
+	var output bytes.Buffer
+	if failures := RunScripts(&output, scripts, state, hooks); failures != 1 {
+		t.Errorf("expected 1 failure, got %d", failures)
+	}
+
+	if len(state.Scripts) != 0 {
+		t.Errorf("expected no scripts to be recorded, got %v", state.Scripts)
+	}
+
+	hooks.OnFailure = HookFailureContinue
+	RunScripts(&output, scripts, state, hooks)
+
+	if !state.Pending(scripts[0]) || state.Pending(scripts[1]) {
+		t.Errorf("expected only the failing script to be pending, got %v", state.Scripts)
+	}
+
+	if status := state.Status(scripts[1]); !strings.HasPrefix(status, "ran ") {
+		t.Errorf("expected run_once_b.sh to have run, got %s", status)
+	}
+}
+
+func TestSyncScripts(t *testing.T) {
+	c, tempDir := newTestConfig(t)
+	defer os.RemoveAll(tempDir)
+
+	os.Setenv("XDG_STATE_HOME", filepath.Join(tempDir, "state"))
+	defer os.Unsetenv("XDG_STATE_HOME")
+
+	log := filepath.Join(tempDir, "log")
+	writeTestFile(t, filepath.Join(c.Root(), ScriptsFolder, "run_once_a.sh"), "echo a >> "+log)
+
+	// a dry run doesn't run anything
+	if err := SyncScripts(c, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(log); !os.IsNotExist(err) {
+		t.Error("expected the script not to run")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := SyncScripts(c, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, _ := ioutil.ReadFile(log)
+	if string(content) != "a\n" {
+		t.Errorf("expected the script to run once, got %q", content)
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, "state", "dot", "scripts.json")); err != nil {
+		t.Errorf("expected the state outside the repository: %s", err)
+	}
+}
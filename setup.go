@@ -1,47 +1,71 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 )
 
 // SetupInitialMachine will setup a machine to be able to use dot it'll do the
 // following:
-// 1. Create a .dotconfig file
-// 2. Create the files and backup folders
+// 1. Create the files and backup folders in `dir`
+// 2. Create a .dotconfig file
 // 3. Add the .dotconfig for tracking
-func SetupInitialMachine(pathDotConfig string) {
-	// create .dotconfig file
-	err := CreateDotConfigFile(pathDotConfig)
+// 4. Optionally initialize a git repository in `dir`
+//
+// The .dotconfig file is removed again when it can't be tracked, so the
+// setup can be retried.
+func SetupInitialMachine(pathDotConfig string, dir string, git bool) error {
+	// create dot folders
+	err := CreateDotFolders(dir)
 	if err != nil {
-		PrintBodyError(err.Error())
-		return
+		return err
 	}
 
-	// create dot folders
-	err = CreateDotFolders()
+	// create .dotconfig file
+	err = CreateDotConfigFile(pathDotConfig, dir)
 	if err != nil {
-		PrintBodyError(err.Error())
-		return
+		return err
 	}
 
 	// add .dotconfig for tracking
-	_ = TrackFile("dotconfig", pathDotConfig, false, false)
+	err = TrackFile("dotconfig", &Entry{Path: pathDotConfig}, false)
+	if err != nil {
+		os.Remove(pathDotConfig)
+		return err
+	}
+
+	// initialize git repository
+	if git {
+		err = GitInit(dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-// CreateDotConfigFile will create a .dotconfig file in the specified path
-func CreateDotConfigFile(pathDotConfig string) error {
-	// remove HomeDir() from the currentWorkingDir to get relative path
-	// we need this relative path to put in the .dotconfig file
-	relPath, err := GetRelativePathFromCwd()
+// CreateDotConfigFile will create a .dotconfig file in the specified path,
+// `dir` is the folder that will hold the tracked files.
+func CreateDotConfigFile(pathDotConfig string, dir string) error {
+	// dot_path will be relative to the home folder when `dir` resides in it,
+	// otherwise it is stored as an absolute path
+	c := &Config{
+		Version: ConfigVersion,
+		DotPath: ContractPath(dir),
+		Files:   make(map[string]*Entry),
+	}
+
+	payload, err := json.MarshalIndent(c, "", "\t")
 	if err != nil {
 		return err
 	}
 
 	// create .dotconfig file
-	payload := fmt.Sprintf("{\"dot_path\": \"%s\", \"files\": {}}", relPath)
-	err = ioutil.WriteFile(pathDotConfig, []byte(payload), 0755)
+	err = ioutil.WriteFile(pathDotConfig, payload, 0755)
 	if err != nil {
 		return err
 	}
@@ -52,22 +76,18 @@ func CreateDotConfigFile(pathDotConfig string) error {
 	return nil
 }
 
-// CreateDotFolders() will create the files and backup folders
-func CreateDotFolders() error {
-	currentWorkingDir, err := os.Getwd()
-	if err != nil {
-		return err
-	}
-
+// CreateDotFolders() will create the files and backup folders in `dir`,
+// folders that are already present are left as they are
+func CreateDotFolders(dir string) error {
 	folders := [2]string{
-		fmt.Sprintf("%s/files", currentWorkingDir),
-		fmt.Sprintf("%s/backup", currentWorkingDir),
+		filepath.Join(dir, "files"),
+		filepath.Join(dir, "backup"),
 	}
 
 	for _, folder := range folders {
 		message := fmt.Sprintf("Creating folder: %s", folder)
 		PrintBody(message)
-		err = os.Mkdir(folder, 0755)
+		err := os.MkdirAll(folder, 0755)
 		if err != nil {
 			return err
 		}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
 )
 
@@ -15,28 +17,99 @@ func TestCreateDotConfigFile(t *testing.T) {
 
 	pathDotConfig := fmt.Sprintf("%s/.dotconfig", tempDir)
 
-	// set current working directory to home dir, this will
-	// make the GetRelativePathFromCwd work
-	os.Chdir(HomeDir())
+	// the repository folder resides in the home dir, so dot_path should
+	// be relative to the home dir
+	dir := filepath.Join(HomeDir(), "dotfiles")
 
 	// test creating .dotconfig file
-	err = CreateDotConfigFile(pathDotConfig)
+	err = CreateDotConfigFile(pathDotConfig, dir)
 	if err != nil {
 		t.Error(err)
 	}
+
+	c, err := NewConfig(pathDotConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.DotPath != "~/dotfiles" {
+		t.Errorf("c.DotPath doesn't match: %s", c.DotPath)
+	}
+
+	// the repository folder resides outside the home dir, so dot_path should
+	// be absolute
+	err = CreateDotConfigFile(pathDotConfig, "/srv/dotfiles")
+	if err != nil {
+		t.Error(err)
+	}
+
+	c, err = NewConfig(pathDotConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.DotPath != "/srv/dotfiles" {
+		t.Errorf("c.DotPath doesn't match: %s", c.DotPath)
+	}
 }
 
 func TestCreateDotFolders(t *testing.T) {
-	// change current working directory
 	tempDir, err := ioutil.TempDir("", "")
 	if err != nil {
 		t.Error(err)
 	}
-	os.Chdir(tempDir)
 
 	// create the folders
-	err = CreateDotFolders()
+	err = CreateDotFolders(tempDir)
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, folder := range []string{"files", "backup"} {
+		if _, err := os.Stat(filepath.Join(tempDir, folder)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// the folders are already present
+	err = CreateDotFolders(tempDir)
 	if err != nil {
 		t.Error(err)
 	}
 }
+
+func TestGitInit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// an existing .gitignore should be kept
+	pathGitIgnore := filepath.Join(tempDir, ".gitignore")
+	ioutil.WriteFile(pathGitIgnore, []byte("*.swp"), 0644)
+
+	// run it twice, backup/ should only be added once
+	for i := 0; i < 2; i++ {
+		err = GitInit(tempDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, ".git")); err != nil {
+		t.Error(err)
+	}
+
+	content, err := ioutil.ReadFile(pathGitIgnore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != "*.swp\nbackup/\n" {
+		t.Errorf(".gitignore doesn't match: %q", content)
+	}
+}
@@ -0,0 +1,71 @@
+// status.go will report the state of the tracked entries on this machine,
+// without changing anything. Of the merged entries it reports the keys that
+// drifted, see merge.go.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// StatusEntries will write the state of the entries `names`, or of every
+// entry when empty, to `w`: what `dot sync` would do with it, and for the
+// merged entries the keys of which the target has a different value than
+// the repository or that are missing in the target. Like `dot list`, the
+// layer of an entry is shown when there are layers, and the entries of
+// .dotconfig.local are marked local. It reports whether any entry isn't in
+// sync.
+func StatusEntries(w io.Writer, c *Config, names []string) (bool, error) {
+	if len(names) == 0 {
+		names = c.SortedNames()
+	}
+
+	for _, name := range names {
+		if _, ok := c.Files[name]; !ok {
+			return false, fmt.Errorf("'%s' is not being tracked. Get the list of tracked files with `dot list`", name)
+		}
+	}
+
+	layered := len(c.Layers) > 0
+	changes := false
+	for _, name := range names {
+		e := c.Files[name]
+
+		label := name
+		if layered || e.layer == LocalLayer {
+			label = fmt.Sprintf("%s (%s)", name, e.Layer())
+		}
+
+		step := PlanEntry(c, name, e)
+		if step.Action == ActionSkip {
+			FprintBodyError(w, fmt.Sprintf("%s: %s (%s)", label, step.Action, step.Reason))
+			changes = true
+			continue
+		}
+
+		FprintBody(w, fmt.Sprintf("%s: %s", label, step.describe()))
+		changes = changes || step.Changes()
+		if step.Drifted {
+			FprintBody(w, fmt.Sprintf("  %s was changed since it was assembled", step.Target))
+		}
+
+		if e.Mode != EntryModeMerge || step.Action != ActionMerge {
+			continue
+		}
+
+		drifted, missing, err := driftedKeys(c, name)
+		if err != nil {
+			return changes, err
+		}
+		if len(drifted) > 0 {
+			FprintBody(w, fmt.Sprintf("  drifted: %s", strings.Join(drifted, ", ")))
+		}
+		if len(missing) > 0 {
+			FprintBody(w, fmt.Sprintf("  missing: %s", strings.Join(missing, ", ")))
+		}
+	}
+
+	return changes, nil
+}
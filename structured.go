@@ -0,0 +1,1641 @@
+// structured.go will hold the parsers of the structured config files that
+// dot can merge keys into, see merge.go. The values of a document are
+// addressed by their key path, e.g. `editor.fontSize`, and are kept as the
+// text they have in the file. Only the lines that change are rewritten, so
+// the rest of the file keeps its layout and comments.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+	FormatINI  = "ini"
+)
+
+// Document is a parsed structured config file
+type Document interface {
+	// Leaves will return the values that aren't tables or mappings, in the
+	// order of the document. A key that is present more than once is only
+	// returned the first time.
+	Leaves() []Leaf
+
+	// Get will return the value at the key path `path`, it reports whether
+	// there is a value that isn't a table or mapping
+	Get(path []string) (string, bool)
+
+	// Set will set the value at the key path `path` to `value`, a value
+	// returned by a document of the same format. Missing tables are
+	// created, and a value in the way of the path is replaced.
+	Set(path []string, value string) error
+
+	String() string
+}
+
+// Leaf is a value of a Document with its key path
+type Leaf struct {
+	Path  []string
+	Value string
+}
+
+// Key will return the key path of the leaf, joined by dots
+func (l Leaf) Key() string {
+	return strings.Join(l.Path, ".")
+}
+
+// DetectFormat will return the format of the file at `path` based on its
+// extension, or an empty string when it's unknown
+func DetectFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".jsonc":
+		return FormatJSON
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	case ".ini", ".cfg", ".conf", ".gitconfig", ".editorconfig":
+		return FormatINI
+	default:
+		return ""
+	}
+}
+
+// validFormat reports whether `format` is a known format
+func validFormat(format string) bool {
+	switch format {
+	case FormatJSON, FormatYAML, FormatTOML, FormatINI:
+		return true
+	default:
+		return false
+	}
+}
+
+// ParseDocument will parse `content` in the format `format`, empty content
+// is an empty document
+func ParseDocument(format, content string) (Document, error) {
+	switch format {
+	case FormatJSON:
+		return parseJSONDocument(content)
+	case FormatYAML:
+		d := &yamlDocument{lines: blockLines(content)}
+		return d, d.parse()
+	case FormatTOML, FormatINI:
+		d := &sectionDocument{lines: blockLines(content), toml: format == FormatTOML}
+		return d, d.parse()
+	default:
+		return nil, fmt.Errorf("unknown format '%s'", format)
+	}
+}
+
+// MergeDocument will set the values of the document `ours` in the document
+// `target`, both in the format `format`. It returns the merged document and
+// the key paths of the values that were missing or differed in `target`.
+// When nothing differs `target` is returned as it is.
+func MergeDocument(format, ours, target string) (string, []string, error) {
+	o, err := ParseDocument(format, ours)
+	if err != nil {
+		return "", nil, err
+	}
+
+	t, err := ParseDocument(format, target)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var keys []string
+	for _, leaf := range o.Leaves() {
+		if value, ok := t.Get(leaf.Path); ok && value == leaf.Value {
+			continue
+		}
+
+		err = t.Set(leaf.Path, leaf.Value)
+		if err != nil {
+			return "", nil, err
+		}
+		keys = append(keys, leaf.Key())
+	}
+
+	if len(keys) == 0 {
+		return target, nil, nil
+	}
+
+	return t.String(), keys, nil
+}
+
+// CollectDocument will replace the values of the document `ours` with the
+// values in the document `target` that differ, both in the format
+// `format`. Values that are missing in `target` are kept. It returns the
+// updated document and the key paths of the values that were replaced.
+// When nothing differs `ours` is returned as it is.
+func CollectDocument(format, ours, target string) (string, []string, error) {
+	o, err := ParseDocument(format, ours)
+	if err != nil {
+		return "", nil, err
+	}
+
+	t, err := ParseDocument(format, target)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var keys []string
+	for _, leaf := range o.Leaves() {
+		value, ok := t.Get(leaf.Path)
+		if !ok || value == leaf.Value {
+			continue
+		}
+
+		err = o.Set(leaf.Path, value)
+		if err != nil {
+			return "", nil, err
+		}
+		keys = append(keys, leaf.Key())
+	}
+
+	if len(keys) == 0 {
+		return ours, nil, nil
+	}
+
+	return o.String(), keys, nil
+}
+
+// spliceLines will replace lines[start:end] of `dst` with `lines`, in
+// place when `dst` has the room for them
+func spliceLines(dst []string, start, end int, lines []string) []string {
+	n := len(dst) + len(lines) - (end - start)
+	if n > cap(dst) {
+		grown := make([]string, n, 2*n)
+		copy(grown, dst[:start])
+		copy(grown[start+len(lines):], dst[end:])
+		copy(grown[start:], lines)
+		return grown
+	}
+
+	tail := dst[end:]
+	dst = dst[:cap(dst)]
+	copy(dst[start+len(lines):], tail)
+	copy(dst[start:], lines)
+	return dst[:n]
+}
+
+// jsonDocument is a JSON document, comments and trailing commas are
+// allowed, as they are in e.g. the settings of VS Code. The values that are
+// set are written in the content itself, so the comments and the layout of
+// the rest of the document are kept.
+type jsonDocument struct {
+	content string
+	root    *jsonObject
+
+	// indentation of the document, it's used for the values that are set
+	indent string
+}
+
+// jsonObject is a JSON object that keeps the order of its keys, its values
+// are either objects or compacted JSON values
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+
+	// offsets in the content of the braces of the object, and of its
+	// members by key, the start of an object that isn't in the content is
+	// negative
+	start, end int
+	spans      map[string]*jsonSpan
+
+	// a key is present more than once, so the members aren't in the order
+	// of `keys` in the content
+	unordered bool
+}
+
+// jsonSpan is a member of a jsonObject, its key starts at content[key] and
+// its value is content[start:end]
+type jsonSpan struct {
+	key, start, end int
+}
+
+func newJSONObject() *jsonObject {
+	return &jsonObject{values: make(map[string]interface{}), start: -1, spans: make(map[string]*jsonSpan)}
+}
+
+// set will set the value of `key`, a new key is added at the end
+func (o *jsonObject) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func parseJSONDocument(content string) (*jsonDocument, error) {
+	d := &jsonDocument{content: content, indent: "  "}
+
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && trimmed != line {
+			d.indent = line[:len(line)-len(trimmed)]
+			break
+		}
+	}
+
+	return d, d.parse()
+}
+
+// parse will read the objects of the content of the document
+func (d *jsonDocument) parse() error {
+	p := &jsonParser{s: d.content}
+	p.skip()
+	if p.i == len(p.s) {
+		d.root = newJSONObject()
+		return nil
+	}
+
+	if p.s[p.i] != '{' {
+		return errors.New("the JSON value isn't an object")
+	}
+
+	root, err := p.object()
+	if err != nil {
+		return err
+	}
+
+	p.skip()
+	if p.i < len(p.s) {
+		return errors.New("unexpected data after the JSON object")
+	}
+
+	d.root = root
+	return nil
+}
+
+// jsonParser reads the JSON in `s` from the offset `i`
+type jsonParser struct {
+	s string
+	i int
+}
+
+// skip will move past the white space and the comments
+func (p *jsonParser) skip() {
+	for p.i < len(p.s) {
+		switch {
+		case strings.IndexByte(" \t\r\n", p.s[p.i]) >= 0:
+			p.i++
+		case strings.HasPrefix(p.s[p.i:], "//"):
+			end := strings.IndexByte(p.s[p.i:], '\n')
+			if end < 0 {
+				p.i = len(p.s)
+				return
+			}
+			p.i += end
+		case strings.HasPrefix(p.s[p.i:], "/*"):
+			end := strings.Index(p.s[p.i+2:], "*/")
+			if end < 0 {
+				p.i = len(p.s)
+				return
+			}
+			p.i += end + 4
+		default:
+			return
+		}
+	}
+}
+
+// object will read the object that starts at the offset of the parser
+func (p *jsonParser) object() (*jsonObject, error) {
+	o := newJSONObject()
+	o.start = p.i
+	p.i++
+
+	for {
+		p.skip()
+		if p.i == len(p.s) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if p.s[p.i] == '}' {
+			o.end = p.i
+			p.i++
+			return o, nil
+		}
+
+		n := len(o.keys)
+		err := p.member(o)
+		if err != nil {
+			return nil, err
+		}
+		if len(o.keys) == n {
+			o.unordered = true
+		}
+
+		p.skip()
+		if p.i < len(p.s) && p.s[p.i] == ',' {
+			p.i++
+		} else if p.i < len(p.s) && p.s[p.i] != '}' {
+			return nil, fmt.Errorf("expected ',' or '}' at offset %d", p.i)
+		}
+	}
+}
+
+// member will read the member of the object `o` that starts at the offset
+// of the parser
+func (p *jsonParser) member(o *jsonObject) error {
+	start := p.i
+	if p.s[p.i] != '"' {
+		return fmt.Errorf("expected a key at offset %d", p.i)
+	}
+	p.scalar()
+
+	var key string
+	err := json.Unmarshal([]byte(p.s[start:p.i]), &key)
+	if err != nil {
+		return err
+	}
+
+	p.skip()
+	if p.i == len(p.s) || p.s[p.i] != ':' {
+		return fmt.Errorf("expected ':' after the key %s", quoteJSON(key))
+	}
+	p.i++
+	p.skip()
+
+	span := &jsonSpan{key: start, start: p.i}
+	switch {
+	case p.i == len(p.s):
+		return io.ErrUnexpectedEOF
+	case p.s[p.i] == '{':
+		child, err := p.object()
+		if err != nil {
+			return err
+		}
+		o.set(key, child)
+	default:
+		if p.s[p.i] == '[' {
+			p.array()
+		} else {
+			p.scalar()
+		}
+
+		var compact bytes.Buffer
+		err := json.Compact(&compact, []byte(stripJSONComments(p.s[span.start:p.i])))
+		if err != nil {
+			return fmt.Errorf("invalid value of the key %s (%s)", quoteJSON(key), err)
+		}
+		o.set(key, compact.String())
+	}
+	span.end = p.i
+	o.spans[key] = span
+
+	return nil
+}
+
+// array will move past the array that starts at the offset of the parser
+func (p *jsonParser) array() {
+	depth := 0
+	for p.i < len(p.s) {
+		switch p.s[p.i] {
+		case '"':
+			p.scalar()
+			continue
+		case '/':
+			start := p.i
+			p.skip()
+			if p.i > start {
+				continue
+			}
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		}
+
+		p.i++
+		if depth == 0 {
+			return
+		}
+	}
+}
+
+// scalar will move past the string, number or literal that starts at the
+// offset of the parser
+func (p *jsonParser) scalar() {
+	if p.s[p.i] == '"' {
+		for p.i++; p.i < len(p.s) && p.s[p.i] != '"'; p.i++ {
+			if p.s[p.i] == '\\' {
+				p.i++
+			}
+		}
+		p.i++
+		if p.i > len(p.s) {
+			p.i = len(p.s)
+		}
+		return
+	}
+
+	for p.i < len(p.s) && strings.IndexByte(" \t\r\n,:[]{}/\"", p.s[p.i]) < 0 {
+		p.i++
+	}
+}
+
+// stripJSONComments will remove the comments and the trailing commas from
+// the JSON in `content`
+func stripJSONComments(content string) string {
+	var out []byte
+	inString := false
+	for i := 0; i < len(content); i++ {
+		ch := content[i]
+		switch {
+		case inString:
+			out = append(out, ch)
+			if ch == '\\' && i+1 < len(content) {
+				i++
+				out = append(out, content[i])
+			} else if ch == '"' {
+				inString = false
+			}
+		case ch == '"':
+			inString = true
+			out = append(out, ch)
+		case strings.HasPrefix(content[i:], "//"):
+			end := strings.IndexByte(content[i:], '\n')
+			if end < 0 {
+				return string(out)
+			}
+			// the line ending is kept
+			i += end - 1
+		case strings.HasPrefix(content[i:], "/*"):
+			end := strings.Index(content[i+2:], "*/")
+			if end < 0 {
+				return string(out)
+			}
+			i += end + 3
+		case ch == '}' || ch == ']':
+			j := len(out)
+			for j > 0 && strings.IndexByte(" \t\r\n", out[j-1]) >= 0 {
+				j--
+			}
+			if j > 0 && out[j-1] == ',' {
+				out = append(out[:j-1], out[j:]...)
+			}
+			out = append(out, ch)
+		default:
+			out = append(out, ch)
+		}
+	}
+
+	return string(out)
+}
+
+func (d *jsonDocument) Leaves() []Leaf {
+	var leaves []Leaf
+
+	var walk func(o *jsonObject, path []string)
+	walk = func(o *jsonObject, path []string) {
+		for _, key := range o.keys {
+			keyPath := append(append([]string{}, path...), key)
+			switch value := o.values[key].(type) {
+			case *jsonObject:
+				walk(value, keyPath)
+			case string:
+				leaves = append(leaves, Leaf{Path: keyPath, Value: value})
+			}
+		}
+	}
+	walk(d.root, nil)
+
+	return leaves
+}
+
+func (d *jsonDocument) Get(path []string) (string, bool) {
+	o := d.root
+	for i, key := range path {
+		switch value := o.values[key].(type) {
+		case *jsonObject:
+			o = value
+		case string:
+			return value, i == len(path)-1
+		default:
+			return "", false
+		}
+	}
+
+	return "", false
+}
+
+func (d *jsonDocument) Set(path []string, value string) error {
+	if len(path) == 0 {
+		return errors.New("empty key path")
+	}
+
+	// an empty document gets an empty object first, the comments are kept
+	if d.root.start < 0 {
+		if d.content != "" && !strings.HasSuffix(d.content, "\n") {
+			d.content += "\n"
+		}
+		d.content += "{}\n"
+		if err := d.parse(); err != nil {
+			return err
+		}
+	}
+
+	o := d.root
+	for i, key := range path {
+		if child, ok := o.values[key].(*jsonObject); ok && i < len(path)-1 {
+			o = child
+			continue
+		}
+
+		// the rest of the path is missing, or a value is in the way of it
+		inline := len(o.keys) > 0 && !strings.Contains(d.content[o.start:o.end], "\n")
+		var at int
+		if span, ok := o.spans[key]; ok {
+			rendered := d.render(path[i+1:], value, d.lineIndent(span.key), inline)
+			d.content = d.content[:span.start] + rendered + d.content[span.end:]
+			d.shift(d.root, span.end, len(rendered)-(span.end-span.start))
+			at = span.key
+		} else {
+			at = d.insert(o, key, path[i+1:], value, inline)
+		}
+
+		// only the member that changed is read again
+		p := &jsonParser{s: d.content, i: at}
+		if err := p.member(o); err != nil {
+			return d.parse()
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
+// shift will move the offsets in the object `o`, and in the objects in it,
+// from the offset `from` by `delta`. A value that ends at `from` stays as
+// it is, the change is after it.
+func (d *jsonDocument) shift(o *jsonObject, from, delta int) {
+	if o.start >= from {
+		o.start += delta
+	}
+	if o.end >= from {
+		o.end += delta
+	}
+
+	// the members that end before `from` don't move, when the members are
+	// in order the ones before them don't either
+	for i := len(o.keys) - 1; i >= 0; i-- {
+		span := o.spans[o.keys[i]]
+		if span.end <= from {
+			if o.unordered {
+				continue
+			}
+			break
+		}
+
+		if span.key >= from {
+			span.key += delta
+		}
+		if span.start >= from {
+			span.start += delta
+		}
+		span.end += delta
+
+		if child, ok := o.values[o.keys[i]].(*jsonObject); ok {
+			d.shift(child, from, delta)
+		}
+	}
+}
+
+// insert will add the member `key` with `value` at the key path `rest` at
+// the end of the object `o`, and return the offset of the key
+func (d *jsonDocument) insert(o *jsonObject, key string, rest []string, value string, inline bool) int {
+	// the start of the first member and the end of the value of the last
+	// one
+	first, last := len(d.content), -1
+	switch {
+	case o.unordered:
+		for _, span := range o.spans {
+			if span.end > last {
+				last = span.end
+			}
+			if span.key < first {
+				first = span.key
+			}
+		}
+	case len(o.keys) > 0:
+		first, last = o.spans[o.keys[0]].key, o.spans[o.keys[len(o.keys)-1]].end
+	}
+
+	if inline {
+		member := ", " + quoteJSON(key) + ": " + d.render(rest, value, "", true)
+		d.content = d.content[:last] + member + d.content[last:]
+		d.shift(d.root, last, len(member))
+		return last + len(", ")
+	}
+
+	indent := d.lineIndent(o.start) + d.indent
+	if last >= 0 {
+		if prefix := d.content[strings.LastIndex(d.content[:first], "\n")+1 : first]; strings.TrimLeft(prefix, " \t") == "" {
+			indent = prefix
+		}
+	}
+
+	// the member is added after the last line of the object that isn't
+	// empty, a comma is added to the last member when it doesn't have one
+	end := o.end
+	for end > o.start+1 && strings.IndexByte(" \t\r\n", d.content[end-1]) >= 0 {
+		end--
+	}
+
+	comma := ""
+	if last < 0 {
+		last = end
+	} else if !strings.Contains(stripJSONComments(d.content[last:o.end]), ",") {
+		comma = ","
+	}
+
+	member := "\n" + indent + quoteJSON(key) + ": " + d.render(rest, value, indent, false)
+	if !strings.Contains(d.content[end:o.end], "\n") {
+		member += "\n" + d.lineIndent(o.end)
+	}
+
+	d.content = d.content[:last] + comma + d.content[last:end] + member + d.content[end:]
+	d.shift(d.root, end, len(member))
+	d.shift(d.root, last, len(comma))
+	return end + len(comma) + len("\n") + len(indent)
+}
+
+// render will return `value` as JSON, nested in new objects with the keys
+// `rest`. Its lines are indented with `prefix`, or it's written on a single
+// line when `inline` is set.
+func (d *jsonDocument) render(rest []string, value, prefix string, inline bool) string {
+	var b bytes.Buffer
+	if len(rest) == 0 {
+		json.Indent(&b, []byte(value), prefix, d.indent)
+	} else {
+		root := newJSONObject()
+		o := root
+		for _, key := range rest[:len(rest)-1] {
+			child := newJSONObject()
+			o.set(key, child)
+			o = child
+		}
+		o.set(rest[len(rest)-1], value)
+		d.write(&b, root, prefix)
+	}
+
+	if inline {
+		var compact bytes.Buffer
+		json.Compact(&compact, b.Bytes())
+		return compact.String()
+	}
+
+	return b.String()
+}
+
+// lineIndent will return the indentation of the line of the content with
+// the offset `offset`
+func (d *jsonDocument) lineIndent(offset int) string {
+	line := d.content[strings.LastIndex(d.content[:offset], "\n")+1:]
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+func (d *jsonDocument) String() string {
+	return d.content
+}
+
+// write will write the object `o` to `b`, its lines are indented with
+// `prefix`
+func (d *jsonDocument) write(b *bytes.Buffer, o *jsonObject, prefix string) {
+	if len(o.keys) == 0 {
+		b.WriteString("{}")
+		return
+	}
+
+	b.WriteString("{\n")
+	for i, key := range o.keys {
+		b.WriteString(prefix + d.indent)
+		b.WriteString(quoteJSON(key))
+		b.WriteString(": ")
+
+		switch value := o.values[key].(type) {
+		case *jsonObject:
+			d.write(b, value, prefix+d.indent)
+		case string:
+			json.Indent(b, []byte(value), prefix+d.indent, d.indent)
+		}
+
+		if i < len(o.keys)-1 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(prefix + "}")
+}
+
+// quoteJSON will return `s` as a JSON string, without escaping HTML
+func quoteJSON(s string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// sectionDocument is a TOML or INI document, e.g. `.gitconfig`. The key
+// path of a value is the name of its section, as it's written between the
+// brackets, and its key. Values before the first section only have a key.
+type sectionDocument struct {
+	lines []string
+	toml  bool
+
+	// the keys and the sections of the document, in the order of the
+	// document, and the first key of every name, see sectionDocument.name
+	keys     []*sectionKey
+	sections []*sectionSpan
+	index    map[string]*sectionKey
+}
+
+// sectionKey is a value of a sectionDocument, it spans lines[line:end]
+type sectionKey struct {
+	section string
+	key     string
+	name    string
+	value   string
+	line    int
+	end     int
+
+	// the INI value ends with a backslash on the last line of the document
+	open bool
+}
+
+// sectionSpan is a section of a sectionDocument, the keys before the first
+// section are in a section without a name or a header
+type sectionSpan struct {
+	name   string
+	header int
+
+	// the name as a TOML key, see tomlKey
+	table string
+}
+
+// comment reports whether the trimmed line `line` is a comment
+func (d *sectionDocument) comment(line string) bool {
+	return strings.HasPrefix(line, "#") || (!d.toml && strings.HasPrefix(line, ";"))
+}
+
+// parse will read the keys and the sections of the document
+func (d *sectionDocument) parse() error {
+	keys, sections, err := d.scan(0, len(d.lines), "")
+	if err != nil {
+		return err
+	}
+
+	d.keys = keys
+	d.sections = append([]*sectionSpan{{header: -1}}, sections...)
+	d.index = make(map[string]*sectionKey)
+	for _, k := range d.keys {
+		if _, ok := d.index[k.name]; !ok {
+			d.index[k.name] = k
+		}
+	}
+	return nil
+}
+
+// scan will read the keys and the sections that start in lines[from:to],
+// the lines start in the section `section`. The value of the last key can
+// continue after `to`.
+func (d *sectionDocument) scan(from, to int, section string) ([]*sectionKey, []*sectionSpan, error) {
+	var keys []*sectionKey
+	var sections []*sectionSpan
+
+	for i := from; i < to; i++ {
+		line := strings.TrimSpace(d.lines[i])
+		if line == "" || d.comment(line) {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			end := strings.LastIndex(d.stripComment(line), "]")
+			if end < 0 {
+				return nil, nil, fmt.Errorf("line %d: the section doesn't end with ']'", i+1)
+			}
+
+			section = strings.TrimSpace(line[1:end])
+			if d.toml && !tomlValidKey(section) {
+				return nil, nil, fmt.Errorf("line %d: the table name has an empty key", i+1)
+			}
+			sections = append(sections, &sectionSpan{name: section, header: i, table: tomlKey(section)})
+			continue
+		}
+
+		k := &sectionKey{section: section, line: i}
+		if eq := strings.Index(line, "="); eq >= 0 {
+			k.key = strings.TrimSpace(line[:eq])
+			k.value = d.stripComment(strings.TrimSpace(line[eq+1:]))
+		} else if !d.toml {
+			// a key without a value
+			k.key = d.stripComment(line)
+		}
+		if k.key == "" || (d.toml && (k.value == "" || !tomlValidKey(k.key))) {
+			return nil, nil, fmt.Errorf("line %d isn't a key with a value", i+1)
+		}
+		k.name = d.name(k.section, k.key)
+
+		// values that continue on the next lines are kept as they are, the
+		// lines of a multi-line string are part of the string
+		k.end = i + 1
+		for k.end < len(d.lines) && d.continues(k.value) {
+			k.value += "\n" + d.lines[k.end]
+			k.end++
+		}
+
+		// an INI value continues on the empty line after the end of the
+		// document, so it's the same as when it's followed by an empty line
+		if d.continues(k.value) {
+			if d.toml {
+				return nil, nil, fmt.Errorf("line %d: the value doesn't end", i+1)
+			}
+			k.value += "\n"
+			k.open = true
+		}
+
+		keys = append(keys, k)
+		i = k.end - 1
+	}
+
+	return keys, sections, nil
+}
+
+// end will return the line after the last key of the section
+// sections[i], or after its header when it doesn't have any keys
+func (d *sectionDocument) end(i int) int {
+	next := len(d.lines)
+	if i+1 < len(d.sections) {
+		next = d.sections[i+1].header
+	}
+
+	k := sort.Search(len(d.keys), func(j int) bool { return d.keys[j].line >= next }) - 1
+	if k >= 0 && d.keys[k].line > d.sections[i].header {
+		return d.keys[k].end
+	}
+
+	return d.sections[i].header + 1
+}
+
+// continues reports whether the value `value` continues on the next line,
+// a TOML array, inline table or multi-line string that isn't closed yet, or
+// an INI value that ends with a backslash
+func (d *sectionDocument) continues(value string) bool {
+	if !d.toml {
+		return strings.HasSuffix(value, "\\")
+	}
+
+	depth := 0
+	for i := 0; i < len(value); i++ {
+		switch {
+		case strings.HasPrefix(value[i:], `"""`) || strings.HasPrefix(value[i:], "'''"):
+			end := strings.Index(value[i+3:], value[i:i+3])
+			if end < 0 {
+				return true
+			}
+			i += end + 5
+		case value[i] == '"':
+			for i++; i < len(value) && value[i] != '"'; i++ {
+				if value[i] == '\\' {
+					i++
+				}
+			}
+		case value[i] == '\'':
+			for i++; i < len(value) && value[i] != '\''; i++ {
+			}
+		case value[i] == '#':
+			for i < len(value) && value[i] != '\n' {
+				i++
+			}
+		case value[i] == '[' || value[i] == '{':
+			depth++
+		case value[i] == ']' || value[i] == '}':
+			depth--
+		}
+	}
+
+	return depth > 0
+}
+
+// stripComment will remove the comment at the end of `line`, comments in
+// quoted strings are left as they are
+func (d *sectionDocument) stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' && quote == '"' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || (d.toml && ch == '\''):
+			quote = ch
+		case ch == '#' || (!d.toml && ch == ';'):
+			return strings.TrimSpace(line[:i])
+		}
+	}
+
+	return line
+}
+
+// name will return the name of the key `key` in the section `section`, the
+// keys with the same name are the same key. In TOML a dotted key is the
+// same as the key in the table it starts with, e.g. `a.b.c` at the root
+// and `c` in `[a.b]`.
+func (d *sectionDocument) name(section, key string) string {
+	if !d.toml {
+		return section + "\n" + key
+	}
+
+	return tomlKey(section, key)
+}
+
+// tomlKey will join the dotted keys `keys`, without the spaces around the
+// dots
+func tomlKey(keys ...string) string {
+	var parts []string
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		for _, part := range strings.Split(key, ".") {
+			parts = append(parts, strings.TrimSpace(part))
+		}
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// tomlValidKey reports whether none of the dotted keys of `key` is empty
+func tomlValidKey(key string) bool {
+	return !strings.Contains("."+tomlKey(key)+".", "..")
+}
+
+// split will return the section and the key of the key path `path`
+func (d *sectionDocument) split(path []string) (string, string, error) {
+	switch len(path) {
+	case 1:
+		return "", path[0], nil
+	case 2:
+		return path[0], path[1], nil
+	default:
+		return "", "", fmt.Errorf("the key path '%s' isn't a section and a key", strings.Join(path, "."))
+	}
+}
+
+func (d *sectionDocument) Leaves() []Leaf {
+	var leaves []Leaf
+	for _, k := range d.keys {
+		if d.index[k.name] != k {
+			continue
+		}
+
+		path := []string{k.key}
+		if k.section != "" {
+			path = []string{k.section, k.key}
+		}
+		leaves = append(leaves, Leaf{Path: path, Value: k.value})
+	}
+
+	return leaves
+}
+
+func (d *sectionDocument) Get(path []string) (string, bool) {
+	section, key, err := d.split(path)
+	if err != nil {
+		return "", false
+	}
+
+	k, ok := d.index[d.name(section, key)]
+	if !ok {
+		return "", false
+	}
+
+	return k.value, true
+}
+
+func (d *sectionDocument) Set(path []string, value string) error {
+	section, key, err := d.split(path)
+	if err != nil {
+		return err
+	}
+
+	if k, ok := d.index[d.name(section, key)]; ok {
+		indent := d.lines[k.line][:len(d.lines[k.line])-len(strings.TrimLeft(d.lines[k.line], " \t"))]
+		return d.splice(k.line, k.end, d.render(indent, k.key, value))
+	}
+
+	// in TOML a dotted key is added to the table with the longest name it
+	// starts with, e.g. `a.b.c` to `[a.b]` as `c`
+	if d.toml {
+		full, table := tomlKey(section, key), ""
+		for _, s := range d.sections {
+			if s.header >= 0 && len(s.table) > len(table) && strings.HasPrefix(full, s.table+".") {
+				section, key, table = s.name, full[len(s.table)+1:], s.table
+			}
+		}
+	}
+
+	// the keys before the first section aren't indented
+	indent := ""
+	if section != "" {
+		indent = d.indent()
+	}
+
+	for i, s := range d.sections {
+		if s.name == section && (section != "" || s.header < 0) {
+			end := d.end(i)
+			return d.splice(end, end, d.render(indent, key, value))
+		}
+	}
+
+	var lines []string
+	if len(d.lines) > 0 && strings.TrimSpace(d.lines[len(d.lines)-1]) != "" {
+		lines = append(lines, "")
+	}
+	lines = append(lines, "["+section+"]")
+	return d.splice(len(d.lines), len(d.lines), append(lines, d.render(indent, key, value)...))
+}
+
+// indent will return the indentation of the first key in a section, e.g.
+// git indents its keys with a tab
+func (d *sectionDocument) indent() string {
+	for _, k := range d.keys {
+		if k.section != "" {
+			line := d.lines[k.line]
+			return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		}
+	}
+
+	return ""
+}
+
+// render will return the lines of the key `key` with the value `value`,
+// the lines a value continues on are written as they are
+func (d *sectionDocument) render(indent, key, value string) []string {
+	lines := strings.Split(value, "\n")
+	lines[0] = fmt.Sprintf("%s%s = %s", indent, key, lines[0])
+	return lines
+}
+
+// splice will replace lines[start:end] of the document with `lines`. Only
+// the keys and the sections in `lines` are read, the ones after them are
+// moved, unless the lines change how the rest of the document is read.
+func (d *sectionDocument) splice(start, end int, lines []string) error {
+	first := sort.Search(len(d.keys), func(i int) bool { return d.keys[i].line >= start })
+	last := sort.Search(len(d.keys), func(i int) bool { return d.keys[i].line >= end })
+
+	// the value before would continue on the lines, an empty line ends it
+	from := start
+	if first > 0 && d.keys[first-1].open && d.keys[first-1].end == start {
+		if len(lines) == 0 || lines[0] != "" {
+			lines = append([]string{""}, lines...)
+		}
+		d.keys[first-1].end++
+		d.keys[first-1].open = false
+		from++
+	}
+
+	delta := len(lines) - (end - start)
+	d.lines = spliceLines(d.lines, start, end, lines)
+
+	s := sort.Search(len(d.sections), func(i int) bool { return d.sections[i].header >= start }) - 1
+	keys, sections, err := d.scan(from, start+len(lines), d.sections[s].name)
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 && keys[len(keys)-1].end > start+len(lines) {
+		return d.parse()
+	}
+
+	for _, k := range d.keys[last:] {
+		k.line += delta
+		k.end += delta
+	}
+	for _, section := range d.sections[s+1:] {
+		section.header += delta
+	}
+
+	// the keys are replaced in place
+	removed := append([]*sectionKey{}, d.keys[first:last]...)
+	tail := len(d.keys) - last
+	for len(d.keys) < first+len(keys)+tail {
+		d.keys = append(d.keys, nil)
+	}
+	copy(d.keys[first+len(keys):], d.keys[last:last+tail])
+	copy(d.keys[first:], keys)
+	d.keys = d.keys[:first+len(keys)+tail]
+
+	if len(sections) > 0 {
+		d.sections = append(append(append([]*sectionSpan{}, d.sections[:s+1]...), sections...), d.sections[s+1:]...)
+	}
+
+	for _, k := range removed {
+		if d.index[k.name] == k {
+			delete(d.index, k.name)
+		}
+	}
+	for _, k := range keys {
+		if other, ok := d.index[k.name]; !ok || other.line > k.line {
+			d.index[k.name] = k
+		}
+	}
+
+	// a key that is present more than once is the first one of its name
+	// now, which is only known when the document is read again
+	for _, k := range removed {
+		if _, ok := d.index[k.name]; !ok {
+			return d.parse()
+		}
+	}
+
+	return nil
+}
+
+func (d *sectionDocument) String() string {
+	if len(d.lines) == 0 {
+		return ""
+	}
+
+	return strings.Join(d.lines, "\n") + "\n"
+}
+
+// yamlDocument is a YAML document of which the root is a mapping. Nested
+// block mappings are followed, other values, like sequences and block
+// scalars, are values as a whole.
+type yamlDocument struct {
+	lines []string
+
+	// the keys of the document, in the order of the document, and the first
+	// key of every key path, see yamlPath
+	nodes []*yamlNode
+	index map[string]*yamlNode
+}
+
+// yamlNode is a key of a yamlDocument, it spans lines[line:end]
+type yamlNode struct {
+	path   []string
+	name   string
+	indent int
+	line   int
+	end    int
+
+	// the node is a mapping, with children indented by `child`
+	mapping bool
+	child   int
+
+	// the value of a node that isn't a mapping, without its comment and
+	// with the common indentation of the following lines removed
+	value string
+}
+
+// yamlIndent will return the number of spaces `line` is indented with
+func yamlIndent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// yamlSkip reports whether the trimmed line `line` doesn't hold a key or a
+// value
+func yamlSkip(line string) bool {
+	return line == "" || strings.HasPrefix(line, "#") || line == "---" || line == "..."
+}
+
+// yamlItem reports whether the trimmed line `line` is an item of a
+// sequence
+func yamlItem(line string) bool {
+	return line == "-" || strings.HasPrefix(line, "- ")
+}
+
+// yamlKey will split the trimmed line `line` into a key of a mapping and
+// the rest of the line, it reports whether the line starts with a key
+func yamlKey(line string) (string, string, bool) {
+	if line == "" || yamlItem(line) || strings.ContainsRune("#[{", rune(line[0])) {
+		return "", "", false
+	}
+
+	if line[0] == '"' || line[0] == '\'' {
+		end := 1
+		for ; end < len(line) && line[end] != line[0]; end++ {
+			if line[0] == '"' && line[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(line) {
+			return "", "", false
+		}
+
+		rest := strings.TrimLeft(line[end+1:], " ")
+		if rest != ":" && !strings.HasPrefix(rest, ": ") {
+			return "", "", false
+		}
+
+		key := strings.ReplaceAll(line[1:end], "''", "'")
+		if line[0] == '"' {
+			unquoted, err := strconv.Unquote(line[:end+1])
+			if err != nil {
+				return "", "", false
+			}
+			key = unquoted
+		}
+
+		return key, strings.TrimSpace(rest[1:]), true
+	}
+
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == ':' && (i+1 == len(line) || line[i+1] == ' '):
+			return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:]), true
+		case line[i] == '#' && line[i-1] == ' ':
+			return "", "", false
+		}
+	}
+
+	return "", "", false
+}
+
+// yamlStripComment will remove the comment at the end of the value
+// `value`, comments in quoted strings are left as they are
+func yamlStripComment(value string) string {
+	var quote byte
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' && quote == '"' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case (ch == '"' || ch == '\'') && (i == 0 || value[i-1] == ' '):
+			quote = ch
+		case ch == '#' && (i == 0 || value[i-1] == ' '):
+			return strings.TrimSpace(value[:i])
+		}
+	}
+
+	return value
+}
+
+// yamlQuoteKey will quote `key` when it can't be written as it is
+func yamlQuoteKey(key string) string {
+	if key == "" || strings.TrimSpace(key) != key || strings.ContainsAny(key[:1], "-?:,[]{}#&*!|>'\"%@`") ||
+		strings.Contains(key, ": ") || strings.Contains(key, " #") || strings.HasSuffix(key, ":") ||
+		strconv.Quote(key) != `"`+key+`"` {
+		return strconv.Quote(key)
+	}
+
+	return key
+}
+
+// parse will read the keys of the document
+func (d *yamlDocument) parse() error {
+	nodes, err := d.scan(0, len(d.lines), nil, -1)
+	if err != nil {
+		return err
+	}
+
+	d.nodes = nodes
+	d.index = make(map[string]*yamlNode)
+	for _, node := range d.nodes {
+		if _, ok := d.index[node.name]; !ok {
+			d.index[node.name] = node
+		}
+	}
+
+	return nil
+}
+
+// scan will read the keys that start in lines[from:to], in the mappings
+// `parents`. The keys at the root are indented by `root`, or by the first
+// one when it's negative.
+func (d *yamlDocument) scan(from, to int, parents []*yamlNode, root int) ([]*yamlNode, error) {
+	var nodes []*yamlNode
+
+	parents = append([]*yamlNode{}, parents...)
+	for i := from; i < to; {
+		line := strings.TrimRight(d.lines[i], " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if yamlSkip(trimmed) {
+			i++
+			continue
+		}
+
+		indent := yamlIndent(line)
+		if line[indent] == '\t' {
+			return nil, fmt.Errorf("line %d is indented with a tab", i+1)
+		}
+
+		key, rest, ok := yamlKey(trimmed)
+		if !ok {
+			return nil, fmt.Errorf("line %d isn't a key of a mapping", i+1)
+		}
+		rest = yamlStripComment(rest)
+
+		for len(parents) > 0 && parents[len(parents)-1].indent >= indent {
+			parents = parents[:len(parents)-1]
+		}
+
+		var path []string
+		switch {
+		case len(parents) > 0 && parents[len(parents)-1].child == indent:
+			path = append(path, parents[len(parents)-1].path...)
+		case len(parents) > 0 || (root >= 0 && root != indent):
+			return nil, fmt.Errorf("line %d has an unexpected indentation", i+1)
+		default:
+			root = indent
+		}
+
+		node := &yamlNode{path: append(path, key), indent: indent, line: i}
+		node.name = yamlPath(node.path)
+
+		// the lines that are indented further belong to the node, just like
+		// a sequence that starts at the same indentation
+		node.end = i + 1
+		for j := i + 1; j < len(d.lines); j++ {
+			next := strings.TrimSpace(d.lines[j])
+			if yamlSkip(next) {
+				continue
+			}
+
+			nextIndent := yamlIndent(d.lines[j])
+			if nextIndent < indent || (nextIndent == indent && (rest != "" || !yamlItem(next))) {
+				break
+			}
+			node.end = j + 1
+		}
+
+		// a mapping continues with a key on the next line
+		for j := i + 1; j < node.end && rest == ""; j++ {
+			next := strings.TrimSpace(d.lines[j])
+			if yamlSkip(next) {
+				continue
+			}
+
+			if _, _, ok := yamlKey(next); ok && yamlIndent(d.lines[j]) > indent {
+				node.mapping = true
+				node.child = yamlIndent(d.lines[j])
+			}
+			break
+		}
+
+		if node.mapping {
+			parents = append(parents, node)
+			nodes = append(nodes, node)
+			i++
+			continue
+		}
+
+		node.value = yamlValue(rest, d.lines[i+1:node.end])
+		nodes = append(nodes, node)
+		i = node.end
+	}
+
+	return nodes, nil
+}
+
+// yamlPath will return the key path `path` as a single string
+func yamlPath(path []string) string {
+	var b strings.Builder
+	for _, key := range path {
+		b.WriteString(strconv.Quote(key))
+	}
+
+	return b.String()
+}
+
+// yamlValue will return the value of a key of which the rest of the line
+// is `rest` and the value continues on the lines `lines`
+func yamlValue(rest string, lines []string) string {
+	common := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" && (common < 0 || yamlIndent(line) < common) {
+			common = yamlIndent(line)
+		}
+	}
+
+	value := rest
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if yamlIndent(line) >= common {
+			line = line[common:]
+		} else {
+			line = strings.TrimLeft(line, " ")
+		}
+		value += "\n" + line
+	}
+
+	return value
+}
+
+// yamlRender will return the lines of the keys `path`, each one nested in
+// the previous one, with the value `value` for the last one
+func yamlRender(indent, step int, path []string, value string) []string {
+	var lines []string
+	for _, key := range path[:len(path)-1] {
+		lines = append(lines, strings.Repeat(" ", indent)+yamlQuoteKey(key)+":")
+		indent += step
+	}
+
+	values := strings.Split(value, "\n")
+	line := strings.Repeat(" ", indent) + yamlQuoteKey(path[len(path)-1]) + ":"
+	if values[0] != "" {
+		line += " " + values[0]
+	}
+	lines = append(lines, line)
+
+	for _, value := range values[1:] {
+		if value != "" {
+			value = strings.Repeat(" ", indent+step) + value
+		}
+		lines = append(lines, value)
+	}
+
+	return lines
+}
+
+// nested reports whether the first node of every parent of the key path
+// `path` is a mapping, a value that is present more than once is only read
+// where it's first defined
+func (d *yamlDocument) nested(path []string) bool {
+	for i := 1; i < len(path); i++ {
+		if node := d.index[yamlPath(path[:i])]; node != nil && !node.mapping {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (d *yamlDocument) Leaves() []Leaf {
+	var leaves []Leaf
+	for _, node := range d.nodes {
+		if !node.mapping && d.index[node.name] == node && d.nested(node.path) {
+			leaves = append(leaves, Leaf{Path: node.path, Value: node.value})
+		}
+	}
+
+	return leaves
+}
+
+func (d *yamlDocument) Get(path []string) (string, bool) {
+	node, ok := d.index[yamlPath(path)]
+	if !ok {
+		return "", false
+	}
+
+	return node.value, !node.mapping
+}
+
+func (d *yamlDocument) Set(path []string, value string) error {
+	if len(path) == 0 {
+		return errors.New("empty key path")
+	}
+
+	// the indentation of the nested mappings, and of the root
+	step, root := 2, -1
+	for _, node := range d.nodes {
+		if node.mapping {
+			step = node.child - node.indent
+			break
+		}
+	}
+	if len(d.nodes) > 0 {
+		root = d.nodes[0].indent
+	}
+
+	if node, ok := d.index[yamlPath(path)]; ok {
+		return d.splice(node.line, node.end, d.parents(node), root, yamlRender(node.indent, step, path[len(path)-1:], value))
+	}
+
+	// the deepest node on the way to the path
+	var node *yamlNode
+	for i := len(path) - 1; i > 0 && node == nil; i-- {
+		node = d.index[yamlPath(path[:i])]
+	}
+
+	if node == nil {
+		end := len(d.lines)
+		if len(d.nodes) > 0 {
+			end = 0
+			for _, node := range d.nodes {
+				if node.end > end {
+					end = node.end
+				}
+			}
+		}
+
+		if root < 0 {
+			root = 0
+		}
+		return d.splice(end, end, nil, root, yamlRender(root, step, path, value))
+	}
+
+	if !node.mapping {
+		// the value in the way is replaced by a mapping
+		return d.splice(node.line, node.end, d.parents(node), root, yamlRender(node.indent, step, path[len(node.path)-1:], value))
+	}
+
+	return d.splice(node.end, node.end, append(d.parents(node), node), root, yamlRender(node.child, step, path[len(node.path):], value))
+}
+
+// parents will return the mappings `node` is in, the outermost one first
+func (d *yamlDocument) parents(node *yamlNode) []*yamlNode {
+	var parents []*yamlNode
+	for _, other := range d.nodes {
+		for len(parents) > 0 && parents[len(parents)-1].indent >= other.indent {
+			parents = parents[:len(parents)-1]
+		}
+		if other == node {
+			break
+		}
+		if other.mapping {
+			parents = append(parents, other)
+		}
+	}
+
+	return parents
+}
+
+// splice will replace lines[start:end] of the document, that are in the
+// mappings `parents`, with `lines`. Only the keys in `lines` are read, the
+// ones after them are moved, unless the lines change how the rest of the
+// document is read.
+func (d *yamlDocument) splice(start, end int, parents []*yamlNode, root int, lines []string) error {
+	first := sort.Search(len(d.nodes), func(i int) bool { return d.nodes[i].line >= start })
+	last := sort.Search(len(d.nodes), func(i int) bool { return d.nodes[i].line >= end })
+
+	delta := len(lines) - (end - start)
+	d.lines = spliceLines(d.lines, start, end, lines)
+
+	nodes, err := d.scan(start, start+len(lines), parents, root)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if node.end > start+len(lines) {
+			return d.parse()
+		}
+	}
+
+	for _, node := range d.nodes[last:] {
+		node.line += delta
+		node.end += delta
+	}
+	for _, node := range parents {
+		node.end += delta
+	}
+
+	// the nodes are replaced in place
+	removed := append([]*yamlNode{}, d.nodes[first:last]...)
+	tail := len(d.nodes) - last
+	for len(d.nodes) < first+len(nodes)+tail {
+		d.nodes = append(d.nodes, nil)
+	}
+	copy(d.nodes[first+len(nodes):], d.nodes[last:last+tail])
+	copy(d.nodes[first:], nodes)
+	d.nodes = d.nodes[:first+len(nodes)+tail]
+
+	for _, node := range removed {
+		if d.index[node.name] == node {
+			delete(d.index, node.name)
+		}
+	}
+	for _, node := range nodes {
+		if other, ok := d.index[node.name]; !ok || other.line > node.line {
+			d.index[node.name] = node
+		}
+	}
+
+	// a key that is present more than once is the first one of its path
+	// now, which is only known when the document is read again
+	for _, node := range removed {
+		if _, ok := d.index[node.name]; !ok {
+			return d.parse()
+		}
+	}
+
+	return nil
+}
+
+func (d *yamlDocument) String() string {
+	if len(d.lines) == 0 {
+		return ""
+	}
+
+	return strings.Join(d.lines, "\n") + "\n"
+}
@@ -0,0 +1,67 @@
+//go:build go1.18
+
+package main
+
+import (
+	"testing"
+)
+
+// fuzzMergeDocument will check that merging the document `ours` into a
+// target in the format `format` is stable: merging it into the merged
+// document again doesn't report any keys
+func fuzzMergeDocument(f *testing.F, format string, seeds [][2]string) {
+	for _, seed := range seeds {
+		f.Add(seed[0], seed[1])
+	}
+
+	f.Fuzz(func(t *testing.T, ours, target string) {
+		merged, _, err := MergeDocument(format, ours, target)
+		if err != nil {
+			return
+		}
+
+		again, keys, err := MergeDocument(format, ours, merged)
+		if err != nil {
+			t.Fatalf("%q into %q: not able to merge into the merged document %q (%s)", ours, target, merged, err)
+		}
+
+		if len(keys) > 0 || again != merged {
+			t.Errorf("%q into %q: expected the merge to be stable, got the keys %v of %q", ours, target, keys, merged)
+		}
+	})
+}
+
+func FuzzMergeDocumentJSON(f *testing.F) {
+	fuzzMergeDocument(f, FormatJSON, [][2]string{
+		{"{\"editor\": {\"fontSize\": 14, \"rulers\": [80]}, \"theme\": \"dark\"}\n", "{\n    // set by the editor\n    \"editor\": {\"fontSize\": 12,},\n}\n"},
+		{"{\"a\": {\"b\": true}, \"c\": [1, 2]}", "{\n\t\"a\": 1, // old\n\t/* last */\n}"},
+		{"{\"a\": {\"b\": 1}}", ""},
+	})
+}
+
+func FuzzMergeDocumentYAML(f *testing.F) {
+	fuzzMergeDocument(f, FormatYAML, [][2]string{
+		{"editor:\n  font:\n    size: 14\nplugins:\n  - git\n", "editor:\n    font:\n        size: 12 # default\nplugins:\n- git\n"},
+		{"a:\n  b:\n    c: 1\nd: |\n  text\n", "a: 5\nz: 1\n"},
+		{"\"a: b\": 1\n", ""},
+		{"\"0\\n0\":", " "},
+		{"0:\n0:\n :", ""},
+	})
+}
+
+func FuzzMergeDocumentTOML(f *testing.F) {
+	fuzzMergeDocument(f, FormatTOML, [][2]string{
+		{"add_newline = false\n\n[git]\nformats = [\n  \"a\",\n]\n", "# starship\nadd_newline = true\n\n[character]\nsymbol = \"$ \"\n"},
+		{"[a]\ns = \"\"\"\nfirst\n\"\"\"\n", "[a]\n  s = \"\"\"\nsecond\n\"\"\"\n  t = 1\n"},
+		{"a.b.c = 1\nx.y = 2\n\n[a]\nb.d = 3\n", "[a.b]\nd = 0\n"},
+		{"0.=", "[0]"},
+	})
+}
+
+func FuzzMergeDocumentINI(f *testing.F) {
+	fuzzMergeDocument(f, FormatINI, [][2]string{
+		{"[user]\n\tname = Jane\n[remote \"origin\"]\n\turl = git@example.com:dot\n", "; written by git\n[user]\n\tname = John\n"},
+		{"a = x\n", "b = 1\\\n"},
+		{"[core]\n\teditor = vim ; comment\n", "[core]\neditor\n"},
+	})
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	tests := map[string]string{
+		"~/.config/Code/User/settings.json": FormatJSON,
+		"~/.config/app/config.yml":          FormatYAML,
+		"~/.config/starship.toml":           FormatTOML,
+		"~/.gitconfig":                      FormatINI,
+		"~/.bashrc":                         "",
+	}
+
+	for path, expected := range tests {
+		if format := DetectFormat(path); format != expected {
+			t.Errorf("%s: expected '%s', got '%s'", path, expected, format)
+		}
+	}
+}
+
+func TestMergeDocument(t *testing.T) {
+	tests := []struct {
+		name         string
+		format       string
+		ours, target string
+		expected     string
+		expectedKeys []string
+	}{
+		{
+			name:   "json",
+			format: FormatJSON,
+			ours:   "{\"editor\": {\"fontSize\": 14, \"rulers\": [80]}, \"theme\": \"dark\"}\n",
+			target: "{\n    // set by the editor\n    \"editor\": {\"fontSize\": 12, \"tabSize\": 4,},\n    \"window\": {\"zoom\": 1}\n}\n",
+			expected: "{\n    // set by the editor\n" +
+				"    \"editor\": {\"fontSize\": 14, \"tabSize\": 4, \"rulers\": [80],},\n" +
+				"    \"window\": {\"zoom\": 1},\n    \"theme\": \"dark\"\n}\n",
+			expectedKeys: []string{"editor.fontSize", "editor.rulers", "theme"},
+		},
+		{
+			name:         "json into an empty file",
+			format:       FormatJSON,
+			ours:         "{\"a\": {\"b\": 1}}",
+			target:       "",
+			expected:     "{\n  \"a\": {\n    \"b\": 1\n  }\n}\n",
+			expectedKeys: []string{"a.b"},
+		},
+		{
+			name:   "json with a value in the way",
+			format: FormatJSON,
+			ours:   "{\"a\": {\"b\": true}, \"c\": [1, 2]}",
+			target: "{\n\t\"a\": 1, // old\n\t/* last */\n}",
+			expected: "{\n\t\"a\": {\n\t\t\"b\": true\n\t}, // old\n\t/* last */\n" +
+				"\t\"c\": [\n\t\t1,\n\t\t2\n\t]\n}",
+			expectedKeys: []string{"a.b", "c"},
+		},
+		{
+			name:         "json without changes",
+			format:       FormatJSON,
+			ours:         "{\"a\": {\"b\": [1, 2]}}",
+			target:       "{\n  \"a\": {\"b\": [ 1,\n 2 ], \"c\": true}\n}\n",
+			expected:     "{\n  \"a\": {\"b\": [ 1,\n 2 ], \"c\": true}\n}\n",
+			expectedKeys: nil,
+		},
+		{
+			name:   "yaml",
+			format: FormatYAML,
+			ours:   "editor:\n  font:\n    size: 14\nplugins:\n  - git\n  - fzf\n",
+			target: "# written by the app\neditor:\n    font:\n        size: 12 # default\n        family: mono\n" +
+				"history: 100\nplugins:\n- git\n",
+			expected: "# written by the app\neditor:\n    font:\n        size: 14\n        family: mono\n" +
+				"history: 100\nplugins:\n    - git\n    - fzf\n",
+			expectedKeys: []string{"editor.font.size", "plugins"},
+		},
+		{
+			name:         "yaml with new mappings",
+			format:       FormatYAML,
+			ours:         "a:\n  b:\n    c: 1\nd: |\n  text\n",
+			target:       "a: 5\nz: 1\n",
+			expected:     "a:\n  b:\n    c: 1\nz: 1\nd: |\n  text\n",
+			expectedKeys: []string{"a.b.c", "d"},
+		},
+		{
+			name:   "toml",
+			format: FormatTOML,
+			ours:   "add_newline = false\n\n[character]\nsymbol = \"> \"\n\n[git]\nformats = [\n  \"a\",\n  \"b\",\n]\n",
+			target: "# starship\nadd_newline = true\n\n[character]\nsymbol = \"$ \" # prompt\n" +
+				"error = \"x\"\n",
+			expected: "# starship\nadd_newline = false\n\n[character]\nsymbol = \"> \"\n" +
+				"error = \"x\"\n\n[git]\nformats = [\n  \"a\",\n  \"b\",\n]\n",
+			expectedKeys: []string{"add_newline", "character.symbol", "git.formats"},
+		},
+		{
+			name:         "toml multi-line strings",
+			format:       FormatTOML,
+			ours:         "[a]\ns = \"\"\"\nfirst\n  second # not a comment\n\"\"\"\n",
+			target:       "[a]\n  s = \"\"\"\nfirst\nsecond\n\"\"\"\n  t = 1\n",
+			expected:     "[a]\n  s = \"\"\"\nfirst\n  second # not a comment\n\"\"\"\n  t = 1\n",
+			expectedKeys: []string{"a.s"},
+		},
+		{
+			name:         "toml dotted keys",
+			format:       FormatTOML,
+			ours:         "a.b.c = 1\nx.y = 2\n\n[a]\nb.d = 3\n",
+			target:       "[a.b]\nd = 0\n",
+			expected:     "x.y = 2\n[a.b]\nd = 3\nc = 1\n",
+			expectedKeys: []string{"a.b.c", "x.y", "a.b.d"},
+		},
+		{
+			name:         "yaml keys that have to be quoted",
+			format:       FormatYAML,
+			ours:         "\"a\\nb\": 1\n",
+			target:       "c: 2\n",
+			expected:     "c: 2\n\"a\\nb\": 1\n",
+			expectedKeys: []string{"a\nb"},
+		},
+		{
+			name:   "ini",
+			format: FormatINI,
+			ours:   "[user]\n\tname = Jane\n\temail = jane@example.com\n[remote \"origin\"]\n\turl = git@example.com:dot\n",
+			target: "; written by git\n[user]\n\tname = John\n[core]\n\teditor = vim\n",
+			expected: "; written by git\n[user]\n\tname = Jane\n\temail = jane@example.com\n[core]\n\teditor = vim\n" +
+				"\n[remote \"origin\"]\n\turl = git@example.com:dot\n",
+			expectedKeys: []string{"user.name", "user.email", "remote \"origin\".url"},
+		},
+		{
+			name:         "ini after a value that continues at the end",
+			format:       FormatINI,
+			ours:         "a = x\n",
+			target:       "b = 1\\\n",
+			expected:     "b = 1\\\n\na = x\n",
+			expectedKeys: []string{"a"},
+		},
+		{
+			name:         "ini value that continues at the end",
+			format:       FormatINI,
+			ours:         "b = 1\\\n",
+			target:       "a = x\nb = 2\nc = 3\n",
+			expected:     "a = x\nb = 1\\\n\nc = 3\n",
+			expectedKeys: []string{"b"},
+		},
+	}
+
+	for _, test := range tests {
+		merged, keys, err := MergeDocument(test.format, test.ours, test.target)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		if merged != test.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", test.name, test.expected, merged)
+		}
+
+		if !reflect.DeepEqual(keys, test.expectedKeys) {
+			t.Errorf("%s: expected the keys %v, got %v", test.name, test.expectedKeys, keys)
+		}
+
+		// merging again doesn't change anything
+		again, keys, err := MergeDocument(test.format, test.ours, merged)
+		if err != nil || again != merged || len(keys) > 0 {
+			t.Errorf("%s: expected the merge to be stable, got the keys %v (%v)", test.name, keys, err)
+		}
+	}
+}
+
+func TestCollectDocument(t *testing.T) {
+	tests := []struct {
+		name         string
+		format       string
+		ours, target string
+		expected     string
+		expectedKeys []string
+	}{
+		{
+			name:         "json",
+			format:       FormatJSON,
+			ours:         "{\n\t\"editor.fontSize\": 14,\n\t\"theme\": \"dark\"\n}\n",
+			target:       "{\"editor.fontSize\": 16, \"other\": 1}",
+			expected:     "{\n\t\"editor.fontSize\": 16,\n\t\"theme\": \"dark\"\n}\n",
+			expectedKeys: []string{"editor.fontSize"},
+		},
+		{
+			name:         "yaml",
+			format:       FormatYAML,
+			ours:         "# managed by dot\nfont:\n  size: 14 # points\n",
+			target:       "font:\n  size: 16\n  family: mono\n",
+			expected:     "# managed by dot\nfont:\n  size: 16\n",
+			expectedKeys: []string{"font.size"},
+		},
+		{
+			name:         "ini without changes",
+			format:       FormatINI,
+			ours:         "[core]\n\teditor = vim ; comment\n",
+			target:       "[core]\neditor = vim\n",
+			expected:     "[core]\n\teditor = vim ; comment\n",
+			expectedKeys: nil,
+		},
+	}
+
+	for _, test := range tests {
+		collected, keys, err := CollectDocument(test.format, test.ours, test.target)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		if collected != test.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", test.name, test.expected, collected)
+		}
+
+		if !reflect.DeepEqual(keys, test.expectedKeys) {
+			t.Errorf("%s: expected the keys %v, got %v", test.name, test.expectedKeys, keys)
+		}
+	}
+}
+
+func TestParseDocumentInvalid(t *testing.T) {
+	tests := []struct {
+		format, content string
+	}{
+		{FormatJSON, "[1, 2]"},
+		{FormatJSON, "{\"a\": 1} {}"},
+		{FormatYAML, "- a\n- b\n"},
+		{FormatYAML, "a:\n  b: 1\n c: 2\n"},
+		{FormatTOML, "[table\n"},
+		{FormatTOML, "key\n"},
+		{FormatTOML, "key = [1,\n"},
+		{FormatTOML, "a. = 1\n"},
+		{FormatTOML, "[a..b]\n"},
+		{"xml", "<a/>"},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseDocument(test.format, test.content); err == nil {
+			t.Errorf("%s: expected an error for %q", test.format, test.content)
+		}
+	}
+}
+
+// benchmarkDocument will return a document in the format `format` with
+// the keys `k0` to `k1999` in 20 tables, the ones `keep` reports are
+// kept, with the value `value`
+func benchmarkDocument(format string, keep func(i int) bool, value int) string {
+	var b strings.Builder
+	if format == FormatJSON {
+		b.WriteString("{\n")
+	}
+
+	for table := 0; table < 20; table++ {
+		switch format {
+		case FormatJSON:
+			if table > 0 {
+				b.WriteString(",\n")
+			}
+			fmt.Fprintf(&b, "  \"s%d\": {\"x\": 0", table)
+		case FormatYAML:
+			fmt.Fprintf(&b, "s%d:\n  x: 0\n", table)
+		default:
+			fmt.Fprintf(&b, "[s%d]\nx = 0\n", table)
+		}
+
+		for i := table; i < 2000; i += 20 {
+			if !keep(i) {
+				continue
+			}
+
+			switch format {
+			case FormatJSON:
+				fmt.Fprintf(&b, ", \"k%d\": %d", i, value)
+			case FormatYAML:
+				fmt.Fprintf(&b, "  k%d: %d\n", i, value)
+			default:
+				fmt.Fprintf(&b, "k%d = %d\n", i, value)
+			}
+		}
+
+		if format == FormatJSON {
+			b.WriteString("}")
+		}
+	}
+
+	if format == FormatJSON {
+		b.WriteString("\n}\n")
+	}
+	return b.String()
+}
+
+// BenchmarkMergeDocument will merge 2000 keys into a document that has
+// half of them, with other values
+func BenchmarkMergeDocument(b *testing.B) {
+	for _, format := range []string{FormatJSON, FormatYAML, FormatTOML, FormatINI} {
+		ours := benchmarkDocument(format, func(i int) bool { return true }, 1)
+		target := benchmarkDocument(format, func(i int) bool { return i%2 == 0 }, 2)
+
+		b.Run(format, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				_, keys, err := MergeDocument(format, ours, target)
+				if err != nil || len(keys) != 2000 {
+					b.Fatalf("expected 2000 keys to be merged, got %d (%v)", len(keys), err)
+				}
+			}
+		})
+	}
+}
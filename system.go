@@ -0,0 +1,304 @@
+// system.go will hold all the operations that have to do with system
+// entries, files outside of the home folder (e.g. in /etc) that are
+// installed as a copy with an explicit owner and mode.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SystemEntry describes a file that will be installed as a copy, instead of
+// being symlinked, because it is owned by the system. The contents of the
+// file reside in `files/[name]/[base]`, just like regular entries.
+type SystemEntry struct {
+	// absolute path to the location where the file will be installed
+	Path string `json:"path"`
+
+	// owner and group of the installed file, when empty they are left to
+	// the privilege helper (usually root)
+	Owner string `json:"owner,omitempty"`
+	Group string `json:"group,omitempty"`
+
+	// octal file mode of the installed file, e.g. "0644"
+	Mode string `json:"mode,omitempty"`
+}
+
+// FileMode will return the parsed Mode of the entry, it defaults to 0644.
+func (e *SystemEntry) FileMode() (os.FileMode, error) {
+	if e.Mode == "" {
+		return 0644, nil
+	}
+
+	mode, err := strconv.ParseUint(e.Mode, 8, 32)
+	if err != nil || mode > 07777 {
+		return 0, fmt.Errorf("invalid mode: %s", e.Mode)
+	}
+
+	return os.FileMode(mode), nil
+}
+
+// PrivilegeHelper will create commands that run with elevated privileges.
+type PrivilegeHelper interface {
+	Command(name string, arg ...string) *exec.Cmd
+}
+
+// CommandPrefix is a PrivilegeHelper that prefixes every command with
+// another command, e.g. `sudo` or `doas`. An empty CommandPrefix will run
+// commands as is, which is what we want when we're already root.
+type CommandPrefix []string
+
+// Command implements the PrivilegeHelper interface.
+func (p CommandPrefix) Command(name string, arg ...string) *exec.Cmd {
+	if len(p) == 0 {
+		return exec.Command(name, arg...)
+	}
+
+	args := append(append(append([]string{}, p[1:]...), name), arg...)
+	return exec.Command(p[0], args...)
+}
+
+// NewPrivilegeHelper will return the PrivilegeHelper by `name`, this can be
+// either `sudo`, `doas`, `none` or `auto`. With `auto` no helper is used when
+// we're already root, otherwise sudo or doas is used, whichever is
+// available.
+func NewPrivilegeHelper(name string) (PrivilegeHelper, error) {
+	switch name {
+	case "none":
+		return CommandPrefix{}, nil
+	case "sudo", "doas":
+		if _, err := exec.LookPath(name); err != nil {
+			return nil, fmt.Errorf("not able to find %s", name)
+		}
+		return CommandPrefix{name}, nil
+	case "", "auto":
+		if os.Geteuid() == 0 {
+			return CommandPrefix{}, nil
+		}
+
+		for _, helper := range []string{"sudo", "doas"} {
+			if _, err := exec.LookPath(helper); err == nil {
+				return CommandPrefix{helper}, nil
+			}
+		}
+
+		return nil, errors.New("not able to find sudo or doas")
+	default:
+		return nil, fmt.Errorf("unknown privilege helper: %s", name)
+	}
+}
+
+// SystemInstaller will install system entries.
+type SystemInstaller struct {
+	// all paths of system entries will be placed inside Root, this is "/"
+	// for a real system but can be pointed to a fake root directory
+	Root string
+
+	// helper that is used to run the commands that install the files
+	Helper PrivilegeHelper
+
+	// when set, nothing will be installed only reported
+	DryRun bool
+}
+
+// Install will install the file `src` at the location of the system entry
+// `e`, it'll return false when the file was already up to date.
+func (i *SystemInstaller) Install(src string, e *SystemEntry) (bool, error) {
+	if !filepath.IsAbs(e.Path) {
+		return false, fmt.Errorf("%s is not an absolute path", e.Path)
+	}
+
+	mode, err := e.FileMode()
+	if err != nil {
+		return false, err
+	}
+
+	dst := filepath.Join(i.Root, e.Path)
+
+	upToDate, err := isInstalled(src, dst, mode, e.Owner, e.Group)
+	if err != nil {
+		return false, err
+	}
+
+	if upToDate {
+		return false, nil
+	}
+
+	// install -D will create the leading directories of dst
+	args := []string{"-D", "-m", fmt.Sprintf("%04o", mode)}
+	if e.Owner != "" {
+		args = append(args, "-o", e.Owner)
+	}
+	if e.Group != "" {
+		args = append(args, "-g", e.Group)
+	}
+	args = append(args, src, dst)
+
+	if i.DryRun {
+		PrintBody(fmt.Sprintf("Would run: install %s", strings.Join(args, " ")))
+		return true, nil
+	}
+
+	output, err := i.Helper.Command("install", args...).CombinedOutput()
+	if err != nil {
+		return false, fmt.Errorf("not able to install %s: %s (%s)",
+			dst, strings.TrimSpace(string(output)), err)
+	}
+
+	return true, nil
+}
+
+// isInstalled reports whether `dst` has the same contents as `src`, and
+// has the expected mode, owner and group.
+func isInstalled(src, dst string, mode os.FileMode, owner, group string) (bool, error) {
+	want, err := ioutil.ReadFile(src)
+	if err != nil {
+		return false, err
+	}
+
+	f, err := os.Stat(dst)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if f.Mode().Perm() != mode.Perm() {
+		return false, nil
+	}
+
+	if uid, gid, ok := fileOwner(f); ok {
+		if owner != "" {
+			u, err := user.Lookup(owner)
+			if err != nil {
+				return false, err
+			}
+			if u.Uid != strconv.Itoa(uid) {
+				return false, nil
+			}
+		}
+
+		if group != "" {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return false, err
+			}
+			if g.Gid != strconv.Itoa(gid) {
+				return false, nil
+			}
+		}
+	}
+
+	got, err := ioutil.ReadFile(dst)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(want, got), nil
+}
+
+// SyncSystemFiles will install all the system entries from the config `c`
+// using the installer `i`.
+func SyncSystemFiles(c *Config, i *SystemInstaller) {
+	if len(c.System) == 0 {
+		return
+	}
+
+	PrintHeader("Syncing system files ...")
+
+	for _, name := range c.SortedSystemNames() {
+		e := c.System[name]
+		src := c.Paths(name).Repo
+
+		installed, err := i.Install(src, e)
+		if err != nil {
+			PrintBodyError(fmt.Sprintf("%s: %s", name, err))
+			continue
+		}
+
+		if installed && !i.DryRun {
+			PrintBody(fmt.Sprintf("Installed: %s", name))
+		} else if !installed {
+			PrintBody(fmt.Sprintf("%s is up to date", name))
+		}
+	}
+}
+
+// TrackSystemFile will add the file `fullPath` as system entry `name`, the
+// file will be copied into the files folder and left untouched otherwise.
+// When `owner`, `group` or `mode` are empty they are taken from the file.
+func TrackSystemFile(name, fullPath, owner, group, mode string) error {
+	c, err := NewConfig(PathDotConfig)
+	if err != nil {
+		return errors.New("not able to find .dotconfig")
+	}
+
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+
+	if _, ok := c.Files[name]; ok {
+		return fmt.Errorf("'%s' is already being tracked", name)
+	}
+	if _, ok := c.System[name]; ok {
+		return fmt.Errorf("'%s' is already being tracked", name)
+	}
+
+	f, err := os.Stat(fullPath)
+	if err != nil {
+		return err
+	}
+
+	if f.IsDir() {
+		return fmt.Errorf("%s is a directory, only files can be system entries", fullPath)
+	}
+
+	if mode == "" {
+		mode = fmt.Sprintf("%04o", f.Mode().Perm())
+	}
+
+	if uid, gid, ok := fileOwner(f); ok {
+		if u, err := user.LookupId(strconv.Itoa(uid)); owner == "" && err == nil {
+			owner = u.Username
+		}
+		if g, err := user.LookupGroupId(strconv.Itoa(gid)); group == "" && err == nil {
+			group = g.Name
+		}
+	}
+
+	e := &SystemEntry{
+		Path:  fullPath,
+		Owner: owner,
+		Group: group,
+		Mode:  mode,
+	}
+
+	if _, err := e.FileMode(); err != nil {
+		return err
+	}
+
+	dst := c.ResolvePaths(name, fullPath).Repo
+	PrintBody(fmt.Sprintf("Copying %s to %s", fullPath, dst))
+
+	err = MakeAndCopyToDir(fullPath, dst)
+	if err != nil {
+		return err
+	}
+
+	if c.System == nil {
+		c.System = make(map[string]*SystemEntry)
+	}
+	c.System[name] = e
+
+	return c.Save()
+}
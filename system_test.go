@@ -0,0 +1,157 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// recordingHelper is a PrivilegeHelper that records the commands instead of
+// running them
+type recordingHelper struct {
+	commands [][]string
+}
+
+func (h *recordingHelper) Command(name string, arg ...string) *exec.Cmd {
+	h.commands = append(h.commands, append([]string{name}, arg...))
+	return exec.Command("true")
+}
+
+func TestSystemEntryFileMode(t *testing.T) {
+	tests := []struct {
+		mode     string
+		fileMode os.FileMode
+		err      bool
+	}{
+		{"", 0644, false},
+		{"0600", 0600, false},
+		{"755", 0755, false},
+		{"0999", 0, true},
+		{"rw-r--r--", 0, true},
+	}
+
+	for _, test := range tests {
+		e := &SystemEntry{Mode: test.mode}
+		fileMode, err := e.FileMode()
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error: %v", test.mode, err)
+		}
+
+		if fileMode != test.fileMode {
+			t.Errorf("%s: expected %o, got %o", test.mode, test.fileMode, fileMode)
+		}
+	}
+}
+
+func TestCommandPrefix(t *testing.T) {
+	cmd := CommandPrefix{"sudo", "-n"}.Command("install", "a", "b")
+	if !reflect.DeepEqual(cmd.Args, []string{"sudo", "-n", "install", "a", "b"}) {
+		t.Errorf("unexpected arguments: %v", cmd.Args)
+	}
+
+	cmd = CommandPrefix{}.Command("install", "a", "b")
+	if !reflect.DeepEqual(cmd.Args, []string{"install", "a", "b"}) {
+		t.Errorf("unexpected arguments: %v", cmd.Args)
+	}
+}
+
+// Test installing a system entry in a fake root directory
+func TestSystemInstallerFakeRoot(t *testing.T) {
+	if _, err := exec.LookPath("install"); err != nil {
+		t.Skip("install not available")
+	}
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	src := filepath.Join(tempDir, "99-dot.conf")
+	ioutil.WriteFile(src, []byte("vm.swappiness = 10\n"), 0644)
+
+	root := filepath.Join(tempDir, "root")
+	i := &SystemInstaller{Root: root, Helper: CommandPrefix{}}
+	e := &SystemEntry{Path: "/etc/sysctl.d/99-dot.conf", Mode: "0600"}
+
+	installed, err := i.Install(src, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !installed {
+		t.Error("expected file to be installed")
+	}
+
+	dst := filepath.Join(root, "etc/sysctl.d/99-dot.conf")
+	f, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Mode().Perm() != 0600 {
+		t.Errorf("unexpected mode: %o", f.Mode().Perm())
+	}
+
+	// a second install should find the file up to date
+	installed, err = i.Install(src, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if installed {
+		t.Error("expected file to be up to date")
+	}
+
+	// a dry run shouldn't change the file
+	ioutil.WriteFile(src, []byte("vm.swappiness = 60\n"), 0644)
+	i.DryRun = true
+
+	installed, err = i.Install(src, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !installed {
+		t.Error("expected file to be reported as installed")
+	}
+
+	content, _ := ioutil.ReadFile(dst)
+	if string(content) != "vm.swappiness = 10\n" {
+		t.Errorf("dry run changed the file: %q", content)
+	}
+}
+
+// Test that the privilege helper is used with the correct arguments
+func TestSystemInstallerHelper(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	src := filepath.Join(tempDir, "hosts")
+	ioutil.WriteFile(src, []byte("127.0.0.1 localhost\n"), 0644)
+
+	h := &recordingHelper{}
+	i := &SystemInstaller{Root: filepath.Join(tempDir, "root"), Helper: h}
+	e := &SystemEntry{Path: "/etc/hosts", Owner: "root", Group: "root"}
+
+	_, err = i.Install(src, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]string{{
+		"install", "-D", "-m", "0644", "-o", "root", "-g", "root",
+		src, filepath.Join(tempDir, "root/etc/hosts"),
+	}}
+	if !reflect.DeepEqual(h.commands, expected) {
+		t.Errorf("unexpected commands: %v", h.commands)
+	}
+
+	// relative paths aren't allowed
+	_, err = i.Install(src, &SystemEntry{Path: "etc/hosts"})
+	if err == nil {
+		t.Error("expected an error for a relative path")
+	}
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
-	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	homedir "github.com/mitchellh/go-homedir"
@@ -18,12 +20,86 @@ func PrintHeader(text string) {
 
 // PrintBody will print out a colourful body given a string
 func PrintBody(text string) {
-	fmt.Printf("... %s\n", text)
+	FprintBody(os.Stdout, text)
 }
 
 // PrintBodyError will print out a colourful error given a string
 func PrintBodyError(text string) {
-	fmt.Printf("... ERROR: %s\n", text)
+	FprintBodyError(os.Stdout, text)
+}
+
+// FprintBody will write a colourful body given a string to `w`
+func FprintBody(w io.Writer, text string) {
+	fmt.Fprintf(w, "... %s\n", text)
+}
+
+// FprintBodyError will write a colourful error given a string to `w`
+func FprintBodyError(w io.Writer, text string) {
+	fmt.Fprintf(w, "... ERROR: %s\n", text)
+}
+
+// WriteFileAtomic will write `data` to the file `path`, by writing it to a
+// temporary file in the same folder, syncing it to disk and renaming it over
+// `path`. Readers will either see the old or the new contents, never a
+// partial file.
+func WriteFileAtomic(path string, data []byte, mode os.FileMode) error {
+	dir := filepath.Dir(path)
+
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+
+	// remove the temporary file when something goes wrong
+	tmpPath := f.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	// try to persist the rename itself, the file is already in place so
+	// this is best effort
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
+
+// StringList is a flag.Value that collects every occurrence of a flag, e.g.
+// `-exclude '*.swp' -exclude Cache/`
+type StringList []string
+
+// String implements the flag.Value interface
+func (l *StringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set implements the flag.Value interface
+func (l *StringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
 
 // HomeDir return the home directory of the logged in user.
@@ -36,58 +112,166 @@ func HomeDir() string {
 	return dir
 }
 
-// GetRelativePathFromCwd will remove the home folder from the current working
-// directory:
+// GetRelativePath will remove the home folder from the argument `fullPath`:
 //
-// `/home/jpbruinsslot/dotfiles/` will become `dotfiles/`
-func GetRelativePathFromCwd() (string, error) {
-	// get current working directory, this returns absolute path
-	currentWorkingDir, err := os.Getwd()
-	if err != nil {
+// `/home/jpbruinsslot/.config/nvim` will become `.config/nvim`
+//
+// An error is returned when `fullPath` doesn't reside in the home folder.
+func GetRelativePath(fullPath string) (string, error) {
+	relPath, err := filepath.Rel(HomeDir(), fullPath)
+	if err != nil || !filepath.IsAbs(fullPath) || IsOutsideDir(relPath) {
+		err := fmt.Errorf("%s is not located in the home folder", fullPath)
 		return "", err
 	}
 
-	// remove HomeDir() from the currentWorkingDir to get relative path
-	relPath := strings.Split(currentWorkingDir, HomeDir())
+	return relPath, nil
+}
 
-	if len(relPath) != 2 {
-		err := errors.New("not able to uncover relative path")
-		return "", err
-	} else {
-		return relPath[1], nil
+// IsOutsideDir reports whether the relative path `relPath`, as returned by
+// filepath.Rel, points outside of the directory it is relative to.
+func IsOutsideDir(relPath string) bool {
+	return relPath == ".." ||
+		strings.HasPrefix(relPath, ".."+string(filepath.Separator))
+}
+
+// IsHomePath reports whether the absolute path `fullPath` resides in the home
+// folder.
+func IsHomePath(fullPath string) bool {
+	_, err := GetRelativePath(fullPath)
+	return err == nil
+}
+
+// IsInsideDir reports whether the absolute path `path` is located inside
+// the folder `dir`, `dir` itself isn't inside of it.
+func IsInsideDir(dir, path string) bool {
+	if !filepath.IsAbs(dir) || !filepath.IsAbs(path) {
+		return false
 	}
+
+	relPath, err := filepath.Rel(dir, path)
+	return err == nil && relPath != "." && !IsOutsideDir(relPath)
 }
 
-// GetRelativePath will remove the home folder from the argument `fullPath`:
+// ExpandPath will turn a path as it is stored in the .dotconfig file into an
+// absolute path. Paths starting with `~` are relative to the home folder,
+// all other paths are absolute:
 //
-// `/home/jpbruinsslot/.config/nvim` will become `.config/nvim`
-func GetRelativePath(fullPath string) (string, error) {
-	relPath := strings.Split(fullPath, HomeDir())
+// `~/.config/nvim` will become `/home/jpbruinsslot/.config/nvim`
+// `/etc/hosts` will stay `/etc/hosts`
+func ExpandPath(path string) string {
+	if path == "~" {
+		return HomeDir()
+	}
 
-	if len(relPath) != 2 {
-		err := errors.New("not able to uncover relative path")
-		return "", err
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(HomeDir(), path[2:])
+	}
+
+	return filepath.Clean(path)
+}
+
+// ContractPath is the inverse of ExpandPath, it will turn the absolute path
+// `fullPath` into a path that can be stored in the .dotconfig file:
+//
+// `/home/jpbruinsslot/.config/nvim` will become `~/.config/nvim`
+// `/etc/hosts` will stay `/etc/hosts`
+func ContractPath(fullPath string) string {
+	relPath, err := GetRelativePath(fullPath)
+	if err != nil {
+		return filepath.Clean(fullPath)
+	}
+
+	if relPath == "." {
+		return "~"
+	}
+
+	return "~/" + filepath.ToSlash(relPath)
+}
+
+// CheckTarget will check whether dot is allowed to manage the absolute path
+// `fullPath`. Paths outside the home folder, e.g. in /etc, are only allowed
+// when `allowSystem` is set, because managing them affects the whole system
+// and will most likely need elevated privileges.
+func CheckTarget(fullPath string, allowSystem bool) error {
+	if !filepath.IsAbs(fullPath) {
+		return fmt.Errorf("%s is not an absolute path", fullPath)
+	}
+
+	if strings.ContainsRune(fullPath, 0) {
+		return fmt.Errorf("%q contains a NUL character", fullPath)
+	}
+
+	// moving these into the repository would take everything with it
+	fullPath = filepath.Clean(fullPath)
+	if fullPath == filepath.Dir(fullPath) || fullPath == HomeDir() {
+		return fmt.Errorf("%s can't be managed, only the files and folders inside of it", fullPath)
+	}
+
+	if !IsHomePath(fullPath) && !allowSystem {
+		return fmt.Errorf("%s is located outside the home folder, use "+
+			"-allow-system to manage it", fullPath)
 	}
 
-	return relPath[1], nil
+	return nil
+}
+
+// GitInit will execute the command git init in `dir` and create a .gitignore
+// file that makes sure the backup folder won't end up in the repository.
+func GitInit(dir string) error {
+	PrintBody(fmt.Sprintf("Initializing git repository: %s", dir))
+
+	cmd := exec.Command("git", "init")
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("not able to initialize git repository: %s (%s)",
+			strings.TrimSpace(string(output)), err)
+	}
+
+	// add the backup folder to .gitignore, keep existing entries
+	pathGitIgnore := filepath.Join(dir, ".gitignore")
+	content, err := ioutil.ReadFile(pathGitIgnore)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == "backup/" {
+			return nil
+		}
+	}
+
+	if len(content) > 0 && !strings.HasSuffix(string(content), "\n") {
+		content = append(content, '\n')
+	}
+	content = append(content, []byte("backup/\n")...)
+
+	PrintBody(fmt.Sprintf("Creating file: %s", pathGitIgnore))
+	return ioutil.WriteFile(pathGitIgnore, content, 0644)
 }
 
 // GitCommitPush will execute the command git commit -a -m [message] and
 // git push origin. This function will be called when a user specifies it
 // wants to commit the changes made to its repository in the form of the
 // `-p` flag used in combination with the `dot add` and `dot rm` commands.
-func GitCommitPush(name, action string) {
-	// load config
-	c, err := NewConfig(PathDotConfig)
-	if err != nil {
-		message := fmt.Sprintf("not able to load config file. Make sure the " +
-			".dotconfig file is present and points to the correct location")
-		PrintBodyError(message)
-		return
+// `dir` is the folder of the repository, when it's empty the repository in
+// DotPath is used.
+func GitCommitPush(dir, name, action string) {
+	if dir == "" {
+		// load config
+		c, err := NewConfig(PathDotConfig)
+		if err != nil {
+			message := fmt.Sprintf("not able to load config file. Make sure the " +
+				".dotconfig file is present and points to the correct location")
+			PrintBodyError(message)
+			return
+		}
+
+		dir = c.Root()
 	}
 
-	// change current working directory to DotPath
-	os.Chdir(c.DotPath)
+	// change current working directory to the repository
+	os.Chdir(dir)
 
 	PrintHeader("Committing changes to repository ...")
 
@@ -114,6 +298,12 @@ func GitCommitPush(name, action string) {
 		commitMessage = fmt.Sprintf("%s: added %s for tracking", name, name)
 	case "rm":
 		commitMessage = fmt.Sprintf("%s: removed %s from tracking", name, name)
+	case "mv":
+		commitMessage = fmt.Sprintf("%s: moved %s", name, name)
+	case "collect":
+		commitMessage = fmt.Sprintf("%s: collected the changed keys of %s", name, name)
+	default:
+		commitMessage = fmt.Sprintf("%s: updated %s", name, name)
 	}
 
 	// add commitMessage to the commitArgs
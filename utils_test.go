@@ -0,0 +1,101 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGetRelativePath(t *testing.T) {
+	tests := []struct {
+		fullPath string
+		relPath  string
+		err      bool
+	}{
+		{filepath.Join(HomeDir(), ".config/nvim"), ".config/nvim", false},
+		{filepath.Join(HomeDir(), ".vimrc") + "/", ".vimrc", false},
+		{HomeDir(), ".", false},
+		{"/etc/hosts", "", true},
+		{HomeDir() + "-other/.vimrc", "", true},
+		{".vimrc", "", true},
+	}
+
+	for _, test := range tests {
+		relPath, err := GetRelativePath(test.fullPath)
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error: %v", test.fullPath, err)
+		}
+
+		if relPath != test.relPath {
+			t.Errorf("%s: expected %s, got %s", test.fullPath, test.relPath, relPath)
+		}
+	}
+}
+
+func TestExpandAndContractPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		fullPath string
+	}{
+		{"~", HomeDir()},
+		{"~/.vimrc", filepath.Join(HomeDir(), ".vimrc")},
+		{"~/.config/nvim", filepath.Join(HomeDir(), ".config/nvim")},
+		{"/etc/hosts", "/etc/hosts"},
+		{"/srv/dotfiles", "/srv/dotfiles"},
+	}
+
+	for _, test := range tests {
+		if fullPath := ExpandPath(test.path); fullPath != test.fullPath {
+			t.Errorf("ExpandPath(%s): expected %s, got %s", test.path, test.fullPath, fullPath)
+		}
+
+		if path := ContractPath(test.fullPath); path != test.path {
+			t.Errorf("ContractPath(%s): expected %s, got %s", test.fullPath, test.path, path)
+		}
+	}
+}
+
+func TestCheckTarget(t *testing.T) {
+	if err := CheckTarget(filepath.Join(HomeDir(), ".vimrc"), false); err != nil {
+		t.Error(err)
+	}
+
+	if err := CheckTarget("/etc/hosts", false); err == nil {
+		t.Error("expected an error for a path outside the home folder")
+	}
+
+	if err := CheckTarget("/etc/hosts", true); err != nil {
+		t.Error(err)
+	}
+
+	if err := CheckTarget(".vimrc", true); err == nil {
+		t.Error("expected an error for a relative path")
+	}
+
+	for _, path := range []string{"/", HomeDir(), HomeDir() + "/", "/home/user/\x00"} {
+		if err := CheckTarget(path, true); err == nil {
+			t.Errorf("%q: expected an error", path)
+		}
+	}
+}
+
+func TestIsInsideDir(t *testing.T) {
+	tests := []struct {
+		dir, path string
+		expected  bool
+	}{
+		{"/srv/dotfiles/files", "/srv/dotfiles/files/vim", true},
+		{"/srv/dotfiles/files", "/srv/dotfiles/files/vim/../nvim", true},
+		{"/srv/dotfiles/files", "/srv/dotfiles/files", false},
+		{"/srv/dotfiles/files", "/srv/dotfiles/files/", false},
+		{"/srv/dotfiles/files", "/srv/dotfiles/files/..", false},
+		{"/srv/dotfiles/files", "/srv/dotfiles/files/../backup", false},
+		{"/srv/dotfiles/files", "/srv/dotfiles/filesystem", false},
+		{"/srv/dotfiles/files", "files/vim", false},
+	}
+
+	for _, test := range tests {
+		if inside := IsInsideDir(test.dir, test.path); inside != test.expected {
+			t.Errorf("%s, %s: expected %t", test.dir, test.path, test.expected)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"syscall"
+)
+
+// copyXattrs will copy the extended attributes of `src` to `dst`. Attributes
+// that we aren't permitted to set, or that aren't supported by the file
+// system of `dst`, are skipped.
+func copyXattrs(src, dst string) error {
+	size, err := syscall.Listxattr(src, nil)
+	if err != nil || size == 0 {
+		// file system doesn't support extended attributes
+		return nil
+	}
+
+	list := make([]byte, size)
+	size, err = syscall.Listxattr(src, list)
+	if err != nil {
+		return nil
+	}
+
+	for _, name := range bytes.Split(list[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+
+		size, err := syscall.Getxattr(src, string(name), nil)
+		if err != nil {
+			continue
+		}
+
+		value := make([]byte, size)
+		size, err = syscall.Getxattr(src, string(name), value)
+		if err != nil {
+			continue
+		}
+
+		err = syscall.Setxattr(dst, string(name), value[:size], 0)
+		if err != nil && !isXattrSkippable(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isXattrSkippable(err error) bool {
+	return errors.Is(err, syscall.EPERM) ||
+		errors.Is(err, syscall.EACCES) ||
+		errors.Is(err, syscall.ENOTSUP) ||
+		errors.Is(err, syscall.EOPNOTSUPP)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// Test if CopyFile will preserve extended attributes
+func TestCopyFileXattrs(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	src := filepath.Join(tempDir, "src")
+	ioutil.WriteFile(src, []byte("file"), 0644)
+
+	err = syscall.Setxattr(src, "user.dot", []byte("tracked"), 0)
+	if err != nil {
+		t.Skip("extended attributes not supported")
+	}
+
+	dst := filepath.Join(tempDir, "dst")
+	err = CopyFile(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value := make([]byte, 64)
+	size, err := syscall.Getxattr(dst, "user.dot", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(value[:size]) != "tracked" {
+		t.Errorf("unexpected value: %s", value[:size])
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// copyXattrs is a no-op on platforms where the syscall package doesn't
+// provide access to extended attributes.
+func copyXattrs(src, dst string) error {
+	return nil
+}